@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"gitlab.kardinal.ai/coretech/esther/logging"
+)
+
+const (
+	// PrincipalKey is the gin context key holding the authenticated principal name
+	PrincipalKey = "principal"
+	// TenantKey is the gin context key holding the resolved tenant
+	TenantKey = "tenant"
+	// TenantHeader is the header used to select a tenant when it is not resolved from a token
+	TenantHeader = "X-Tenant-ID"
+	// ActorHeader is the header declaring on behalf of whom a request is done, it is recorded but not authenticated
+	ActorHeader = "X-Actor"
+	// TenantPrincipalPrefix prefixes the principal of the requests authenticated by a tenant token
+	TenantPrincipalPrefix = "tenant:"
+
+	bearerPrefix = "Bearer "
+)
+
+var (
+	adminTokens       []namedToken
+	tenantTokens      []namedToken
+	tenantRequireAuth bool
+	trustedProxies    []string
+	tenantIDPattern   = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+)
+
+type namedToken struct {
+	name  string
+	token []byte
+}
+
+func init() {
+	initEnv()
+}
+
+func initEnv() []string {
+	errors := []string{}
+	trustedProxies = nil
+
+	envAdminTokens := "ADMIN_TOKENS"
+	adminTokens, errors = parseTokens(envAdminTokens, errors)
+	if len(adminTokens) == 0 {
+		logging.Logger.WithField("env", envAdminTokens).Warn("No admin token is set, the admin API is disabled")
+	}
+
+	envTenantTokens := "TENANT_TOKENS"
+	tenantTokens, errors = parseTokens(envTenantTokens, errors)
+	for _, tenant := range tenantTokens {
+		if !ValidTenantID(tenant.name) {
+			errors = append(errors, fmt.Sprintf("%s contains an invalid tenant: %s", envTenantTokens, tenant.name))
+		}
+	}
+	tenantRequireAuth = os.Getenv("TENANT_REQUIRE_AUTH") == "true"
+
+	trustedProxies = splitList(os.Getenv("TRUSTED_PROXIES"))
+	logging.Logger.WithFields(logging.LogFields{
+		"admins":            len(adminTokens),
+		"tenants":           len(tenantTokens),
+		"tenantRequireAuth": tenantRequireAuth,
+		"trustedProxies":    trustedProxies,
+	}).Info("The authentication environment has been set")
+
+	if len(errors) > 0 {
+		logging.Logger.WithField("errors", errors).Error("The authentication environment is not properly set")
+	}
+	return errors
+}
+
+// TrustedProxies returns the networks allowed to set the client IP through forwarded headers
+func TrustedProxies() []string {
+	return trustedProxies
+}
+
+// AuthenticateAdmin returns the name of the admin owning the bearer token of the given Authorization header
+func AuthenticateAdmin(authorization string) (string, error) {
+	if !strings.HasPrefix(authorization, bearerPrefix) {
+		return "", fmt.Errorf("A bearer token is required")
+	}
+	name := matchToken(adminTokens, authorization)
+	if name == "" {
+		return "", fmt.Errorf("The bearer token is not valid")
+	}
+	return name, nil
+}
+
+// AuthenticateTenant returns the principal owning the tenant bearer token of the given Authorization header, if any
+func AuthenticateTenant(authorization string) string {
+	if tenant := matchToken(tenantTokens, authorization); tenant != "" {
+		return TenantPrincipalPrefix + tenant
+	}
+	return ""
+}
+
+// ResolveTenant returns the tenant of a request from its bearer token, or else from its tenant header.
+// The default tenant ("") is returned when neither is set and authentication is not required.
+func ResolveTenant(authorization string, header string) (string, error) {
+	if tenant := matchToken(tenantTokens, authorization); tenant != "" {
+		if header != "" && header != tenant {
+			return "", fmt.Errorf("The token of tenant %s cannot be used for tenant %s", tenant, header)
+		}
+		return tenant, nil
+	}
+	if tenantRequireAuth {
+		return "", fmt.Errorf("A valid tenant bearer token is required")
+	}
+	if header != "" && !ValidTenantID(header) {
+		return "", fmt.Errorf("%s is not a valid tenant identifier", header)
+	}
+	return header, nil
+}
+
+// ValidTenantID checks that a tenant identifier can be used to name databases and collections
+func ValidTenantID(tenantID string) bool {
+	return tenantIDPattern.MatchString(tenantID)
+}
+
+// Principal returns the name of the authenticated principal of the request, if any
+func Principal(c *gin.Context) string {
+	return c.GetString(PrincipalKey)
+}
+
+// Tenant returns the tenant resolved for the request
+func Tenant(c *gin.Context) string {
+	return c.GetString(TenantKey)
+}
+
+func parseTokens(env string, errors []string) ([]namedToken, []string) {
+	tokens := []namedToken{}
+	for _, entry := range splitList(os.Getenv(env)) {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			errors = append(errors, fmt.Sprintf("%s contains an invalid entry, expected <name>:<token>", env))
+			continue
+		}
+		tokens = append(tokens, namedToken{name: parts[0], token: []byte(parts[1])})
+	}
+	return tokens, errors
+}
+
+func matchToken(tokens []namedToken, authorization string) string {
+	if !strings.HasPrefix(authorization, bearerPrefix) {
+		return ""
+	}
+	token := []byte(strings.TrimSpace(strings.TrimPrefix(authorization, bearerPrefix)))
+	name := ""
+	for _, candidate := range tokens {
+		// Compare every token so that timing does not reveal which one matched
+		if subtle.ConstantTimeCompare(candidate.token, token) == 1 {
+			name = candidate.name
+		}
+	}
+	return name
+}
+
+func splitList(value string) []string {
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -2,7 +2,14 @@
 
 # Notes:
 # - Since 'curl' is not available, we use 'wget' (through busybox),
-# - In order to be authorized, the hostname MUST be 'localhost' or '127.0.0.1'.
+# - In order to be authorized, the token MUST be one of the tokens declared in ADMIN_TOKENS:
+#   ADMIN_TOKEN when it is set, else the token of the first admin of ADMIN_TOKENS.
 
-busybox wget -qO - http://localhost:${PORT}/reset
+token=${ADMIN_TOKEN:-$(echo "${ADMIN_TOKENS}" | cut -d, -f1 | cut -d: -f2-)}
+if [ -z "${token}" ]; then
+  echo "No admin token: set ADMIN_TOKEN, or ADMIN_TOKENS on the server" >&2
+  exit 1
+fi
+
+busybox wget -qO - --header "Authorization: Bearer ${token}" --post-data '' http://localhost:${PORT}/admin/reset
 echo
@@ -0,0 +1,91 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+const ndjsonContentType = "application/x-ndjson"
+
+// Bundle is the Bundle schema: the pending event callbacks of a plan exported, to be imported in another plan or environment
+type Bundle struct {
+	Version        int                    `json:"version"`
+	PlanID         string                 `json:"planId"`
+	ExportedAt     time.Time              `json:"exportedAt"`
+	ExportedBy     string                 `json:"exportedBy,omitempty"`
+	Count          int                    `json:"count"`
+	Checksum       string                 `json:"checksum"`
+	EventCallbacks []BundledEventCallback `json:"eventCallbacks"`
+}
+
+// BundledEventCallback is the BundledCallback schema: an event callback exported, with its position in the plan
+type BundledEventCallback struct {
+	Sequence int `json:"sequence"`
+	EventCallback
+}
+
+// URIRewrite replaces the URIs of the actions starting with From, e.g. the host of an environment, with To
+type URIRewrite struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// ImportResult is the ImportResult schema: the event callbacks created by an import
+type ImportResult struct {
+	PlanID string `json:"planId"`
+	// IDMap maps the ids of the bundle to the ids of the event callbacks created
+	IDMap          map[string]string `json:"idMap"`
+	EventCallbacks []EventCallback   `json:"eventCallbacks"`
+}
+
+// IsNDJSON returns whether a bundle is in NDJSON, a header line followed by one event callback per line, rather than in JSON
+func IsNDJSON(bundle []byte) bool {
+	decoder := json.NewDecoder(bytes.NewReader(bundle))
+	var first json.RawMessage
+	if err := decoder.Decode(&first); err != nil {
+		return false
+	}
+	var second json.RawMessage
+	return decoder.Decode(&second) != io.EOF
+}
+
+// DecodeBundle decodes a bundle returned by ExportPlan, in JSON or in NDJSON, to inspect it: its checksum is not checked
+func DecodeBundle(data []byte) (Bundle, error) {
+	var bundle Bundle
+	if !IsNDJSON(data) {
+		if err := json.Unmarshal(data, &bundle); err != nil {
+			return Bundle{}, fmt.Errorf("esther: the bundle could not be decoded: %s", err)
+		}
+		return bundle, nil
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	first := true
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var err error
+		if first {
+			err = json.Unmarshal(line, &bundle)
+			first = false
+		} else {
+			var eventCallback BundledEventCallback
+			if err = json.Unmarshal(line, &eventCallback); err == nil {
+				bundle.EventCallbacks = append(bundle.EventCallbacks, eventCallback)
+			}
+		}
+		if err != nil {
+			return Bundle{}, fmt.Errorf("esther: the bundle could not be decoded: %s", err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Bundle{}, fmt.Errorf("esther: the bundle could not be decoded: %s", err)
+	}
+	return bundle, nil
+}
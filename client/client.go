@@ -0,0 +1,530 @@
+// Package client is the Go client of the Esther API, as described by openapi/spec/openapi_esther.yaml.
+//
+// It does not depend on the other packages of Esther, so that it can be imported by the services calling it.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	requestIDHeader      = "X-Request-ID"
+	tenantHeader         = "X-Tenant-ID"
+	actorHeader          = "X-Actor"
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotencyKeyInUseTitle is the title of the 409 answered while a request with the same idempotency key is processed
+	idempotencyKeyInUseTitle = "Idempotency key in use"
+
+	defaultMaxRetries   = 3
+	defaultRetryBackoff = 500 * time.Millisecond
+	defaultMaxBackoff   = 30 * time.Second
+)
+
+// Client calls the API of an Esther server, it is safe for concurrent use
+type Client struct {
+	baseURL      *url.URL
+	httpClient   *http.Client
+	token        string
+	tenantID     string
+	actor        string
+	userAgent    string
+	maxRetries   int
+	retryBackoff time.Duration
+	maxBackoff   time.Duration
+}
+
+// Option configures a Client
+type Option func(client *Client)
+
+// WithHTTPClient sets the HTTP client sending the requests, http.DefaultClient by default
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(client *Client) {
+		client.httpClient = httpClient
+	}
+}
+
+// WithToken sets the bearer token of the requests: a tenant token, or an admin token for the admin commands
+func WithToken(token string) Option {
+	return func(client *Client) {
+		client.token = token
+	}
+}
+
+// WithTenant sets the tenant of the requests, when it is not identified by the token
+func WithTenant(tenantID string) Option {
+	return func(client *Client) {
+		client.tenantID = tenantID
+	}
+}
+
+// WithActor sets the X-Actor header, recorded in the history of the changes as onBehalfOf, next to the principal authenticated by the token
+func WithActor(actor string) Option {
+	return func(client *Client) {
+		client.actor = actor
+	}
+}
+
+// WithUserAgent sets the User-Agent header of the requests
+func WithUserAgent(userAgent string) Option {
+	return func(client *Client) {
+		client.userAgent = userAgent
+	}
+}
+
+// WithRetries sets how many times a request is retried (3 by default, 0 disables the retries),
+// with a delay starting at backoff and doubling up to maxBackoff.
+// The transport errors, the 502, 503 and 504 responses and the 429 responses with a Retry-After header are retried.
+// The requests which create or apply are sent with an idempotency key, so that they are not done twice;
+// the other POST requests are only retried on a 429, which the server answers before processing them.
+func WithRetries(maxRetries int, backoff time.Duration, maxBackoff time.Duration) Option {
+	return func(client *Client) {
+		client.maxRetries = maxRetries
+		client.retryBackoff = backoff
+		client.maxBackoff = maxBackoff
+	}
+}
+
+// New returns a client of the server at baseURL, e.g. http://localhost:8080
+func New(baseURL string, options ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid base URL %s: %s", baseURL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("The base URL must be an absolute http or https URL: %s", baseURL)
+	}
+	client := &Client{
+		baseURL:      u,
+		httpClient:   http.DefaultClient,
+		userAgent:    "esther-go-client",
+		maxRetries:   defaultMaxRetries,
+		retryBackoff: defaultRetryBackoff,
+		maxBackoff:   defaultMaxBackoff,
+	}
+	for _, option := range options {
+		option(client)
+	}
+	return client, nil
+}
+
+type requestIDContextKey struct{}
+
+// WithRequestID returns a copy of ctx whose requests are sent with a request ID, generated by the server otherwise
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, requestID)
+}
+
+// ListEventCallbacks returns the pending event callbacks of a plan, in their order of creation
+func (client *Client) ListEventCallbacks(ctx context.Context, planID string) ([]EventCallback, error) {
+	var eventCallbacks []EventCallback
+	err := client.do(ctx, http.MethodGet, planPath(planID, "eventCallbacks"), nil, nil, false, &eventCallbacks)
+	return eventCallbacks, err
+}
+
+// GetEventCallback returns a pending event callback, the error matches ErrNotFound when there is none
+func (client *Client) GetEventCallback(ctx context.Context, planID string, eventID string) (EventCallback, error) {
+	var eventCallback EventCallback
+	err := client.do(ctx, http.MethodGet, planPath(planID, "eventCallbacks", eventID), nil, nil, false, &eventCallback)
+	return eventCallback, err
+}
+
+// CreateEventCallback adds an event callback to a plan
+func (client *Client) CreateEventCallback(ctx context.Context, planID string, eventCallback EventCallback) (EventCallback, error) {
+	var created EventCallback
+	err := client.do(ctx, http.MethodPost, planPath(planID, "eventCallbacks"), nil, eventCallback, true, &created)
+	return created, err
+}
+
+// UpdateEventCallback changes the fields of an event callback which are set
+func (client *Client) UpdateEventCallback(ctx context.Context, planID string, eventID string, eventCallback EventCallback) (EventCallback, error) {
+	var updated EventCallback
+	err := client.do(ctx, http.MethodPut, planPath(planID, "eventCallbacks", eventID), nil, eventCallback, false, &updated)
+	return updated, err
+}
+
+// DeleteEventCallback deletes a pending event callback
+func (client *Client) DeleteEventCallback(ctx context.Context, planID string, eventID string) error {
+	return client.do(ctx, http.MethodDelete, planPath(planID, "eventCallbacks", eventID), nil, nil, false, nil)
+}
+
+// DeleteEventCallbacks deletes all the pending event callbacks of a plan
+func (client *Client) DeleteEventCallbacks(ctx context.Context, planID string) error {
+	return client.do(ctx, http.MethodDelete, planPath(planID, "eventCallbacks"), nil, nil, false, nil)
+}
+
+// ApplyToParent applies the pending event callbacks of a plan to their parent, and returns once they are applied
+func (client *Client) ApplyToParent(ctx context.Context, planID string) error {
+	return client.do(ctx, http.MethodPut, planPath(planID, "eventCallbacksToParent"), nil, nil, true, nil)
+}
+
+// ResumeApply resumes the unfinished apply run of a plan, the error matches ErrNotFound when there is none
+func (client *Client) ResumeApply(ctx context.Context, planID string) (ApplyRun, error) {
+	var run ApplyRun
+	err := client.do(ctx, http.MethodPut, planPath(planID, "eventCallbacksToParent", "resume"), nil, nil, true, &run)
+	return run, err
+}
+
+// ExportPlan returns the bundle of the pending event callbacks of a plan, in JSON or in NDJSON when ndjson is set.
+// The bundle is returned as sent by the server, as its checksum is checked when it is imported; it can be decoded into a Bundle.
+func (client *Client) ExportPlan(ctx context.Context, planID string, ndjson bool) ([]byte, error) {
+	query := url.Values{}
+	if ndjson {
+		query.Set("format", "ndjson")
+	}
+	var bundle []byte
+	err := client.do(ctx, http.MethodGet, planPath(planID, "eventCallbacks", "export"), query, nil, false, &bundle)
+	return bundle, err
+}
+
+// ImportOptions are the changes done to the event callbacks of a bundle when they are imported
+type ImportOptions struct {
+	// IDMap maps ids of the bundle to event callbacks already pending in the plan, which the dependencies on them then refer to
+	IDMap map[string]string
+	// URIRewrites replace the start of the URIs of the actions, the first one matching is applied
+	URIRewrites []URIRewrite
+}
+
+// ImportBundle creates the event callbacks of a bundle returned by ExportPlan, in JSON or in NDJSON, in a plan.
+// They get new ids, and none is created when one fails.
+func (client *Client) ImportBundle(ctx context.Context, planID string, bundle []byte, options ImportOptions) (ImportResult, error) {
+	query := url.Values{}
+	exportedIDs := make([]string, 0, len(options.IDMap))
+	for exportedID := range options.IDMap {
+		exportedIDs = append(exportedIDs, exportedID)
+	}
+	sort.Strings(exportedIDs)
+	for _, exportedID := range exportedIDs {
+		query.Add("mapId", exportedID+"|"+options.IDMap[exportedID])
+	}
+	for _, rewrite := range options.URIRewrites {
+		query.Add("rewriteUri", rewrite.From+"|"+rewrite.To)
+	}
+	contentType := "application/json"
+	if IsNDJSON(bundle) {
+		contentType = ndjsonContentType
+	}
+	var result ImportResult
+	err := client.do(ctx, http.MethodPost, planPath(planID, "eventCallbacks", "import"), query, rawBody{contentType: contentType, data: bundle}, true, &result)
+	return result, err
+}
+
+// CopyEventCallbacks copies event callbacks of a plan to another plan, after its own event callbacks.
+// The error matches ErrConflict, with the Conflicts of the *Error, when they modify resources modified in the target plan and the policy is ConflictFail.
+func (client *Client) CopyEventCallbacks(ctx context.Context, planID string, request TransferRequest) (TransferResult, error) {
+	var result TransferResult
+	err := client.do(ctx, http.MethodPost, planPath(planID, "eventCallbacks", "copy"), nil, request, true, &result)
+	return result, err
+}
+
+// MoveEventCallbacks moves event callbacks of a plan to another plan, like CopyEventCallbacks
+func (client *Client) MoveEventCallbacks(ctx context.Context, planID string, request TransferRequest) (TransferResult, error) {
+	var result TransferResult
+	err := client.do(ctx, http.MethodPost, planPath(planID, "eventCallbacks", "move"), nil, request, true, &result)
+	return result, err
+}
+
+// MergePlan copies all the event callbacks of the source plan of the request to a plan, like CopyEventCallbacks
+func (client *Client) MergePlan(ctx context.Context, planID string, request MergeRequest) (TransferResult, error) {
+	var result TransferResult
+	err := client.do(ctx, http.MethodPost, planPath(planID, "merge"), nil, request, true, &result)
+	return result, err
+}
+
+// ListApplyRuns returns the apply runs of a plan
+func (client *Client) ListApplyRuns(ctx context.Context, planID string) ([]ApplyRun, error) {
+	var runs []ApplyRun
+	err := client.do(ctx, http.MethodGet, planPath(planID, "applyRuns"), nil, nil, false, &runs)
+	return runs, err
+}
+
+// ListArchivedEventCallbacks returns the event callbacks of a plan applied to its parent
+func (client *Client) ListArchivedEventCallbacks(ctx context.Context, planID string) ([]ArchivedEventCallback, error) {
+	var archived []ArchivedEventCallback
+	err := client.do(ctx, http.MethodGet, planPath(planID, "archivedEventCallbacks"), nil, nil, false, &archived)
+	return archived, err
+}
+
+// ListDeadLetterEventCallbacks returns the expired event callbacks of a plan which need a manual action
+func (client *Client) ListDeadLetterEventCallbacks(ctx context.Context, planID string) ([]DeadLetterEventCallback, error) {
+	var deadLetters []DeadLetterEventCallback
+	err := client.do(ctx, http.MethodGet, planPath(planID, "deadLetterEventCallbacks"), nil, nil, false, &deadLetters)
+	return deadLetters, err
+}
+
+// GetPlanSettings returns the settings of a plan
+func (client *Client) GetPlanSettings(ctx context.Context, planID string) (Plan, error) {
+	var plan Plan
+	err := client.do(ctx, http.MethodGet, planPath(planID, "settings"), nil, nil, false, &plan)
+	return plan, err
+}
+
+// UpdatePlanSettings saves the settings of a plan
+func (client *Client) UpdatePlanSettings(ctx context.Context, planID string, plan Plan) (Plan, error) {
+	var saved Plan
+	err := client.do(ctx, http.MethodPut, planPath(planID, "settings"), nil, plan, false, &saved)
+	return saved, err
+}
+
+// GetHistory returns the changes done to the event callbacks of a plan between from and to, which are ignored when zero
+func (client *Client) GetHistory(ctx context.Context, planID string, from time.Time, to time.Time) ([]HistoryEntry, error) {
+	query := url.Values{}
+	if !from.IsZero() {
+		query.Set("from", from.Format(time.RFC3339))
+	}
+	if !to.IsZero() {
+		query.Set("to", to.Format(time.RFC3339))
+	}
+	var entries []HistoryEntry
+	err := client.do(ctx, http.MethodGet, planPath(planID, "history"), query, nil, false, &entries)
+	return entries, err
+}
+
+// CreateSubscription subscribes a webhook, the secret of the subscription is only returned here.
+// A retry replaying the response of a first attempt which was lost returns it without the secret.
+func (client *Client) CreateSubscription(ctx context.Context, subscription Subscription) (Subscription, error) {
+	var created Subscription
+	err := client.do(ctx, http.MethodPost, "/subscriptions", nil, subscription, true, &created)
+	return created, err
+}
+
+// ListSubscriptions returns the subscriptions of the tenant
+func (client *Client) ListSubscriptions(ctx context.Context) ([]Subscription, error) {
+	var subscriptions []Subscription
+	err := client.do(ctx, http.MethodGet, "/subscriptions", nil, nil, false, &subscriptions)
+	return subscriptions, err
+}
+
+// GetSubscription returns a subscription, the error matches ErrNotFound when there is none
+func (client *Client) GetSubscription(ctx context.Context, subscriptionID string) (Subscription, error) {
+	var subscription Subscription
+	err := client.do(ctx, http.MethodGet, "/subscriptions/"+url.PathEscape(subscriptionID), nil, nil, false, &subscription)
+	return subscription, err
+}
+
+// DeleteSubscription deletes a subscription
+func (client *Client) DeleteSubscription(ctx context.Context, subscriptionID string) error {
+	return client.do(ctx, http.MethodDelete, "/subscriptions/"+url.PathEscape(subscriptionID), nil, nil, false, nil)
+}
+
+// ListWebhookDeliveries returns the deliveries of a subscription, with their attempts
+func (client *Client) ListWebhookDeliveries(ctx context.Context, subscriptionID string) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := client.do(ctx, http.MethodGet, "/subscriptions/"+url.PathEscape(subscriptionID)+"/deliveries", nil, nil, false, &deliveries)
+	return deliveries, err
+}
+
+// Ready checks that the server is ready, the error holds the failed checks otherwise
+func (client *Client) Ready(ctx context.Context) error {
+	return client.do(ctx, http.MethodGet, "/ready", nil, nil, false, nil)
+}
+
+// Reset deletes the data of every tenant, it requires an admin token
+func (client *Client) Reset(ctx context.Context) error {
+	return client.do(ctx, http.MethodPost, "/admin/reset", nil, nil, false, nil)
+}
+
+// ResetTenant deletes the data of a tenant, it requires an admin token
+func (client *Client) ResetTenant(ctx context.Context, tenantID string) error {
+	return client.do(ctx, http.MethodPost, "/admin/tenants/"+url.PathEscape(tenantID)+"/reset", nil, nil, false, nil)
+}
+
+// Migrate creates the indexes of the collections of every tenant, it requires an admin token
+func (client *Client) Migrate(ctx context.Context) error {
+	return client.do(ctx, http.MethodPost, "/admin/migrate", nil, nil, false, nil)
+}
+
+func planPath(planID string, elements ...string) string {
+	path := "/plans/" + url.PathEscape(planID)
+	for _, element := range elements {
+		path += "/" + url.PathEscape(element)
+	}
+	return path
+}
+
+// do sends a request, retrying it when it can, and decodes its response into out when it is not nil
+func (client *Client) do(ctx context.Context, method string, path string, query url.Values, in interface{}, idempotent bool, out interface{}) error {
+	var body []byte
+	contentType := "application/json"
+	switch in := in.(type) {
+	case nil:
+	case rawBody:
+		body, contentType = in.data, in.contentType
+	default:
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return fmt.Errorf("esther: the request body could not be encoded: %s", err)
+		}
+	}
+	// The same key is sent with every attempt, the server replays the response of the first one
+	idempotencyKey := ""
+	if idempotent {
+		idempotencyKey = newKey()
+	}
+
+	attempt, polls := 0, 0
+	for {
+		resp, err := client.send(ctx, method, path, query, body, contentType, idempotencyKey)
+		var delay time.Duration
+		poll := false
+		if err == nil {
+			var respBody []byte
+			respBody, err = ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			switch {
+			case err != nil:
+				err = fmt.Errorf("esther: the response could not be read: %s", err)
+			case resp.StatusCode >= 200 && resp.StatusCode < 300:
+				return decodeResponse(resp, respBody, out)
+			default:
+				apiErr := parseError(resp, respBody)
+				var retry bool
+				if delay, retry, poll = retryAfter(resp, apiErr, method, idempotencyKey); !retry {
+					return apiErr
+				}
+				err = apiErr
+			}
+		} else if ctx.Err() != nil || (method == http.MethodPost && idempotencyKey == "") {
+			// The request may have been processed
+			return err
+		}
+
+		// Polling for the response of the first attempt does not count as a retry
+		var backoff time.Duration
+		if poll {
+			backoff = client.backoff(polls)
+			polls++
+		} else {
+			if attempt >= client.maxRetries {
+				return err
+			}
+			backoff = client.backoff(attempt)
+			attempt++
+		}
+		if delay < backoff {
+			delay = backoff
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+func (client *Client) send(ctx context.Context, method string, path string, query url.Values, body []byte, contentType string, idempotencyKey string) (*http.Response, error) {
+	// The path is already escaped
+	u := client.baseURL.String() + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	client.setHeaders(ctx, req)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if idempotencyKey != "" {
+		req.Header.Set(idempotencyKeyHeader, idempotencyKey)
+	}
+	return client.httpClient.Do(req)
+}
+
+// setHeaders sets the headers identifying the client, its tenant and the request
+func (client *Client) setHeaders(ctx context.Context, req *http.Request) {
+	if client.userAgent != "" {
+		req.Header.Set("User-Agent", client.userAgent)
+	}
+	if client.token != "" {
+		req.Header.Set("Authorization", "Bearer "+client.token)
+	}
+	if client.tenantID != "" {
+		req.Header.Set(tenantHeader, client.tenantID)
+	}
+	if client.actor != "" {
+		req.Header.Set(actorHeader, client.actor)
+	}
+	if requestID, _ := ctx.Value(requestIDContextKey{}).(string); requestID != "" {
+		req.Header.Set(requestIDHeader, requestID)
+	}
+}
+
+// backoff returns the delay before a retry, doubling from the retry backoff up to the max backoff
+func (client *Client) backoff(attempt int) time.Duration {
+	delay := float64(client.retryBackoff) * math.Pow(2, float64(attempt))
+	if delay > float64(client.maxBackoff) {
+		return client.maxBackoff
+	}
+	return time.Duration(delay)
+}
+
+// retryAfter returns whether an error response can be retried, the delay asked by the server if any,
+// and whether the request is polled while the first attempt with its idempotency key is still processed.
+// The 429 responses are only retried when the rate of the client is limited, not when a quota is exceeded.
+// The POST requests without an idempotency key are only retried on a 429, the other errors may come once they were processed.
+func retryAfter(resp *http.Response, apiErr *Error, method string, idempotencyKey string) (delay time.Duration, retry bool, poll bool) {
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		delay = time.Duration(seconds) * time.Second
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return delay, delay > 0, false
+	case http.StatusConflict:
+		// The response of the first attempt is replayed once it is stored
+		return delay, idempotencyKey != "" && apiErr.Title == idempotencyKeyInUseTitle, true
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return delay, method != http.MethodPost || idempotencyKey != "", false
+	}
+	return 0, false, false
+}
+
+// rawBody is a request body sent as is
+type rawBody struct {
+	contentType string
+	data        []byte
+}
+
+// decodeResponse decodes a JSON response into out, or copies it when out is a *[]byte
+func decodeResponse(resp *http.Response, body []byte, out interface{}) error {
+	if raw, ok := out.(*[]byte); ok {
+		*raw = body
+		return nil
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent || len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("esther: the response could not be decoded: %s", err)
+	}
+	return nil
+}
+
+func newKey() string {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(key)
+}
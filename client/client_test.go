@@ -0,0 +1,314 @@
+package client
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const specPath = "../openapi/spec/openapi_esther.yaml"
+
+// specParameter is a parameter of the OpenAPI spec, or a reference to one of its components
+type specParameter struct {
+	Ref  string `yaml:"$ref"`
+	Name string `yaml:"name"`
+	In   string `yaml:"in"`
+}
+
+type specOperation struct {
+	Parameters []specParameter `yaml:"parameters"`
+}
+
+type specPathItem struct {
+	Parameters []specParameter `yaml:"parameters"`
+	Get        *specOperation  `yaml:"get"`
+	Put        *specOperation  `yaml:"put"`
+	Post       *specOperation  `yaml:"post"`
+	Delete     *specOperation  `yaml:"delete"`
+}
+
+type spec struct {
+	Paths      map[string]specPathItem `yaml:"paths"`
+	Components struct {
+		Parameters map[string]specParameter `yaml:"parameters"`
+	} `yaml:"components"`
+}
+
+func loadSpec(t *testing.T) spec {
+	data, err := ioutil.ReadFile(specPath)
+	if err != nil {
+		t.Fatalf("The spec could not be read: %s", err)
+	}
+	var s spec
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		t.Fatalf("The spec could not be parsed: %s", err)
+	}
+	return s
+}
+
+// operation returns the operation of the spec matching a request, with the parameters of its path.
+// The literal elements of the paths take precedence over their parameters, e.g. /plans/{id}/eventCallbacks/export over /plans/{id}/eventCallbacks/{eventId}.
+func (s spec) operation(method string, path string) (*specOperation, []specParameter) {
+	var found *specOperation
+	var parameters []specParameter
+	best := -1
+	for template, item := range s.Paths {
+		literals, ok := matchPath(template, path)
+		if !ok || literals <= best {
+			continue
+		}
+		operations := map[string]*specOperation{
+			http.MethodGet:    item.Get,
+			http.MethodPut:    item.Put,
+			http.MethodPost:   item.Post,
+			http.MethodDelete: item.Delete,
+		}
+		if operation := operations[method]; operation != nil {
+			found, best = operation, literals
+			parameters = append(append([]specParameter{}, item.Parameters...), operation.Parameters...)
+		}
+	}
+	return found, parameters
+}
+
+// parameter resolves a parameter of the spec, which may be a reference to its components
+func (s spec) parameter(parameter specParameter) specParameter {
+	if strings.HasPrefix(parameter.Ref, "#/components/parameters/") {
+		return s.Components.Parameters[strings.TrimPrefix(parameter.Ref, "#/components/parameters/")]
+	}
+	return parameter
+}
+
+// matchPath matches a path with a path template of the spec, e.g. /plans/{id}/eventCallbacks, and counts its literal elements
+func matchPath(template string, path string) (int, bool) {
+	templateElements := strings.Split(strings.Trim(template, "/"), "/")
+	pathElements := strings.Split(strings.Trim(path, "/"), "/")
+	if len(templateElements) != len(pathElements) {
+		return 0, false
+	}
+	literals := 0
+	for i, element := range templateElements {
+		if strings.HasPrefix(element, "{") {
+			continue
+		}
+		if element != pathElements[i] {
+			return 0, false
+		}
+		literals++
+	}
+	return literals, true
+}
+
+type recordedRequest struct {
+	method string
+	path   string
+	query  []string
+	header http.Header
+}
+
+func TestClientMatchesSpec(t *testing.T) {
+	s := loadSpec(t)
+
+	var mutex sync.Mutex
+	var requests []recordedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		query := make([]string, 0, len(r.URL.Query()))
+		for name := range r.URL.Query() {
+			query = append(query, name)
+		}
+		requests = append(requests, recordedRequest{method: r.Method, path: r.URL.EscapedPath(), query: query, header: r.Header.Clone()})
+		mutex.Unlock()
+		if strings.HasSuffix(r.URL.Path, "/events/stream") {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		// null decodes into the lists and the objects alike
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("null"))
+	}))
+	defer server.Close()
+
+	c, err := New(server.URL, WithToken("token"), WithTenant("acme"), WithActor("jane"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	now := time.Now()
+	calls := map[string]func() error{
+		"ListEventCallbacks":   func() error { _, err := c.ListEventCallbacks(ctx, "plan-1"); return err },
+		"GetEventCallback":     func() error { _, err := c.GetEventCallback(ctx, "plan-1", "ec-1"); return err },
+		"CreateEventCallback":  func() error { _, err := c.CreateEventCallback(ctx, "plan-1", EventCallback{}); return err },
+		"UpdateEventCallback":  func() error { _, err := c.UpdateEventCallback(ctx, "plan-1", "ec-1", EventCallback{}); return err },
+		"DeleteEventCallback":  func() error { return c.DeleteEventCallback(ctx, "plan-1", "ec-1") },
+		"DeleteEventCallbacks": func() error { return c.DeleteEventCallbacks(ctx, "plan-1") },
+		"ApplyToParent":        func() error { return c.ApplyToParent(ctx, "plan-1") },
+		"ResumeApply":          func() error { _, err := c.ResumeApply(ctx, "plan-1"); return err },
+		"ExportPlan":           func() error { _, err := c.ExportPlan(ctx, "plan-1", true); return err },
+		"ImportBundle": func() error {
+			_, err := c.ImportBundle(ctx, "plan-1", []byte("{}"), ImportOptions{
+				IDMap:       map[string]string{"a": "b"},
+				URIRewrites: []URIRewrite{{From: "http://a", To: "http://b"}},
+			})
+			return err
+		},
+		"CopyEventCallbacks":           func() error { _, err := c.CopyEventCallbacks(ctx, "plan-1", TransferRequest{}); return err },
+		"MoveEventCallbacks":           func() error { _, err := c.MoveEventCallbacks(ctx, "plan-1", TransferRequest{}); return err },
+		"MergePlan":                    func() error { _, err := c.MergePlan(ctx, "plan-1", MergeRequest{}); return err },
+		"ListApplyRuns":                func() error { _, err := c.ListApplyRuns(ctx, "plan-1"); return err },
+		"ListArchivedEventCallbacks":   func() error { _, err := c.ListArchivedEventCallbacks(ctx, "plan-1"); return err },
+		"ListDeadLetterEventCallbacks": func() error { _, err := c.ListDeadLetterEventCallbacks(ctx, "plan-1"); return err },
+		"GetPlanSettings":              func() error { _, err := c.GetPlanSettings(ctx, "plan-1"); return err },
+		"UpdatePlanSettings":           func() error { _, err := c.UpdatePlanSettings(ctx, "plan-1", Plan{}); return err },
+		"GetHistory":                   func() error { _, err := c.GetHistory(ctx, "plan-1", now.Add(-time.Hour), now); return err },
+		"OpenPlanEvents": func() error {
+			stream, err := c.OpenPlanEvents(ctx, "plan-1", "")
+			if err == nil {
+				stream.Close()
+			}
+			return err
+		},
+		"CreateSubscription":    func() error { _, err := c.CreateSubscription(ctx, Subscription{}); return err },
+		"ListSubscriptions":     func() error { _, err := c.ListSubscriptions(ctx); return err },
+		"GetSubscription":       func() error { _, err := c.GetSubscription(ctx, "sub-1"); return err },
+		"DeleteSubscription":    func() error { return c.DeleteSubscription(ctx, "sub-1") },
+		"ListWebhookDeliveries": func() error { _, err := c.ListWebhookDeliveries(ctx, "sub-1"); return err },
+		"Ready":                 func() error { return c.Ready(ctx) },
+		"Reset":                 func() error { return c.Reset(ctx) },
+		"ResetTenant":           func() error { return c.ResetTenant(ctx, "acme") },
+		"Migrate":               func() error { return c.Migrate(ctx) },
+	}
+
+	for name, call := range calls {
+		mutex.Lock()
+		requests = nil
+		mutex.Unlock()
+		if err := call(); err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		mutex.Lock()
+		sent := requests
+		mutex.Unlock()
+		if len(sent) != 1 {
+			t.Errorf("%s: %d requests were sent, instead of 1", name, len(sent))
+			continue
+		}
+		request := sent[0]
+		operation, parameters := s.operation(request.method, request.path)
+		if operation == nil {
+			t.Errorf("%s: %s %s is not in the spec", name, request.method, request.path)
+			continue
+		}
+		declared := map[string]bool{}
+		for _, parameter := range parameters {
+			parameter = s.parameter(parameter)
+			declared[parameter.In+" "+strings.ToLower(parameter.Name)] = true
+		}
+		for _, query := range request.query {
+			if !declared["query "+strings.ToLower(query)] {
+				t.Errorf("%s: the query parameter %s of %s %s is not in the spec", name, query, request.method, request.path)
+			}
+		}
+		if request.header.Get(idempotencyKeyHeader) != "" && !declared["header "+strings.ToLower(idempotencyKeyHeader)] {
+			t.Errorf("%s: %s %s does not accept the %s header of the client", name, request.method, request.path, idempotencyKeyHeader)
+		}
+	}
+}
+
+func TestPostWithoutIdempotencyKeyIsNotRetried(t *testing.T) {
+	var mutex sync.Mutex
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		attempts++
+		mutex.Unlock()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	c, err := New(server.URL, WithRetries(3, time.Millisecond, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Migrate(context.Background()); err == nil {
+		t.Fatal("The 503 was not returned")
+	}
+	if attempts != 1 {
+		t.Errorf("The POST without an idempotency key was sent %d times, instead of once", attempts)
+	}
+}
+
+func TestIdempotencyKeyInUseIsPolled(t *testing.T) {
+	var mutex sync.Mutex
+	keys := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		keys = append(keys, r.Header.Get(idempotencyKeyHeader))
+		attempt := len(keys)
+		mutex.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch attempt {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2, 3, 4:
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"error": {"title": "` + idempotencyKeyInUseTitle + `"}}`))
+		default:
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id": "sub-1"}`))
+		}
+	}))
+	defer server.Close()
+
+	// The polls do not count as retries, a single retry is allowed
+	c, err := New(server.URL, WithRetries(1, time.Millisecond, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subscription, err := c.CreateSubscription(context.Background(), Subscription{})
+	if err != nil {
+		t.Fatalf("The stored response was not replayed: %s", err)
+	}
+	if subscription.ID != "sub-1" {
+		t.Errorf("The subscription %s was returned, instead of sub-1", subscription.ID)
+	}
+	if len(keys) != 5 {
+		t.Fatalf("%d requests were sent, instead of 5", len(keys))
+	}
+	for _, key := range keys {
+		if key == "" || key != keys[0] {
+			t.Fatalf("The attempts were not sent with the same idempotency key: %v", keys)
+		}
+	}
+}
+
+func TestOtherConflictIsNotRetried(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"error": {"title": "The plan is locked"}}`))
+	}))
+	defer server.Close()
+
+	c, err := New(server.URL, WithRetries(3, time.Millisecond, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ApplyToParent(context.Background(), "plan-1"); err == nil {
+		t.Fatal("The 409 was not returned")
+	}
+	if attempts != 1 {
+		t.Errorf("The locked plan was requested %d times, instead of once", attempts)
+	}
+}
@@ -0,0 +1,106 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Errors matched by the API errors with errors.Is, according to their status
+var (
+	ErrNotFound        = errors.New("not found")
+	ErrUnauthorized    = errors.New("unauthorized")
+	ErrConflict        = errors.New("conflict")
+	ErrPayloadTooLarge = errors.New("payload too large")
+	ErrTooManyRequests = errors.New("too many requests")
+)
+
+// Error is an error response of the API, with the Error schema of its {"error": {...}} envelope
+type Error struct {
+	StatusCode int         `json:"-"`
+	Title      string      `json:"title"`
+	Type       string      `json:"type,omitempty"`
+	Detail     string      `json:"detail,omitempty"`
+	RequestID  string      `json:"requestId,omitempty"`
+	Lock       *PlanLock   `json:"lock,omitempty"`
+	Violations []Violation `json:"violations,omitempty"`
+	Conflicts  []Conflict  `json:"conflicts,omitempty"`
+	RunID      string      `json:"runId,omitempty"`
+	// Errors holds the errors of the checks and the admin commands, which respond with an {"errors": {...}} envelope instead
+	Errors map[string][]string `json:"-"`
+}
+
+func (err *Error) Error() string {
+	message := fmt.Sprintf("esther: %d %s", err.StatusCode, err.Title)
+	if err.Detail != "" {
+		message += ": " + err.Detail
+	}
+	if len(err.Errors) > 0 {
+		keys := make([]string, 0, len(err.Errors))
+		for key := range err.Errors {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		details := make([]string, 0, len(keys))
+		for _, key := range keys {
+			details = append(details, fmt.Sprintf("%s: %s", key, strings.Join(err.Errors[key], ", ")))
+		}
+		message += " (" + strings.Join(details, "; ") + ")"
+	}
+	if err.RequestID != "" {
+		message += " [request " + err.RequestID + "]"
+	}
+	return message
+}
+
+// Is matches the errors of the package with the status of the response
+func (err *Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return err.StatusCode == http.StatusNotFound
+	case ErrUnauthorized:
+		return err.StatusCode == http.StatusUnauthorized
+	case ErrConflict:
+		return err.StatusCode == http.StatusConflict
+	case ErrPayloadTooLarge:
+		return err.StatusCode == http.StatusRequestEntityTooLarge
+	case ErrTooManyRequests:
+		return err.StatusCode == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// parseError returns the error of a response, its body being empty or not JSON for some statuses (e.g. 404)
+func parseError(resp *http.Response, body []byte) *Error {
+	var envelope struct {
+		Error  *Error          `json:"error"`
+		Errors json.RawMessage `json:"errors"`
+	}
+	apiErr := &Error{}
+	if json.Unmarshal(body, &envelope) == nil {
+		if envelope.Error != nil {
+			apiErr = envelope.Error
+		}
+		if len(envelope.Errors) > 0 {
+			// The checks respond with lists of errors, the admin commands with a single list
+			var errs map[string][]string
+			var list []string
+			if json.Unmarshal(envelope.Errors, &errs) == nil {
+				apiErr.Errors = errs
+			} else if json.Unmarshal(envelope.Errors, &list) == nil {
+				apiErr.Errors = map[string][]string{"errors": list}
+			}
+		}
+	}
+	apiErr.StatusCode = resp.StatusCode
+	if apiErr.Title == "" {
+		apiErr.Title = http.StatusText(resp.StatusCode)
+	}
+	if apiErr.RequestID == "" {
+		apiErr.RequestID = resp.Header.Get(requestIDHeader)
+	}
+	return apiErr
+}
@@ -0,0 +1,106 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// PlanEventStream is an open stream of the lifecycle events of the event callbacks of a plan, it is not safe for concurrent use
+type PlanEventStream struct {
+	ctx     context.Context
+	body    io.ReadCloser
+	scanner *bufio.Scanner
+}
+
+// OpenPlanEvents opens a stream of the lifecycle events of the event callbacks of a plan with Server-Sent Events, starting after lastEventID when given.
+// It returns once the server has subscribed to the events, so that the events of the requests sent afterwards are received.
+// The stream is not resumed: the caller reopens it from the id of the last event it has received.
+func (client *Client) OpenPlanEvents(ctx context.Context, planID string, lastEventID string) (*PlanEventStream, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, client.baseURL.String()+planPath(planID, "events", "stream"), nil)
+	if err != nil {
+		return nil, err
+	}
+	client.setHeaders(ctx, req)
+	req.Header.Set("Accept", "text/event-stream")
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, parseError(resp, body)
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return &PlanEventStream{ctx: ctx, body: resp.Body, scanner: scanner}, nil
+}
+
+// Next waits for the next event, the error is io.EOF when the server has ended the stream
+func (stream *PlanEventStream) Next() (PlanEvent, error) {
+	// The events are separated by a blank line, the lines starting with ":" are heartbeats
+	var data strings.Builder
+	for stream.scanner.Scan() {
+		line := stream.scanner.Text()
+		switch {
+		case line == "":
+			if data.Len() == 0 {
+				continue
+			}
+			var event PlanEvent
+			if err := json.Unmarshal([]byte(data.String()), &event); err != nil {
+				return PlanEvent{}, fmt.Errorf("esther: the event could not be decoded: %s", err)
+			}
+			return event, nil
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteString("\n")
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if err := stream.ctx.Err(); err != nil {
+		return PlanEvent{}, err
+	}
+	if err := stream.scanner.Err(); err != nil {
+		return PlanEvent{}, err
+	}
+	return PlanEvent{}, io.EOF
+}
+
+// Close closes the stream, Next then returns an error
+func (stream *PlanEventStream) Close() error {
+	return stream.body.Close()
+}
+
+// StreamPlanEvents receives the lifecycle events of the event callbacks of a plan with Server-Sent Events, starting after lastEventID when given.
+// It calls handle with every event until ctx is done, handle returns an error, or the server ends the stream.
+// The stream is not resumed: the caller resumes it from the id of the last event it has handled.
+func (client *Client) StreamPlanEvents(ctx context.Context, planID string, lastEventID string, handle func(event PlanEvent) error) error {
+	stream, err := client.OpenPlanEvents(ctx, planID, lastEventID)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	for {
+		event, err := stream.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := handle(event); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package client
+
+import "time"
+
+// Statuses of an apply run
+const (
+	ApplyRunRunning     = "running"
+	ApplyRunCompleted   = "completed"
+	ApplyRunFailed      = "failed"
+	ApplyRunInterrupted = "interrupted"
+)
+
+// Actions done when an event callback expires
+const (
+	ExpiryActionDelete     = "delete"
+	ExpiryActionUndo       = "undo"
+	ExpiryActionDeadLetter = "deadletter"
+)
+
+// Transports of an action
+const (
+	TransportHTTP  = "http"
+	TransportNATS  = "nats"
+	TransportKafka = "kafka"
+)
+
+// Policies of a transfer when an event callback modifies a resource already modified in the target plan
+const (
+	ConflictFail  = "fail"
+	ConflictSkip  = "skip"
+	ConflictAllow = "allow"
+)
+
+// Types of the notifications delivered to the subscriptions
+const (
+	NotificationApplyCompleted = "apply.completed"
+	NotificationApplyFailed    = "apply.failed"
+	NotificationUndoCompleted  = "undo.completed"
+	NotificationUndoFailed     = "undo.failed"
+)
+
+// EventCallback is the Callback schema: an event callback pending in a plan
+type EventCallback struct {
+	ID          string     `json:"id,omitempty"`
+	PlanID      string     `json:"planId,omitempty"`
+	Title       string     `json:"title"`
+	Undo        Action     `json:"undo"`
+	Parent      Action     `json:"parent"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	OnExpire    string     `json:"onExpire,omitempty"`
+	DependsOn   []string   `json:"dependsOn,omitempty"`
+	ResourceKey string     `json:"resourceKey,omitempty"`
+	// State and ApplyRunID are checkpointed by the apply runs, they are ignored by the server when sent
+	State      string `json:"state,omitempty"`
+	ApplyRunID string `json:"applyRunId,omitempty"`
+}
+
+// Action is the Action schema: the call sent to the parent of an event callback when it is applied, or to undo it
+type Action struct {
+	PlanID    string                 `json:"planId"`
+	Transport string                 `json:"transport,omitempty"`
+	Method    string                 `json:"method"`
+	URI       string                 `json:"uri"`
+	Topic     string                 `json:"topic,omitempty"`
+	Key       string                 `json:"key,omitempty"`
+	Headers   map[string]string      `json:"headers,omitempty"`
+	Payload   map[string]interface{} `json:"payload"`
+}
+
+// ApplyRun is the ApplyRun schema: the application of the event callbacks of a plan to its parent
+type ApplyRun struct {
+	ID         string     `json:"id"`
+	PlanID     string     `json:"planId"`
+	Status     string     `json:"status"`
+	Owner      string     `json:"owner"`
+	Actor      string     `json:"actor,omitempty"`
+	OnBehalfOf string     `json:"onBehalfOf,omitempty"`
+	StartedAt  time.Time  `json:"startedAt"`
+	UpdatedAt  time.Time  `json:"updatedAt"`
+	EndedAt    *time.Time `json:"endedAt,omitempty"`
+	Applied    int        `json:"applied"`
+	Current    string     `json:"current,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// ActionResponse is the ActionResponse schema: what the target of an action responded
+type ActionResponse struct {
+	StatusCode int     `json:"statusCode"`
+	Status     string  `json:"status"`
+	Body       string  `json:"body,omitempty"`
+	Latency    float64 `json:"latency"`
+}
+
+// ApplyResult is the ApplyResult schema: the outcome of the application of an event callback
+type ApplyResult struct {
+	Success  bool            `json:"success"`
+	Error    string          `json:"error,omitempty"`
+	Response *ActionResponse `json:"response,omitempty"`
+}
+
+// ArchivedEventCallback is the ArchivedCallback schema: an event callback applied to its parent
+type ArchivedEventCallback struct {
+	ID            string          `json:"id"`
+	PlanID        string          `json:"planId"`
+	EventCallback EventCallback   `json:"eventCallback"`
+	AppliedAt     time.Time       `json:"appliedAt"`
+	Response      *ActionResponse `json:"response,omitempty"`
+}
+
+// DeadLetterEventCallback is the DeadLetterCallback schema: an expired event callback which needs a manual action
+type DeadLetterEventCallback struct {
+	ID             string        `json:"id"`
+	PlanID         string        `json:"planId"`
+	EventCallback  EventCallback `json:"eventCallback"`
+	Reason         string        `json:"reason"`
+	DeadLetteredAt time.Time     `json:"deadLetteredAt"`
+}
+
+// HistoryEntry is the HistoryEntry schema: a change done to an event callback
+type HistoryEntry struct {
+	ID              string         `json:"id"`
+	PlanID          string         `json:"planId"`
+	EventCallbackID string         `json:"eventCallbackId"`
+	Operation       string         `json:"operation"`
+	Actor           string         `json:"actor"`
+	OnBehalfOf      string         `json:"onBehalfOf,omitempty"`
+	ClientIP        string         `json:"clientIp"`
+	Timestamp       time.Time      `json:"timestamp"`
+	Before          *EventCallback `json:"before,omitempty"`
+	After           *EventCallback `json:"after,omitempty"`
+	ApplyResult     *ApplyResult   `json:"applyResult,omitempty"`
+}
+
+// Plan is the Plan schema: the settings of a plan
+type Plan struct {
+	PlanID          string `json:"planId,omitempty"`
+	DefaultTTL      string `json:"defaultTtl,omitempty"`
+	OnExpire        string `json:"onExpire,omitempty"`
+	MaxCallbacks    int64  `json:"maxCallbacks,omitempty"`
+	MaxPayloadBytes int64  `json:"maxPayloadBytes,omitempty"`
+}
+
+// PlanLock is the PlanLock schema: the lock held on a plan while it is applied, or while one of its event callbacks expires
+type PlanLock struct {
+	PlanID     string    `json:"planId"`
+	Owner      string    `json:"owner"`
+	Holder     string    `json:"holder,omitempty"`
+	Operation  string    `json:"operation"`
+	RequestID  string    `json:"requestId,omitempty"`
+	AcquiredAt time.Time `json:"acquiredAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// Violation is the Violation schema: the rule violation which caused an error
+type Violation struct {
+	PropertyPath string `json:"propertyPath"`
+	Message      string `json:"message"`
+}
+
+// Subscription is the Subscription schema: a webhook notified when the applications and undos of plans complete or fail
+type Subscription struct {
+	ID          string    `json:"id,omitempty"`
+	URL         string    `json:"url"`
+	EventTypes  []string  `json:"eventTypes"`
+	PlanIDs     []string  `json:"planIds,omitempty"`
+	Secret      string    `json:"secret,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Actor       string    `json:"actor,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Notification is the Notification schema: the body of a webhook delivery
+type Notification struct {
+	ID            string         `json:"id"`
+	Type          string         `json:"type"`
+	TenantID      string         `json:"tenantId,omitempty"`
+	PlanID        string         `json:"planId"`
+	OccurredAt    time.Time      `json:"occurredAt"`
+	ApplyRun      *ApplyRun      `json:"applyRun,omitempty"`
+	EventCallback *EventCallback `json:"eventCallback,omitempty"`
+	Error         string         `json:"error,omitempty"`
+}
+
+// WebhookDelivery is the WebhookDelivery schema: the delivery of a notification to a subscription
+type WebhookDelivery struct {
+	ID             string            `json:"id"`
+	SubscriptionID string            `json:"subscriptionId"`
+	PlanID         string            `json:"planId"`
+	Notification   Notification      `json:"notification"`
+	Status         string            `json:"status"`
+	AttemptCount   int               `json:"attemptCount"`
+	Attempts       []DeliveryAttempt `json:"attempts"`
+	NextAttemptAt  time.Time         `json:"nextAttemptAt"`
+	CreatedAt      time.Time         `json:"createdAt"`
+	DeliveredAt    *time.Time        `json:"deliveredAt,omitempty"`
+	EndedAt        *time.Time        `json:"endedAt,omitempty"`
+}
+
+// DeliveryAttempt is one attempt of a webhook delivery
+type DeliveryAttempt struct {
+	AttemptedAt time.Time `json:"attemptedAt"`
+	StatusCode  int       `json:"statusCode,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Latency     float64   `json:"latency"`
+}
+
+// PlanEvent is the PlanEvent schema: a lifecycle event of an event callback
+type PlanEvent struct {
+	ID              string         `json:"id"`
+	Type            string         `json:"type"`
+	TenantID        string         `json:"tenantId,omitempty"`
+	PlanID          string         `json:"planId"`
+	EventCallbackID string         `json:"eventCallbackId"`
+	EventCallback   *EventCallback `json:"eventCallback,omitempty"`
+	Actor           string         `json:"actor,omitempty"`
+	OnBehalfOf      string         `json:"onBehalfOf,omitempty"`
+	RequestID       string         `json:"requestId,omitempty"`
+	OccurredAt      time.Time      `json:"occurredAt"`
+}
+
+// TransferRequest is the TransferRequest schema: the event callbacks of a plan to copy or to move to another plan
+type TransferRequest struct {
+	TargetPlanID     string   `json:"targetPlanId"`
+	EventCallbackIDs []string `json:"eventCallbackIds"`
+	OnConflict       string   `json:"onConflict,omitempty"`
+}
+
+// MergeRequest is the MergeRequest schema: a plan whose event callbacks are merged into another plan
+type MergeRequest struct {
+	SourcePlanID string `json:"sourcePlanId"`
+	OnConflict   string `json:"onConflict,omitempty"`
+	DeleteSource bool   `json:"deleteSource,omitempty"`
+}
+
+// Conflict is the Conflict schema: an event callback transferred to a plan where an event callback of the same resource is pending
+type Conflict struct {
+	ResourceKey           string `json:"resourceKey"`
+	EventCallbackID       string `json:"eventCallbackId"`
+	TargetEventCallbackID string `json:"targetEventCallbackId"`
+}
+
+// TransferResult is the TransferResult schema: the outcome of a copy, a move or a merge
+type TransferResult struct {
+	SourcePlanID   string            `json:"sourcePlanId"`
+	TargetPlanID   string            `json:"targetPlanId"`
+	IDMap          map[string]string `json:"idMap"`
+	EventCallbacks []EventCallback   `json:"eventCallbacks"`
+	Conflicts      []Conflict        `json:"conflicts"`
+	Skipped        []string          `json:"skipped"`
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"gitlab.kardinal.ai/coretech/esther/auth"
+	"gitlab.kardinal.ai/coretech/esther/client"
+	"gitlab.kardinal.ai/coretech/esther/model"
+	"gitlab.kardinal.ai/coretech/esther/persistence"
+	"gitlab.kardinal.ai/coretech/esther/transport"
+)
+
+// cliActor is the actor of the changes done directly in the database, on behalf of the -actor flag
+const cliActor = "esther:cli"
+
+// progressFunc receives the outcome of the action of every event callback applied or undone
+type progressFunc func(eventCallbackID string, err error)
+
+// backend runs the commands against the server, or directly against the database
+type backend interface {
+	list(ctx context.Context, planID string) ([]client.EventCallback, error)
+	get(ctx context.Context, planID string, eventID string) (client.EventCallback, error)
+	create(ctx context.Context, planID string, eventCallback client.EventCallback) (client.EventCallback, error)
+	delete(ctx context.Context, planID string, eventID string) error
+	deleteAll(ctx context.Context, planID string) error
+	apply(ctx context.Context, planID string, progress progressFunc) error
+	exportPlan(ctx context.Context, planID string, ndjson bool) ([]byte, error)
+	importBundle(ctx context.Context, planID string, bundle []byte, options client.ImportOptions) (client.ImportResult, error)
+	reset(ctx context.Context, tenantID string) error
+	migrate(ctx context.Context) error
+	close()
+}
+
+func newBackend(opts options) (backend, error) {
+	if opts.db {
+		if opts.tenant != "" && !auth.ValidTenantID(opts.tenant) {
+			return nil, fmt.Errorf("Invalid tenant %s", opts.tenant)
+		}
+		return &dbBackend{scope: model.Scope{TenantID: opts.tenant, Actor: cliActor, OnBehalfOf: opts.actor}}, nil
+	}
+	c, err := client.New(opts.server,
+		client.WithToken(opts.token),
+		client.WithTenant(opts.tenant),
+		client.WithActor(opts.actor),
+		client.WithUserAgent("esther-cli"))
+	if err != nil {
+		return nil, err
+	}
+	return &httpBackend{client: c}, nil
+}
+
+// httpBackend calls the API of the server
+type httpBackend struct {
+	client *client.Client
+}
+
+// streamGrace is how long the events of an apply are still received once the server has responded
+const streamGrace = time.Second
+
+func (b *httpBackend) list(ctx context.Context, planID string) ([]client.EventCallback, error) {
+	return b.client.ListEventCallbacks(ctx, planID)
+}
+
+func (b *httpBackend) get(ctx context.Context, planID string, eventID string) (client.EventCallback, error) {
+	return b.client.GetEventCallback(ctx, planID, eventID)
+}
+
+func (b *httpBackend) create(ctx context.Context, planID string, eventCallback client.EventCallback) (client.EventCallback, error) {
+	return b.client.CreateEventCallback(ctx, planID, eventCallback)
+}
+
+func (b *httpBackend) delete(ctx context.Context, planID string, eventID string) error {
+	return b.client.DeleteEventCallback(ctx, planID, eventID)
+}
+
+func (b *httpBackend) deleteAll(ctx context.Context, planID string) error {
+	return b.client.DeleteEventCallbacks(ctx, planID)
+}
+
+func (b *httpBackend) apply(ctx context.Context, planID string, progress progressFunc) error {
+	return b.withEvents(ctx, planID, model.EventCallbackApplied, progress, func() error {
+		return b.client.ApplyToParent(ctx, planID)
+	})
+}
+
+// withEvents reports the events of the given type received while fn runs, the failures being reported by the error of fn
+func (b *httpBackend) withEvents(ctx context.Context, planID string, eventType string, progress progressFunc, fn func() error) error {
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := b.client.OpenPlanEvents(streamCtx, planID, "")
+	if err != nil {
+		// The progress is not reported by the servers which do not stream the events
+		return fn()
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer stream.Close()
+		for {
+			event, err := stream.Next()
+			if err != nil {
+				return
+			}
+			if event.Type == eventType {
+				progress(event.EventCallbackID, nil)
+			}
+		}
+	}()
+	err = fn()
+	// The last events are published once committed, they may still be on their way
+	select {
+	case <-done:
+	case <-time.After(streamGrace):
+	}
+	cancel()
+	<-done
+	return err
+}
+
+func (b *httpBackend) exportPlan(ctx context.Context, planID string, ndjson bool) ([]byte, error) {
+	return b.client.ExportPlan(ctx, planID, ndjson)
+}
+
+func (b *httpBackend) importBundle(ctx context.Context, planID string, bundle []byte, options client.ImportOptions) (client.ImportResult, error) {
+	return b.client.ImportBundle(ctx, planID, bundle, options)
+}
+
+func (b *httpBackend) reset(ctx context.Context, tenantID string) error {
+	if tenantID == "" {
+		return b.client.Reset(ctx)
+	}
+	return b.client.ResetTenant(ctx, tenantID)
+}
+
+func (b *httpBackend) migrate(ctx context.Context) error {
+	return b.client.Migrate(ctx)
+}
+
+func (b *httpBackend) close() {}
+
+// dbBackend calls the model, as the server does, with the MONGODB_* environment of the server
+type dbBackend struct {
+	scope model.Scope
+}
+
+func (b *dbBackend) list(ctx context.Context, planID string) ([]client.EventCallback, error) {
+	eventCallbacks, err := model.FindEventCallbacksByPlanId(ctx, b.scope, planID)
+	if err != nil {
+		return nil, err
+	}
+	var converted []client.EventCallback
+	return converted, convert(eventCallbacks, &converted)
+}
+
+func (b *dbBackend) get(ctx context.Context, planID string, eventID string) (client.EventCallback, error) {
+	var converted client.EventCallback
+	eventCallback, err := model.FindEventCallbackById(ctx, b.scope, planID, eventID)
+	if err != nil {
+		return converted, err
+	}
+	return converted, convert(eventCallback, &converted)
+}
+
+func (b *dbBackend) create(ctx context.Context, planID string, eventCallback client.EventCallback) (client.EventCallback, error) {
+	var toCreate model.EventCallback
+	var created client.EventCallback
+	if err := convert(eventCallback, &toCreate); err != nil {
+		return created, err
+	}
+	createdEventCallback, err := model.CreateEventCallback(ctx, b.scope, planID, toCreate)
+	if err != nil {
+		return created, err
+	}
+	return created, convert(createdEventCallback, &created)
+}
+
+func (b *dbBackend) delete(ctx context.Context, planID string, eventID string) error {
+	if _, err := model.FindEventCallbackById(ctx, b.scope, planID, eventID); err != nil {
+		return err
+	}
+	return model.DeleteEventCallbacksById(ctx, b.scope, planID, eventID)
+}
+
+func (b *dbBackend) deleteAll(ctx context.Context, planID string) error {
+	return model.DeleteEventCallbacksByPlanId(ctx, b.scope, planID)
+}
+
+func (b *dbBackend) apply(ctx context.Context, planID string, progress progressFunc) error {
+	_, err := model.ApplyPlanWithProgress(ctx, b.scope, planID, func(p model.ApplyProgress) {
+		if p.EventCallbackID == "" {
+			return
+		}
+		var err error
+		if !p.Applied {
+			err = errors.New(p.Error)
+		}
+		progress(p.EventCallbackID, err)
+	})
+	return err
+}
+
+func (b *dbBackend) exportPlan(ctx context.Context, planID string, ndjson bool) ([]byte, error) {
+	bundle, err := model.ExportPlan(ctx, b.scope, planID)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if ndjson {
+		err = bundle.WriteNDJSON(&buf)
+	} else {
+		err = json.NewEncoder(&buf).Encode(bundle)
+	}
+	return buf.Bytes(), err
+}
+
+func (b *dbBackend) importBundle(ctx context.Context, planID string, data []byte, options client.ImportOptions) (client.ImportResult, error) {
+	var imported client.ImportResult
+	bundle, err := model.ReadBundle(bytes.NewReader(data), client.IsNDJSON(data))
+	if err != nil {
+		return imported, err
+	}
+	importOptions := model.ImportOptions{IDMap: options.IDMap}
+	for _, rewrite := range options.URIRewrites {
+		importOptions.URIRewrites = append(importOptions.URIRewrites, model.URIRewrite{From: rewrite.From, To: rewrite.To})
+	}
+	result, err := model.ImportBundle(ctx, b.scope, planID, bundle, importOptions)
+	if err != nil {
+		return imported, err
+	}
+	return imported, convert(result, &imported)
+}
+
+func (b *dbBackend) reset(ctx context.Context, tenantID string) error {
+	var errs []string
+	if tenantID == "" {
+		errs = persistence.Reset(ctx)
+	} else if !auth.ValidTenantID(tenantID) {
+		return fmt.Errorf("Invalid tenant %s", tenantID)
+	} else {
+		errs = persistence.ResetTenant(ctx, tenantID)
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (b *dbBackend) migrate(ctx context.Context) error {
+	if errs := model.Migrate(ctx); len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (b *dbBackend) close() {
+	transport.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	persistence.Disconnect(ctx)
+}
+
+// convert copies the model types to the types of the API and back, through their JSON representation which they share
+func convert(from interface{}, to interface{}) error {
+	data, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, to); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gitlab.kardinal.ai/coretech/esther/client"
+	"gitlab.kardinal.ai/coretech/esther/model"
+)
+
+// errUsage is returned by the commands called with invalid arguments, once they have printed their usage
+var errUsage = errors.New("usage")
+
+type commandFunc func(ctx context.Context, b backend, opts options, args []string) error
+
+var commands = map[string]commandFunc{
+	"list":    listCommand,
+	"inspect": inspectCommand,
+	"create":  createCommand,
+	"delete":  deleteCommand,
+	"apply":   applyCommand,
+	"undo":    undoCommand,
+	"export":  exportCommand,
+	"import":  importCommand,
+	"admin":   adminCommand,
+}
+
+// parseArgs parses the flags of a command, and checks the number of its other arguments
+func parseArgs(flags *flag.FlagSet, args []string, usage string, minArgs int, maxArgs int) error {
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: esther %s\n", usage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if flags.NArg() < minArgs || flags.NArg() > maxArgs {
+		flags.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+func listCommand(ctx context.Context, b backend, opts options, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	if err := parseArgs(flags, args, "list <planId>", 1, 1); err != nil {
+		return err
+	}
+	eventCallbacks, err := b.list(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if opts.json {
+		return printJSON(os.Stdout, eventCallbacks)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTITLE\tPARENT\tUNDO\tEXPIRES")
+	for _, eventCallback := range eventCallbacks {
+		expires := "-"
+		if eventCallback.ExpiresAt != nil {
+			expires = eventCallback.ExpiresAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", eventCallback.ID, eventCallback.Title, describeAction(eventCallback.Parent), describeAction(eventCallback.Undo), expires)
+	}
+	return w.Flush()
+}
+
+func inspectCommand(ctx context.Context, b backend, opts options, args []string) error {
+	flags := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	if err := parseArgs(flags, args, "inspect <planId> <eventId>", 2, 2); err != nil {
+		return err
+	}
+	eventCallback, err := b.get(ctx, flags.Arg(0), flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	return printJSON(os.Stdout, eventCallback)
+}
+
+func createCommand(ctx context.Context, b backend, opts options, args []string) error {
+	flags := flag.NewFlagSet("create", flag.ContinueOnError)
+	file := flags.String("f", "-", "JSON file of a callback or of a list of callbacks, - for stdin")
+	if err := parseArgs(flags, args, "create [-f file] <planId>", 1, 1); err != nil {
+		return err
+	}
+	eventCallbacks, err := readEventCallbacks(*file)
+	if err != nil {
+		return err
+	}
+	created := make([]client.EventCallback, 0, len(eventCallbacks))
+	for _, eventCallback := range eventCallbacks {
+		createdEventCallback, err := b.create(ctx, flags.Arg(0), eventCallback)
+		if err != nil {
+			return fmt.Errorf("%q could not be created after %d created: %s", eventCallback.Title, len(created), err)
+		}
+		created = append(created, createdEventCallback)
+		if !opts.json {
+			fmt.Printf("created %s %s\n", createdEventCallback.ID, createdEventCallback.Title)
+		}
+	}
+	if opts.json {
+		return printJSON(os.Stdout, created)
+	}
+	return nil
+}
+
+func deleteCommand(ctx context.Context, b backend, opts options, args []string) error {
+	flags := flag.NewFlagSet("delete", flag.ContinueOnError)
+	all := flags.Bool("all", false, "Delete all the event callbacks of the plan")
+	dryRun := flags.Bool("dry-run", false, "Print the event callbacks which would be deleted, without deleting them")
+	if err := parseArgs(flags, args, "delete [-all] [-dry-run] <planId> [eventId]", 1, 2); err != nil {
+		return err
+	}
+	planID := flags.Arg(0)
+	if *all == (flags.NArg() == 2) {
+		flags.Usage()
+		return errUsage
+	}
+
+	if !*all {
+		eventCallback, err := b.get(ctx, planID, flags.Arg(1))
+		if err != nil {
+			return err
+		}
+		if *dryRun {
+			fmt.Printf("would delete %s %s\n", eventCallback.ID, eventCallback.Title)
+			return nil
+		}
+		if err := b.delete(ctx, planID, eventCallback.ID); err != nil {
+			return err
+		}
+		fmt.Printf("deleted %s %s\n", eventCallback.ID, eventCallback.Title)
+		return nil
+	}
+
+	eventCallbacks, err := b.list(ctx, planID)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		for _, eventCallback := range eventCallbacks {
+			fmt.Printf("would delete %s %s\n", eventCallback.ID, eventCallback.Title)
+		}
+		return nil
+	}
+	if err := b.deleteAll(ctx, planID); err != nil {
+		return err
+	}
+	fmt.Printf("deleted the %d event callbacks of plan %s\n", len(eventCallbacks), planID)
+	return nil
+}
+
+func applyCommand(ctx context.Context, b backend, opts options, args []string) error {
+	flags := flag.NewFlagSet("apply", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "Print the actions which would be sent, without sending them")
+	if err := parseArgs(flags, args, "apply [-dry-run] <planId>", 1, 1); err != nil {
+		return err
+	}
+	planID := flags.Arg(0)
+	eventCallbacks, err := b.list(ctx, planID)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		for _, eventCallback := range eventCallbacks {
+			fmt.Printf("would apply %s %s: %s\n", eventCallback.ID, eventCallback.Title, describeAction(eventCallback.Parent))
+		}
+		return nil
+	}
+	applied, err := runWithProgress("applied", eventCallbacks, func(progress progressFunc) error {
+		return b.apply(ctx, planID, progress)
+	})
+	fmt.Printf("%d of %d event callbacks applied\n", applied, len(eventCallbacks))
+	return err
+}
+
+func undoCommand(ctx context.Context, b backend, opts options, args []string) error {
+	flags := flag.NewFlagSet("undo", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "Print the undo actions which would be sent, without sending them")
+	if err := parseArgs(flags, args, "undo [-dry-run] <planId>", 1, 1); err != nil {
+		return err
+	}
+	planID := flags.Arg(0)
+	eventCallbacks, err := b.list(ctx, planID)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		for i := len(eventCallbacks) - 1; i >= 0; i-- {
+			fmt.Printf("would undo %s %s: %s\n", eventCallbacks[i].ID, eventCallbacks[i].Title, describeAction(eventCallbacks[i].Undo))
+		}
+		return nil
+	}
+	undone, err := runWithProgress("undone", eventCallbacks, func(progress progressFunc) error {
+		return undo(ctx, b, planID, eventCallbacks, progress)
+	})
+	fmt.Printf("%d of %d event callbacks undone\n", undone, len(eventCallbacks))
+	return err
+}
+
+// undo sends the undo actions of the event callbacks of a plan, the last created first, and deletes the ones undone as the delete command does.
+// It stops at the first undo action which fails, this event callback and the ones created before it stay pending.
+func undo(ctx context.Context, b backend, planID string, eventCallbacks []client.EventCallback, progress progressFunc) error {
+	for i := len(eventCallbacks) - 1; i >= 0; i-- {
+		var eventCallback model.EventCallback
+		if err := convert(eventCallbacks[i], &eventCallback); err != nil {
+			return err
+		}
+		if _, err := eventCallback.ApplyUndo(ctx); err != nil {
+			err = fmt.Errorf("The undo action of the event-callback %s has failed: %s", eventCallback.ID, err)
+			progress(eventCallback.ID, err)
+			return err
+		}
+		if err := b.delete(ctx, planID, eventCallback.ID); err != nil {
+			err = fmt.Errorf("The event-callback %s was undone but could not be deleted: %s", eventCallback.ID, err)
+			progress(eventCallback.ID, err)
+			return err
+		}
+		progress(eventCallback.ID, nil)
+	}
+	return nil
+}
+
+// runWithProgress prints the outcome of every event callback applied or undone by run, and returns how many have succeeded
+func runWithProgress(verb string, eventCallbacks []client.EventCallback, run func(progress progressFunc) error) (int, error) {
+	titles := make(map[string]string, len(eventCallbacks))
+	for _, eventCallback := range eventCallbacks {
+		titles[eventCallback.ID] = eventCallback.Title
+	}
+	succeeded := 0
+	err := run(func(eventCallbackID string, err error) {
+		if err != nil {
+			fmt.Printf("failed %s %s: %s\n", eventCallbackID, titles[eventCallbackID], err)
+			return
+		}
+		succeeded++
+		fmt.Printf("%s %s %s\n", verb, eventCallbackID, titles[eventCallbackID])
+	})
+	return succeeded, err
+}
+
+func exportCommand(ctx context.Context, b backend, opts options, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	output := flags.String("o", "-", "File written, - for stdout")
+	ndjson := flags.Bool("ndjson", false, "Export the bundle in NDJSON, the default for the files ending with .ndjson")
+	if err := parseArgs(flags, args, "export [-o file] [-ndjson] <planId>", 1, 1); err != nil {
+		return err
+	}
+	if strings.HasSuffix(*output, ".ndjson") {
+		*ndjson = true
+	}
+	bundle, err := b.exportPlan(ctx, flags.Arg(0), *ndjson)
+	if err != nil {
+		return err
+	}
+	if *output == "-" {
+		_, err := os.Stdout.Write(bundle)
+		return err
+	}
+	if err := ioutil.WriteFile(*output, bundle, 0644); err != nil {
+		return err
+	}
+	decoded, err := client.DecodeBundle(bundle)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%d event callbacks exported to %s\n", decoded.Count, *output)
+	return nil
+}
+
+func importCommand(ctx context.Context, b backend, opts options, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	file := flags.String("f", "-", "Bundle written by export, in JSON or in NDJSON, - for stdin")
+	dryRun := flags.Bool("dry-run", false, "Print the event callbacks which would be created, without creating them")
+	var mapIDs, rewriteURIs stringList
+	flags.Var(&mapIDs, "map", "Dependency on an event callback outside of the bundle, changed to one pending in the plan: 'bundleId|planId' (repeatable)")
+	flags.Var(&rewriteURIs, "rewrite-uri", "Rewrite of the start of the URIs of the actions: 'from|to' (repeatable)")
+	if err := parseArgs(flags, args, "import [-f file] [-map 'bundleId|planId'] [-rewrite-uri 'from|to'] [-dry-run] <planId>", 1, 1); err != nil {
+		return err
+	}
+	planID := flags.Arg(0)
+	importOptions := client.ImportOptions{IDMap: map[string]string{}}
+	for _, value := range mapIDs {
+		parts := strings.SplitN(value, "|", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("-map must be 'bundleId|planId': %s", value)
+		}
+		importOptions.IDMap[parts[0]] = parts[1]
+	}
+	for _, value := range rewriteURIs {
+		parts := strings.SplitN(value, "|", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("-rewrite-uri must be 'from|to': %s", value)
+		}
+		importOptions.URIRewrites = append(importOptions.URIRewrites, client.URIRewrite{From: parts[0], To: parts[1]})
+	}
+
+	var data []byte
+	var err error
+	if *file == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(*file)
+	}
+	if err != nil {
+		return err
+	}
+	bundle, err := client.DecodeBundle(data)
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		sort.SliceStable(bundle.EventCallbacks, func(i, j int) bool {
+			return bundle.EventCallbacks[i].Sequence < bundle.EventCallbacks[j].Sequence
+		})
+		for _, eventCallback := range bundle.EventCallbacks {
+			parent := eventCallback.Parent
+			for _, rewrite := range importOptions.URIRewrites {
+				if strings.HasPrefix(parent.URI, rewrite.From) {
+					parent.URI = rewrite.To + strings.TrimPrefix(parent.URI, rewrite.From)
+					break
+				}
+			}
+			fmt.Printf("would create %s (exported as %s): %s\n", eventCallback.Title, eventCallback.ID, describeAction(parent))
+		}
+		return nil
+	}
+
+	result, err := b.importBundle(ctx, planID, data, importOptions)
+	if err != nil {
+		return err
+	}
+	exportedIDs := make(map[string]string, len(result.IDMap))
+	for exportedID, id := range result.IDMap {
+		exportedIDs[id] = exportedID
+	}
+	for _, eventCallback := range result.EventCallbacks {
+		fmt.Printf("created %s %s (exported as %s)\n", eventCallback.ID, eventCallback.Title, exportedIDs[eventCallback.ID])
+	}
+	fmt.Printf("%d event callbacks of plan %s imported\n", len(result.EventCallbacks), bundle.PlanID)
+	return nil
+}
+
+// stringList is a flag which can be repeated
+type stringList []string
+
+func (list *stringList) String() string {
+	return strings.Join(*list, ", ")
+}
+
+func (list *stringList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
+
+func adminCommand(ctx context.Context, b backend, opts options, args []string) error {
+	const usage = "admin reset -yes | -tenant tenantId | admin migrate"
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: esther %s\n", usage)
+		return errUsage
+	}
+	switch args[0] {
+	case "reset":
+		flags := flag.NewFlagSet("admin reset", flag.ContinueOnError)
+		tenantID := flags.String("tenant", "", "Tenant whose data is deleted")
+		yes := flags.Bool("yes", false, "Delete the data of every tenant")
+		if err := parseArgs(flags, args[1:], "admin reset -yes | -tenant tenantId", 0, 0); err != nil {
+			return err
+		}
+		// Deleting the data of every tenant is never the default
+		if (*tenantID == "") != *yes {
+			flags.Usage()
+			return errUsage
+		}
+		if err := b.reset(ctx, *tenantID); err != nil {
+			return err
+		}
+		if *tenantID == "" {
+			fmt.Println("the data of every tenant has been deleted")
+		} else {
+			fmt.Printf("the data of tenant %s has been deleted\n", *tenantID)
+		}
+		return nil
+	case "migrate":
+		flags := flag.NewFlagSet("admin migrate", flag.ContinueOnError)
+		if err := parseArgs(flags, args[1:], "admin migrate", 0, 0); err != nil {
+			return err
+		}
+		if err := b.migrate(ctx); err != nil {
+			return err
+		}
+		fmt.Println("the collections have been migrated")
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "Usage: esther %s\n", usage)
+	return errUsage
+}
+
+// readEventCallbacks reads a callback or a list of callbacks from a JSON file, or from stdin for -
+func readEventCallbacks(file string) ([]client.EventCallback, error) {
+	var data []byte
+	var err error
+	if file == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+	var eventCallbacks []client.EventCallback
+	if bytes.HasPrefix(data, []byte("[")) {
+		err = json.Unmarshal(data, &eventCallbacks)
+	} else {
+		var eventCallback client.EventCallback
+		err = json.Unmarshal(data, &eventCallback)
+		eventCallbacks = append(eventCallbacks, eventCallback)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("The callbacks could not be read from %s: %s", file, err)
+	}
+	return eventCallbacks, nil
+}
+
+func describeAction(action client.Action) string {
+	switch action.Transport {
+	case client.TransportNATS, client.TransportKafka:
+		return action.Transport + " " + action.Topic
+	}
+	return action.Method + " " + action.URI
+}
+
+func printJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
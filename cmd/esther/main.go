@@ -0,0 +1,111 @@
+// Command esther manages the event callbacks of the plans of an Esther server, through its API or directly in its database.
+//
+// Usage:
+//
+//	esther [global flags] <command> [flags] [arguments]
+//
+// Run esther -h for the commands and the flags.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"gitlab.kardinal.ai/coretech/esther/logging"
+)
+
+const usage = `Usage: esther [global flags] <command> [flags] [arguments]
+
+Commands:
+  list <planId>                      List the pending event callbacks of a plan
+  inspect <planId> <eventId>         Print an event callback
+  create [-f file] <planId>          Create event callbacks from a JSON callback or list of callbacks (stdin by default)
+  delete [-all] [-dry-run] <planId> [eventId]
+                                     Delete an event callback, or all the event callbacks of a plan
+  apply [-dry-run] <planId>          Apply the event callbacks of a plan to their parent
+  undo [-dry-run] <planId>           Send the undo actions of the event callbacks of a plan, the last created first
+  export [-o file] [-ndjson] <planId>
+                                     Export the event callbacks of a plan into a bundle (stdout by default)
+  import [-f file] [-map 'bundleId|planId'] [-rewrite-uri 'from|to'] [-dry-run] <planId>
+                                     Create the event callbacks of a bundle in a plan (stdin by default)
+  admin reset -yes | -tenant tenantId
+                                     Delete the data of every tenant, or of a tenant
+  admin migrate                      Create the indexes of the collections of every tenant
+
+Global flags:
+`
+
+type options struct {
+	server string
+	db     bool
+	token  string
+	tenant string
+	actor  string
+	json   bool
+}
+
+func main() {
+	// The logs of the packages shared with the server must not mix with the output of the commands
+	logging.Logger.SetOutput(os.Stderr)
+
+	var opts options
+	flags := flag.NewFlagSet("esther", flag.ExitOnError)
+	flags.StringVar(&opts.server, "server", envOr("ESTHER_URL", "http://localhost:8080"), "URL of the Esther server (ESTHER_URL)")
+	flags.BoolVar(&opts.db, "db", false, "Work directly against the database configured by the MONGODB_* environment, instead of the server")
+	flags.StringVar(&opts.token, "token", os.Getenv("ESTHER_TOKEN"), "Bearer token sent to the server: a tenant token, or an admin token for the admin commands (ESTHER_TOKEN)")
+	flags.StringVar(&opts.tenant, "tenant", os.Getenv("ESTHER_TENANT"), "Tenant of the plans, when it is not identified by the token (ESTHER_TENANT)")
+	flags.StringVar(&opts.actor, "actor", envOr("ESTHER_ACTOR", os.Getenv("USER")), "On behalf of whom the changes are done, recorded in their history next to the authenticated actor (ESTHER_ACTOR)")
+	flags.BoolVar(&opts.json, "json", false, "Print the results as JSON")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(os.Args[1:])
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	command, ok := commands[flags.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "esther: unknown command %q\n\n", flags.Arg(0))
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	// An interrupted command stops at the end of the current step, as the server does on shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	b, err := newBackend(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "esther: %s\n", err)
+		os.Exit(1)
+	}
+	err = command(ctx, b, opts, flags.Args()[1:])
+	b.close()
+	cancel()
+	if err == errUsage {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "esther: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func envOr(name string, fallback string) string {
+	if value, ok := os.LookupEnv(name); ok && value != "" {
+		return value
+	}
+	return fallback
+}
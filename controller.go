@@ -1,18 +1,33 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
+	"gitlab.kardinal.ai/coretech/esther/auth"
+	"gitlab.kardinal.ai/coretech/esther/logging"
 	"gitlab.kardinal.ai/coretech/esther/model"
 )
 
+func scope(c *gin.Context) model.Scope {
+	return model.Scope{
+		TenantID:   auth.Tenant(c),
+		Actor:      auth.Principal(c),
+		OnBehalfOf: c.GetHeader(auth.ActorHeader),
+		ClientIP:   c.ClientIP(),
+	}
+}
+
 func getCallbacks(c *gin.Context) {
 	planID := c.Param("planId")
 
-	eventCallbacks, err := model.FindEventCallbacksByPlanId(planID)
+	eventCallbacks, err := model.FindEventCallbacksByPlanId(c.Request.Context(), scope(c), planID)
 	if err != nil {
 		c.Status(http.StatusNotFound)
 	} else {
@@ -24,7 +39,7 @@ func getOneCallback(c *gin.Context) {
 	planID := c.Param("planId")
 	eventID := c.Param("eventId")
 
-	eventCallback, err := model.FindEventCallbackById(planID, eventID)
+	eventCallback, err := model.FindEventCallbackById(c.Request.Context(), scope(c), planID, eventID)
 	if err != nil {
 		c.Status(http.StatusNotFound)
 	} else {
@@ -40,7 +55,19 @@ func postOneCallback(c *gin.Context) {
 		abortWithError(c, http.StatusBadRequest, err, "The callback input payload could not be bound")
 		return
 	}
-	createdEventCallback, err := model.CreateEventCallback(planID, eventCallback)
+	createdEventCallback, err := model.CreateEventCallback(c.Request.Context(), scope(c), planID, eventCallback)
+	if errors.Is(err, model.ErrPlanLocked) {
+		abortWithPlanLocked(c, err)
+		return
+	}
+	if errors.Is(err, model.ErrQuotaExceeded) {
+		abortWithError(c, http.StatusTooManyRequests, err, "The quota is exceeded")
+		return
+	}
+	if errors.Is(err, model.ErrPayloadTooLarge) {
+		abortWithError(c, http.StatusRequestEntityTooLarge, err, "The callback is too large")
+		return
+	}
 	if err != nil {
 		abortWithError(c, http.StatusBadRequest, err, "The callback could not be created")
 		return
@@ -57,7 +84,15 @@ func putOneCallback(c *gin.Context) {
 		abortWithError(c, http.StatusBadRequest, err, "The callback input payload could not be bound")
 		return
 	}
-	updatedEventCallback, err := model.UpdateEventCallback(planID, eventID, eventCallback)
+	updatedEventCallback, err := model.UpdateEventCallback(c.Request.Context(), scope(c), planID, eventID, eventCallback)
+	if errors.Is(err, model.ErrPlanLocked) {
+		abortWithPlanLocked(c, err)
+		return
+	}
+	if errors.Is(err, model.ErrPayloadTooLarge) {
+		abortWithError(c, http.StatusRequestEntityTooLarge, err, "The callback is too large")
+		return
+	}
 	if err != nil {
 		abortWithError(c, http.StatusBadRequest, err, "The callback could not be updated")
 		return
@@ -68,37 +103,141 @@ func putOneCallback(c *gin.Context) {
 func putCallbacksToParent(c *gin.Context) {
 	planID := c.Param("planId")
 
-	eventCallbacks, err := model.FindEventCallbacksByPlanId(planID)
+	run, err := model.ApplyPlan(c.Request.Context(), scope(c), planID)
+	if !handleApplyError(c, planID, run, err) {
+		return
+	}
+
+	c.JSON(http.StatusNoContent, fmt.Sprintf("PUT callbacks list to parent %s", planID))
+}
+
+func putResumeCallbacksToParent(c *gin.Context) {
+	planID := c.Param("planId")
+
+	run, err := model.ResumeApplyRun(c.Request.Context(), scope(c), planID)
+	if !handleApplyError(c, planID, run, err) {
+		return
+	}
+	c.JSON(http.StatusOK, run)
+}
+
+func getApplyRuns(c *gin.Context) {
+	planID := c.Param("planId")
+
+	runs, err := model.FindApplyRunsByPlanId(c.Request.Context(), scope(c), planID)
 	if err != nil {
-		c.Status(http.StatusNotFound)
+		abortWithError(c, http.StatusInternalServerError, err, "The apply runs could not be retrieved")
+		return
+	}
+	c.JSON(http.StatusOK, runs)
+}
+
+// handleApplyError responds to the error of an apply run, it returns whether the run has completed
+func handleApplyError(c *gin.Context, planID string, run model.ApplyRun, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, model.ErrApplyInterrupted):
+		abortWithApplyInterrupted(c, run, err)
+	case errors.Is(err, model.ErrNoApplyRun):
+		abortWithError(c, http.StatusNotFound, err, fmt.Sprintf("Plan %s has no apply run to resume", planID))
+	case errors.Is(err, model.ErrPlanLocked):
+		abortWithPlanLocked(c, err)
+	default:
+		abortWithError(c, http.StatusInternalServerError, err, "Error during application of events")
+	}
+	return false
+}
+
+func getArchivedCallbacks(c *gin.Context) {
+	planID := c.Param("planId")
+
+	archived, err := model.FindArchivedEventCallbacksByPlanId(c.Request.Context(), scope(c), planID)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err, "The archived callbacks could not be retrieved")
 		return
 	}
+	c.JSON(http.StatusOK, archived)
+}
 
-	for _, eventCallback := range eventCallbacks {
-		err := eventCallback.Apply()
-		if err != nil {
-			abortWithError(c, http.StatusInternalServerError, err, fmt.Sprintf("Error during application of event: %s", eventCallback.ID))
+func getDeadLetterCallbacks(c *gin.Context) {
+	planID := c.Param("planId")
+
+	deadLetters, err := model.FindDeadLetterEventCallbacksByPlanId(c.Request.Context(), scope(c), planID)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err, "The dead letter callbacks could not be retrieved")
+		return
+	}
+	c.JSON(http.StatusOK, deadLetters)
+}
+
+func getPlanSettings(c *gin.Context) {
+	planID := c.Param("planId")
+
+	plan, err := model.FindPlan(c.Request.Context(), scope(c), planID)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err, "The plan settings could not be retrieved")
+		return
+	}
+	c.JSON(http.StatusOK, plan)
+}
+
+func putPlanSettings(c *gin.Context) {
+	planID := c.Param("planId")
+
+	var plan model.Plan
+	if err := c.ShouldBindBodyWith(&plan, binding.JSON); err != nil {
+		abortWithError(c, http.StatusBadRequest, err, "The plan settings input payload could not be bound")
+		return
+	}
+	savedPlan, err := model.SavePlan(c.Request.Context(), scope(c), planID, plan)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err, "The plan settings could not be saved")
+		return
+	}
+	c.JSON(http.StatusOK, savedPlan)
+}
+
+func getHistory(c *gin.Context) {
+	planID := c.Param("planId")
+
+	var from, to time.Time
+	var err error
+	if value := c.Query("from"); value != "" {
+		if from, err = time.Parse(time.RFC3339, value); err != nil {
+			abortWithError(c, http.StatusBadRequest, err, "The from parameter must be a RFC 3339 date")
+			return
+		}
+	}
+	if value := c.Query("to"); value != "" {
+		if to, err = time.Parse(time.RFC3339, value); err != nil {
+			abortWithError(c, http.StatusBadRequest, err, "The to parameter must be a RFC 3339 date")
 			return
 		}
 	}
 
-	if err := model.DeleteEventCallbacksByPlanId(planID); err != nil {
-		abortWithError(c, http.StatusInternalServerError, err, "The events could not be deleted")
+	entries, err := model.FindHistoryByPlanId(c.Request.Context(), scope(c), planID, from, to)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err, "The history could not be retrieved")
 		return
 	}
-
-	c.JSON(http.StatusNoContent, fmt.Sprintf("PUT callbacks list to parent %s", planID))
+	c.JSON(http.StatusOK, entries)
 }
 
 func deleteOneCallback(c *gin.Context) {
 	planID := c.Param("planId")
 	eventID := c.Param("eventId")
 
-	if _, err := model.FindEventCallbackById(planID, eventID); err != nil {
+	if _, err := model.FindEventCallbackById(c.Request.Context(), scope(c), planID, eventID); err != nil {
 		c.Status(http.StatusNotFound)
 		return
 	}
-	if err := model.DeleteEventCallbacksById(planID, eventID); err != nil {
+	err := model.DeleteEventCallbacksById(c.Request.Context(), scope(c), planID, eventID)
+	if errors.Is(err, model.ErrPlanLocked) {
+		abortWithPlanLocked(c, err)
+		return
+	}
+	if err != nil {
 		abortWithError(c, http.StatusBadRequest, err, "The event callback could not be deleted")
 		return
 	}
@@ -108,13 +247,217 @@ func deleteOneCallback(c *gin.Context) {
 func deleteCallbacks(c *gin.Context) {
 	planID := c.Param("planId")
 
-	if _, err := model.FindEventCallbacksByPlanId(planID); err != nil {
+	if _, err := model.FindEventCallbacksByPlanId(c.Request.Context(), scope(c), planID); err != nil {
 		c.Status(http.StatusNotFound)
 		return
 	}
-	if err := model.DeleteEventCallbacksByPlanId(planID); err != nil {
+	err := model.DeleteEventCallbacksByPlanId(c.Request.Context(), scope(c), planID)
+	if errors.Is(err, model.ErrPlanLocked) {
+		abortWithPlanLocked(c, err)
+		return
+	}
+	if err != nil {
 		abortWithError(c, http.StatusBadRequest, err, "The events could not be deleted")
 		return
 	}
 	c.Status(http.StatusNoContent)
 }
+
+func getExportCallbacks(c *gin.Context) {
+	planID := c.Param("planId")
+
+	bundle, err := model.ExportPlan(c.Request.Context(), scope(c), planID)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err, "The plan could not be exported")
+		return
+	}
+	if c.Query("format") != "ndjson" && c.NegotiateFormat(binding.MIMEJSON, model.NDJSONContentType) != model.NDJSONContentType {
+		c.JSON(http.StatusOK, bundle)
+		return
+	}
+	c.Header("Content-Type", model.NDJSONContentType)
+	c.Status(http.StatusOK)
+	if err := bundle.WriteNDJSON(c.Writer); err != nil {
+		logging.FromContext(c.Request.Context()).WithField("error", err).Error("The export could not be written")
+	}
+}
+
+func postImportCallbacks(c *gin.Context) {
+	planID := c.Param("planId")
+
+	options, err := importOptions(c)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err, "The import options are invalid")
+		return
+	}
+	bundle, err := model.ReadBundle(c.Request.Body, c.ContentType() == model.NDJSONContentType)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err, "The bundle could not be read")
+		return
+	}
+	result, err := model.ImportBundle(c.Request.Context(), scope(c), planID, bundle, options)
+	if errors.Is(err, model.ErrPlanLocked) {
+		abortWithPlanLocked(c, err)
+		return
+	}
+	if errors.Is(err, model.ErrQuotaExceeded) {
+		abortWithError(c, http.StatusTooManyRequests, err, "The quota is exceeded")
+		return
+	}
+	if errors.Is(err, model.ErrPayloadTooLarge) {
+		abortWithError(c, http.StatusRequestEntityTooLarge, err, "A callback is too large")
+		return
+	}
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err, "The bundle could not be imported")
+		return
+	}
+	c.JSON(http.StatusCreated, result)
+}
+
+// importOptions reads the mapId and rewriteUri parameters of an import, whose two parts are separated by "|" which URIs cannot hold
+func importOptions(c *gin.Context) (model.ImportOptions, error) {
+	options := model.ImportOptions{IDMap: map[string]string{}}
+	for _, value := range c.QueryArray("mapId") {
+		parts := strings.SplitN(value, "|", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return options, fmt.Errorf("The mapId parameter must be <bundle id>|<plan id>: %s", value)
+		}
+		options.IDMap[parts[0]] = parts[1]
+	}
+	for _, value := range c.QueryArray("rewriteUri") {
+		parts := strings.SplitN(value, "|", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return options, fmt.Errorf("The rewriteUri parameter must be <from>|<to>: %s", value)
+		}
+		options.URIRewrites = append(options.URIRewrites, model.URIRewrite{From: parts[0], To: parts[1]})
+	}
+	return options, nil
+}
+
+func postCopyCallbacks(c *gin.Context) {
+	transferCallbacks(c, model.CopyEventCallbacks, "The callbacks could not be copied")
+}
+
+func postMoveCallbacks(c *gin.Context) {
+	transferCallbacks(c, model.MoveEventCallbacks, "The callbacks could not be moved")
+}
+
+// transferCallbacks copies or moves callbacks of the plan of the request to another plan
+func transferCallbacks(c *gin.Context, transfer func(ctx context.Context, scope model.Scope, planID string, request model.TransferRequest) (model.TransferResult, error), errTitle string) {
+	planID := c.Param("planId")
+
+	var request model.TransferRequest
+	if err := c.ShouldBindBodyWith(&request, binding.JSON); err != nil {
+		abortWithError(c, http.StatusBadRequest, err, "The transfer input payload could not be bound")
+		return
+	}
+	result, err := transfer(c.Request.Context(), scope(c), planID, request)
+	if !handleTransferError(c, err, errTitle) {
+		return
+	}
+	c.JSON(http.StatusCreated, result)
+}
+
+func postMergePlan(c *gin.Context) {
+	planID := c.Param("planId")
+
+	var request model.MergeRequest
+	if err := c.ShouldBindBodyWith(&request, binding.JSON); err != nil {
+		abortWithError(c, http.StatusBadRequest, err, "The merge input payload could not be bound")
+		return
+	}
+	result, err := model.MergePlans(c.Request.Context(), scope(c), planID, request)
+	if !handleTransferError(c, err, "The plans could not be merged") {
+		return
+	}
+	c.JSON(http.StatusCreated, result)
+}
+
+// handleTransferError responds to the error of a copy, a move or a merge, it returns whether the transfer has succeeded
+func handleTransferError(c *gin.Context, err error, errTitle string) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, model.ErrPlanLocked):
+		abortWithPlanLocked(c, err)
+	case errors.Is(err, model.ErrResourceConflict):
+		abortWithResourceConflict(c, err)
+	case errors.Is(err, model.ErrQuotaExceeded):
+		abortWithError(c, http.StatusTooManyRequests, err, "The quota is exceeded")
+	case errors.Is(err, model.ErrPayloadTooLarge):
+		abortWithError(c, http.StatusRequestEntityTooLarge, err, "A callback is too large")
+	default:
+		abortWithError(c, http.StatusBadRequest, err, errTitle)
+	}
+	return false
+}
+
+func postSubscription(c *gin.Context) {
+	var subscription model.Subscription
+	if err := c.ShouldBindBodyWith(&subscription, binding.JSON); err != nil {
+		abortWithError(c, http.StatusBadRequest, err, "The subscription input payload could not be bound")
+		return
+	}
+	createdSubscription, err := model.CreateSubscription(c.Request.Context(), scope(c), subscription)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err, "The subscription could not be created")
+		return
+	}
+	// The secret is only returned at creation
+	c.JSON(http.StatusCreated, createdSubscription)
+}
+
+func getSubscriptions(c *gin.Context) {
+	subscriptions, err := model.FindSubscriptions(c.Request.Context(), scope(c))
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err, "The subscriptions could not be retrieved")
+		return
+	}
+	c.JSON(http.StatusOK, subscriptions)
+}
+
+func getOneSubscription(c *gin.Context) {
+	subscriptionID := c.Param("subscriptionId")
+
+	subscription, err := model.FindSubscription(c.Request.Context(), scope(c), subscriptionID)
+	if errors.Is(err, model.ErrNoSubscription) {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err, "The subscription could not be retrieved")
+		return
+	}
+	c.JSON(http.StatusOK, subscription)
+}
+
+func deleteSubscription(c *gin.Context) {
+	subscriptionID := c.Param("subscriptionId")
+
+	err := model.DeleteSubscription(c.Request.Context(), scope(c), subscriptionID)
+	if errors.Is(err, model.ErrNoSubscription) {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err, "The subscription could not be deleted")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func getSubscriptionDeliveries(c *gin.Context) {
+	subscriptionID := c.Param("subscriptionId")
+
+	if _, err := model.FindSubscription(c.Request.Context(), scope(c), subscriptionID); err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	deliveries, err := model.FindWebhookDeliveries(c.Request.Context(), scope(c), subscriptionID)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err, "The deliveries could not be retrieved")
+		return
+	}
+	c.JSON(http.StatusOK, deliveries)
+}
@@ -0,0 +1,396 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package estherpb
+
+import (
+	context "context"
+	empty "github.com/golang/protobuf/ptypes/empty"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion7
+
+// EstherClient is the client API for Esther service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type EstherClient interface {
+	// ListEventCallbacks returns the pending event callbacks of a plan, in their order of creation
+	ListEventCallbacks(ctx context.Context, in *ListEventCallbacksRequest, opts ...grpc.CallOption) (*ListEventCallbacksResponse, error)
+	// GetEventCallback returns one pending event callback
+	GetEventCallback(ctx context.Context, in *GetEventCallbackRequest, opts ...grpc.CallOption) (*EventCallback, error)
+	// CreateEventCallback adds an event callback to a plan
+	CreateEventCallback(ctx context.Context, in *CreateEventCallbackRequest, opts ...grpc.CallOption) (*EventCallback, error)
+	// UpdateEventCallback changes the fields of an event callback which are set in the request
+	UpdateEventCallback(ctx context.Context, in *UpdateEventCallbackRequest, opts ...grpc.CallOption) (*EventCallback, error)
+	// DeleteEventCallback deletes one pending event callback
+	DeleteEventCallback(ctx context.Context, in *DeleteEventCallbackRequest, opts ...grpc.CallOption) (*empty.Empty, error)
+	// DeleteEventCallbacks deletes all the pending event callbacks of a plan
+	DeleteEventCallbacks(ctx context.Context, in *DeleteEventCallbacksRequest, opts ...grpc.CallOption) (*empty.Empty, error)
+	// ApplyToParent applies the event callbacks of a plan to their parent, and returns the ended apply run
+	ApplyToParent(ctx context.Context, in *ApplyToParentRequest, opts ...grpc.CallOption) (*ApplyRun, error)
+	// StreamApplyProgress applies the event callbacks of a plan to their parent like ApplyToParent,
+	// streaming the outcome of every event callback, then the ended apply run
+	StreamApplyProgress(ctx context.Context, in *ApplyToParentRequest, opts ...grpc.CallOption) (Esther_StreamApplyProgressClient, error)
+}
+
+type estherClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewEstherClient(cc grpc.ClientConnInterface) EstherClient {
+	return &estherClient{cc}
+}
+
+func (c *estherClient) ListEventCallbacks(ctx context.Context, in *ListEventCallbacksRequest, opts ...grpc.CallOption) (*ListEventCallbacksResponse, error) {
+	out := new(ListEventCallbacksResponse)
+	err := c.cc.Invoke(ctx, "/esther.v1.Esther/ListEventCallbacks", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *estherClient) GetEventCallback(ctx context.Context, in *GetEventCallbackRequest, opts ...grpc.CallOption) (*EventCallback, error) {
+	out := new(EventCallback)
+	err := c.cc.Invoke(ctx, "/esther.v1.Esther/GetEventCallback", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *estherClient) CreateEventCallback(ctx context.Context, in *CreateEventCallbackRequest, opts ...grpc.CallOption) (*EventCallback, error) {
+	out := new(EventCallback)
+	err := c.cc.Invoke(ctx, "/esther.v1.Esther/CreateEventCallback", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *estherClient) UpdateEventCallback(ctx context.Context, in *UpdateEventCallbackRequest, opts ...grpc.CallOption) (*EventCallback, error) {
+	out := new(EventCallback)
+	err := c.cc.Invoke(ctx, "/esther.v1.Esther/UpdateEventCallback", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *estherClient) DeleteEventCallback(ctx context.Context, in *DeleteEventCallbackRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, "/esther.v1.Esther/DeleteEventCallback", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *estherClient) DeleteEventCallbacks(ctx context.Context, in *DeleteEventCallbacksRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, "/esther.v1.Esther/DeleteEventCallbacks", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *estherClient) ApplyToParent(ctx context.Context, in *ApplyToParentRequest, opts ...grpc.CallOption) (*ApplyRun, error) {
+	out := new(ApplyRun)
+	err := c.cc.Invoke(ctx, "/esther.v1.Esther/ApplyToParent", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *estherClient) StreamApplyProgress(ctx context.Context, in *ApplyToParentRequest, opts ...grpc.CallOption) (Esther_StreamApplyProgressClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Esther_serviceDesc.Streams[0], "/esther.v1.Esther/StreamApplyProgress", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &estherStreamApplyProgressClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Esther_StreamApplyProgressClient interface {
+	Recv() (*ApplyProgress, error)
+	grpc.ClientStream
+}
+
+type estherStreamApplyProgressClient struct {
+	grpc.ClientStream
+}
+
+func (x *estherStreamApplyProgressClient) Recv() (*ApplyProgress, error) {
+	m := new(ApplyProgress)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// EstherServer is the server API for Esther service.
+// All implementations must embed UnimplementedEstherServer
+// for forward compatibility
+type EstherServer interface {
+	// ListEventCallbacks returns the pending event callbacks of a plan, in their order of creation
+	ListEventCallbacks(context.Context, *ListEventCallbacksRequest) (*ListEventCallbacksResponse, error)
+	// GetEventCallback returns one pending event callback
+	GetEventCallback(context.Context, *GetEventCallbackRequest) (*EventCallback, error)
+	// CreateEventCallback adds an event callback to a plan
+	CreateEventCallback(context.Context, *CreateEventCallbackRequest) (*EventCallback, error)
+	// UpdateEventCallback changes the fields of an event callback which are set in the request
+	UpdateEventCallback(context.Context, *UpdateEventCallbackRequest) (*EventCallback, error)
+	// DeleteEventCallback deletes one pending event callback
+	DeleteEventCallback(context.Context, *DeleteEventCallbackRequest) (*empty.Empty, error)
+	// DeleteEventCallbacks deletes all the pending event callbacks of a plan
+	DeleteEventCallbacks(context.Context, *DeleteEventCallbacksRequest) (*empty.Empty, error)
+	// ApplyToParent applies the event callbacks of a plan to their parent, and returns the ended apply run
+	ApplyToParent(context.Context, *ApplyToParentRequest) (*ApplyRun, error)
+	// StreamApplyProgress applies the event callbacks of a plan to their parent like ApplyToParent,
+	// streaming the outcome of every event callback, then the ended apply run
+	StreamApplyProgress(*ApplyToParentRequest, Esther_StreamApplyProgressServer) error
+	mustEmbedUnimplementedEstherServer()
+}
+
+// UnimplementedEstherServer must be embedded to have forward compatible implementations.
+type UnimplementedEstherServer struct {
+}
+
+func (UnimplementedEstherServer) ListEventCallbacks(context.Context, *ListEventCallbacksRequest) (*ListEventCallbacksResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListEventCallbacks not implemented")
+}
+func (UnimplementedEstherServer) GetEventCallback(context.Context, *GetEventCallbackRequest) (*EventCallback, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetEventCallback not implemented")
+}
+func (UnimplementedEstherServer) CreateEventCallback(context.Context, *CreateEventCallbackRequest) (*EventCallback, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateEventCallback not implemented")
+}
+func (UnimplementedEstherServer) UpdateEventCallback(context.Context, *UpdateEventCallbackRequest) (*EventCallback, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateEventCallback not implemented")
+}
+func (UnimplementedEstherServer) DeleteEventCallback(context.Context, *DeleteEventCallbackRequest) (*empty.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteEventCallback not implemented")
+}
+func (UnimplementedEstherServer) DeleteEventCallbacks(context.Context, *DeleteEventCallbacksRequest) (*empty.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteEventCallbacks not implemented")
+}
+func (UnimplementedEstherServer) ApplyToParent(context.Context, *ApplyToParentRequest) (*ApplyRun, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ApplyToParent not implemented")
+}
+func (UnimplementedEstherServer) StreamApplyProgress(*ApplyToParentRequest, Esther_StreamApplyProgressServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamApplyProgress not implemented")
+}
+func (UnimplementedEstherServer) mustEmbedUnimplementedEstherServer() {}
+
+// UnsafeEstherServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to EstherServer will
+// result in compilation errors.
+type UnsafeEstherServer interface {
+	mustEmbedUnimplementedEstherServer()
+}
+
+func RegisterEstherServer(s grpc.ServiceRegistrar, srv EstherServer) {
+	s.RegisterService(&_Esther_serviceDesc, srv)
+}
+
+func _Esther_ListEventCallbacks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListEventCallbacksRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EstherServer).ListEventCallbacks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/esther.v1.Esther/ListEventCallbacks",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EstherServer).ListEventCallbacks(ctx, req.(*ListEventCallbacksRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Esther_GetEventCallback_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetEventCallbackRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EstherServer).GetEventCallback(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/esther.v1.Esther/GetEventCallback",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EstherServer).GetEventCallback(ctx, req.(*GetEventCallbackRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Esther_CreateEventCallback_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateEventCallbackRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EstherServer).CreateEventCallback(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/esther.v1.Esther/CreateEventCallback",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EstherServer).CreateEventCallback(ctx, req.(*CreateEventCallbackRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Esther_UpdateEventCallback_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateEventCallbackRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EstherServer).UpdateEventCallback(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/esther.v1.Esther/UpdateEventCallback",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EstherServer).UpdateEventCallback(ctx, req.(*UpdateEventCallbackRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Esther_DeleteEventCallback_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteEventCallbackRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EstherServer).DeleteEventCallback(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/esther.v1.Esther/DeleteEventCallback",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EstherServer).DeleteEventCallback(ctx, req.(*DeleteEventCallbackRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Esther_DeleteEventCallbacks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteEventCallbacksRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EstherServer).DeleteEventCallbacks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/esther.v1.Esther/DeleteEventCallbacks",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EstherServer).DeleteEventCallbacks(ctx, req.(*DeleteEventCallbacksRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Esther_ApplyToParent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ApplyToParentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EstherServer).ApplyToParent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/esther.v1.Esther/ApplyToParent",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EstherServer).ApplyToParent(ctx, req.(*ApplyToParentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Esther_StreamApplyProgress_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ApplyToParentRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(EstherServer).StreamApplyProgress(m, &estherStreamApplyProgressServer{stream})
+}
+
+type Esther_StreamApplyProgressServer interface {
+	Send(*ApplyProgress) error
+	grpc.ServerStream
+}
+
+type estherStreamApplyProgressServer struct {
+	grpc.ServerStream
+}
+
+func (x *estherStreamApplyProgressServer) Send(m *ApplyProgress) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _Esther_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "esther.v1.Esther",
+	HandlerType: (*EstherServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListEventCallbacks",
+			Handler:    _Esther_ListEventCallbacks_Handler,
+		},
+		{
+			MethodName: "GetEventCallback",
+			Handler:    _Esther_GetEventCallback_Handler,
+		},
+		{
+			MethodName: "CreateEventCallback",
+			Handler:    _Esther_CreateEventCallback_Handler,
+		},
+		{
+			MethodName: "UpdateEventCallback",
+			Handler:    _Esther_UpdateEventCallback_Handler,
+		},
+		{
+			MethodName: "DeleteEventCallback",
+			Handler:    _Esther_DeleteEventCallback_Handler,
+		},
+		{
+			MethodName: "DeleteEventCallbacks",
+			Handler:    _Esther_DeleteEventCallbacks_Handler,
+		},
+		{
+			MethodName: "ApplyToParent",
+			Handler:    _Esther_ApplyToParent_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamApplyProgress",
+			Handler:       _Esther_StreamApplyProgress_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "esther.proto",
+}
@@ -0,0 +1,4 @@
+// Package estherpb holds the protobuf messages and the gRPC service of the API, generated from esther.proto
+package estherpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative esther.proto
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"time"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"gitlab.kardinal.ai/coretech/esther/auth"
+	"gitlab.kardinal.ai/coretech/esther/estherpb"
+	"gitlab.kardinal.ai/coretech/esther/logging"
+	"gitlab.kardinal.ai/coretech/esther/model"
+)
+
+const defaultGRPCPort = "9090"
+
+// The metadata of the gRPC calls, matching the headers of the REST API
+const (
+	authorizationMetadata = "authorization"
+	tenantMetadata        = "x-tenant-id"
+	actorMetadata         = "x-actor"
+	requestIDMetadata     = "x-request-id"
+)
+
+type grpcScopeKey struct{}
+
+// newGRPCServer returns the gRPC server of the API, the calls are canceled with baseCtx like the REST requests
+func newGRPCServer(baseCtx context.Context) *grpc.Server {
+	server := grpc.NewServer(
+		grpc.MaxRecvMsgSize(int(maxBodyBytes)),
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			start := time.Now()
+			ctx, cancel, err := startGRPCCall(ctx, baseCtx)
+			defer cancel()
+			var resp interface{}
+			if err == nil {
+				resp, err = handler(ctx, req)
+			}
+			endGRPCCall(ctx, info.FullMethod, start, err)
+			return resp, err
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			start := time.Now()
+			ctx, cancel, err := startGRPCCall(ss.Context(), baseCtx)
+			defer cancel()
+			if err == nil {
+				err = handler(srv, &grpcServerStream{ServerStream: ss, ctx: ctx})
+			}
+			endGRPCCall(ctx, info.FullMethod, start, err)
+			return err
+		}),
+	)
+	estherpb.RegisterEstherServer(server, &grpcService{})
+	return server
+}
+
+// grpcServerStream overrides the context of a stream with the one of the call
+type grpcServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (stream *grpcServerStream) Context() context.Context {
+	return stream.ctx
+}
+
+// startGRPCCall identifies the call, applies the rate limit of the client and resolves its tenant, like the REST middlewares
+func startGRPCCall(ctx context.Context, baseCtx context.Context) (context.Context, context.CancelFunc, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	requestID := firstMetadata(md, requestIDMetadata)
+	if !logging.ValidRequestID(requestID) {
+		requestID = logging.NewRequestID()
+	}
+	ctx = logging.WithRequestID(ctx, requestID)
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDMetadata, requestID))
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-baseCtx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	clientIP := grpcClientIP(ctx)
+	client := rateLimitKey(firstMetadata(md, authorizationMetadata), clientIP)
+	if clientRate != rate.Inf && !getClientLimiter(client).Allow() {
+		retryAfter := int(math.Ceil(1 / float64(clientRate)))
+		grpc.SetTrailer(ctx, metadata.Pairs("retry-after", strconv.Itoa(retryAfter)))
+		return ctx, cancel, status.Errorf(codes.ResourceExhausted,
+			"The client %s is limited to %g requests per second, with bursts of %d requests", client, float64(clientRate), clientBurst)
+	}
+	tenantID, err := auth.ResolveTenant(firstMetadata(md, authorizationMetadata), firstMetadata(md, tenantMetadata))
+	if err != nil {
+		return ctx, cancel, status.Errorf(codes.Unauthenticated, "The tenant could not be resolved: %s", err)
+	}
+	ctx = context.WithValue(ctx, grpcScopeKey{}, model.Scope{
+		TenantID:   tenantID,
+		Actor:      auth.AuthenticateTenant(firstMetadata(md, authorizationMetadata)),
+		OnBehalfOf: firstMetadata(md, actorMetadata),
+		ClientIP:   clientIP,
+	})
+	return ctx, cancel, nil
+}
+
+// endGRPCCall logs a call like GinLogHandler logs a request
+func endGRPCCall(ctx context.Context, method string, start time.Time, err error) {
+	code := status.Code(err)
+	fields := logging.LogFields{
+		"grpcCode": code.String(),
+		"method":   method,
+		"clientIP": grpcClientIP(ctx),
+		"latency":  time.Since(start).Seconds(),
+	}
+	switch code {
+	case codes.OK:
+		logging.FromContext(ctx).WithFields(fields).Info(code.String())
+	case codes.Internal, codes.Unknown, codes.Unavailable, codes.DataLoss:
+		logging.FromContext(ctx).WithFields(fields).WithField("error", err).Error(code.String())
+	default:
+		logging.FromContext(ctx).WithFields(fields).WithField("error", err).Warn(code.String())
+	}
+}
+
+// grpcScope returns the scope of a call, set by startGRPCCall
+func grpcScope(ctx context.Context) model.Scope {
+	scope, _ := ctx.Value(grpcScopeKey{}).(model.Scope)
+	return scope
+}
+
+func grpcClientIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+func firstMetadata(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// grpcError returns the status of an error of the model, with the same meaning as the HTTP status of the REST API
+func grpcError(err error, title string) error {
+	code := codes.Internal
+	switch {
+	case errors.Is(err, model.ErrPlanLocked):
+		code = codes.Aborted
+	case errors.Is(err, model.ErrQuotaExceeded), errors.Is(err, model.ErrPayloadTooLarge):
+		code = codes.ResourceExhausted
+	case errors.Is(err, model.ErrNoApplyRun):
+		code = codes.NotFound
+	case errors.Is(err, model.ErrApplyInterrupted), errors.Is(err, context.Canceled):
+		code = codes.Unavailable
+	}
+	return grpcErrorWithCode(code, err, title)
+}
+
+func grpcErrorWithCode(code codes.Code, err error, title string) error {
+	return status.Error(code, fmt.Sprintf("%s: %s", title, err))
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"gitlab.kardinal.ai/coretech/esther/estherpb"
+	"gitlab.kardinal.ai/coretech/esther/logging"
+	"gitlab.kardinal.ai/coretech/esther/model"
+)
+
+// grpcService implements the gRPC API with the model of the REST handlers
+type grpcService struct {
+	estherpb.UnimplementedEstherServer
+}
+
+func (service *grpcService) ListEventCallbacks(ctx context.Context, req *estherpb.ListEventCallbacksRequest) (*estherpb.ListEventCallbacksResponse, error) {
+	eventCallbacks, err := model.FindEventCallbacksByPlanId(ctx, grpcScope(ctx), req.PlanId)
+	if err != nil {
+		return nil, grpcErrorWithCode(codes.NotFound, err, "The callbacks could not be retrieved")
+	}
+	resp := &estherpb.ListEventCallbacksResponse{EventCallbacks: make([]*estherpb.EventCallback, 0, len(eventCallbacks))}
+	for _, eventCallback := range eventCallbacks {
+		message, err := eventCallbackToProto(eventCallback)
+		if err != nil {
+			return nil, grpcErrorWithCode(codes.Internal, err, "The callbacks could not be encoded")
+		}
+		resp.EventCallbacks = append(resp.EventCallbacks, message)
+	}
+	return resp, nil
+}
+
+func (service *grpcService) GetEventCallback(ctx context.Context, req *estherpb.GetEventCallbackRequest) (*estherpb.EventCallback, error) {
+	eventCallback, err := model.FindEventCallbackById(ctx, grpcScope(ctx), req.PlanId, req.Id)
+	if err != nil {
+		return nil, grpcErrorWithCode(codes.NotFound, err, "The callback could not be retrieved")
+	}
+	return encodeEventCallback(eventCallback)
+}
+
+func (service *grpcService) CreateEventCallback(ctx context.Context, req *estherpb.CreateEventCallbackRequest) (*estherpb.EventCallback, error) {
+	eventCallback, err := eventCallbackFromProto(req.EventCallback)
+	if err != nil {
+		return nil, grpcErrorWithCode(codes.InvalidArgument, err, "The callback input payload could not be bound")
+	}
+	createdEventCallback, err := model.CreateEventCallback(ctx, grpcScope(ctx), req.PlanId, eventCallback)
+	if err != nil {
+		return nil, grpcInputError(err, "The callback could not be created")
+	}
+	return encodeEventCallback(createdEventCallback)
+}
+
+func (service *grpcService) UpdateEventCallback(ctx context.Context, req *estherpb.UpdateEventCallbackRequest) (*estherpb.EventCallback, error) {
+	eventCallback, err := eventCallbackFromProto(req.EventCallback)
+	if err != nil {
+		return nil, grpcErrorWithCode(codes.InvalidArgument, err, "The callback input payload could not be bound")
+	}
+	if _, err := model.FindEventCallbackById(ctx, grpcScope(ctx), req.PlanId, req.Id); err != nil {
+		return nil, grpcErrorWithCode(codes.NotFound, err, "The callback could not be retrieved")
+	}
+	updatedEventCallback, err := model.UpdateEventCallback(ctx, grpcScope(ctx), req.PlanId, req.Id, eventCallback)
+	if err != nil {
+		return nil, grpcInputError(err, "The callback could not be updated")
+	}
+	return encodeEventCallback(updatedEventCallback)
+}
+
+func (service *grpcService) DeleteEventCallback(ctx context.Context, req *estherpb.DeleteEventCallbackRequest) (*empty.Empty, error) {
+	if _, err := model.FindEventCallbackById(ctx, grpcScope(ctx), req.PlanId, req.Id); err != nil {
+		return nil, grpcErrorWithCode(codes.NotFound, err, "The callback could not be retrieved")
+	}
+	if err := model.DeleteEventCallbacksById(ctx, grpcScope(ctx), req.PlanId, req.Id); err != nil {
+		return nil, grpcInputError(err, "The event callback could not be deleted")
+	}
+	return &empty.Empty{}, nil
+}
+
+func (service *grpcService) DeleteEventCallbacks(ctx context.Context, req *estherpb.DeleteEventCallbacksRequest) (*empty.Empty, error) {
+	if _, err := model.FindEventCallbacksByPlanId(ctx, grpcScope(ctx), req.PlanId); err != nil {
+		return nil, grpcErrorWithCode(codes.NotFound, err, "The callbacks could not be retrieved")
+	}
+	if err := model.DeleteEventCallbacksByPlanId(ctx, grpcScope(ctx), req.PlanId); err != nil {
+		return nil, grpcInputError(err, "The events could not be deleted")
+	}
+	return &empty.Empty{}, nil
+}
+
+func (service *grpcService) ApplyToParent(ctx context.Context, req *estherpb.ApplyToParentRequest) (*estherpb.ApplyRun, error) {
+	inFlightApplies.Add(1)
+	defer inFlightApplies.Done()
+
+	run, err := model.ApplyPlan(ctx, grpcScope(ctx), req.PlanId)
+	if err != nil {
+		return nil, grpcError(err, "Error during application of events")
+	}
+	return applyRunToProto(run), nil
+}
+
+func (service *grpcService) StreamApplyProgress(req *estherpb.ApplyToParentRequest, stream estherpb.Esther_StreamApplyProgressServer) error {
+	inFlightApplies.Add(1)
+	defer inFlightApplies.Done()
+
+	ctx := stream.Context()
+	// The progress is sent by the goroutine of the run, a client which does not read it slows the run down
+	run, err := model.ApplyPlanWithProgress(ctx, grpcScope(ctx), req.PlanId, func(progress model.ApplyProgress) {
+		message := &estherpb.ApplyProgress{
+			Run:             applyRunToProto(progress.Run),
+			EventCallbackId: progress.EventCallbackID,
+			Applied:         progress.Applied,
+			Error:           progress.Error,
+		}
+		if err := stream.Send(message); err != nil {
+			logging.FromContext(ctx).WithField("error", err).Warn("The apply progress could not be sent")
+		}
+	})
+	// A run which has ended is sent even when it has failed, the status of the call holds the error
+	if run.ID != "" {
+		if sendErr := stream.Send(&estherpb.ApplyProgress{Run: applyRunToProto(run), Done: true}); sendErr != nil && err == nil {
+			return sendErr
+		}
+	}
+	if err != nil {
+		return grpcError(err, "Error during application of events")
+	}
+	return nil
+}
+
+// grpcInputError returns the status of an error of a change, which is an invalid argument unless it is known
+func grpcInputError(err error, title string) error {
+	if errors.Is(err, model.ErrPlanLocked) || errors.Is(err, model.ErrQuotaExceeded) || errors.Is(err, model.ErrPayloadTooLarge) {
+		return grpcError(err, title)
+	}
+	return grpcErrorWithCode(codes.InvalidArgument, err, title)
+}
+
+func encodeEventCallback(eventCallback model.EventCallback) (*estherpb.EventCallback, error) {
+	message, err := eventCallbackToProto(eventCallback)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "The callback could not be encoded: %s", err)
+	}
+	return message, nil
+}
+
+func eventCallbackToProto(eventCallback model.EventCallback) (*estherpb.EventCallback, error) {
+	undo, err := actionToProto(eventCallback.Undo)
+	if err != nil {
+		return nil, err
+	}
+	parent, err := actionToProto(eventCallback.Parent)
+	if err != nil {
+		return nil, err
+	}
+	return &estherpb.EventCallback{
+		Id:          eventCallback.ID,
+		PlanId:      eventCallback.PlanID,
+		Title:       eventCallback.Title,
+		Undo:        undo,
+		Parent:      parent,
+		ExpiresAt:   timeToProto(eventCallback.ExpiresAt),
+		OnExpire:    eventCallback.OnExpire,
+		DependsOn:   eventCallback.DependsOn,
+		ResourceKey: eventCallback.ResourceKey,
+		State:       eventCallback.State,
+		ApplyRunId:  eventCallback.ApplyRunID,
+	}, nil
+}
+
+// eventCallbackFromProto returns the event callback of a request, its state and apply run are ignored by the model
+func eventCallbackFromProto(message *estherpb.EventCallback) (model.EventCallback, error) {
+	if message == nil {
+		return model.EventCallback{}, fmt.Errorf("The event callback is required")
+	}
+	undo, err := actionFromProto(message.Undo)
+	if err != nil {
+		return model.EventCallback{}, fmt.Errorf("Invalid undo action: %s", err)
+	}
+	parent, err := actionFromProto(message.Parent)
+	if err != nil {
+		return model.EventCallback{}, fmt.Errorf("Invalid parent action: %s", err)
+	}
+	eventCallback := model.EventCallback{
+		ID:          message.Id,
+		PlanID:      message.PlanId,
+		Title:       message.Title,
+		Undo:        undo,
+		Parent:      parent,
+		OnExpire:    message.OnExpire,
+		DependsOn:   message.DependsOn,
+		ResourceKey: message.ResourceKey,
+	}
+	if message.ExpiresAt != nil {
+		if err := message.ExpiresAt.CheckValid(); err != nil {
+			return model.EventCallback{}, fmt.Errorf("Invalid expiry date: %s", err)
+		}
+		expiresAt := message.ExpiresAt.AsTime()
+		eventCallback.ExpiresAt = &expiresAt
+	}
+	return eventCallback, nil
+}
+
+// actionToProto converts the payload through JSON, as it is returned by the REST API
+func actionToProto(action model.Action) (*estherpb.Action, error) {
+	message := &estherpb.Action{
+		PlanId:    action.PlanID,
+		Transport: action.Transport,
+		Method:    action.Method,
+		Uri:       action.URI,
+		Topic:     action.Topic,
+		Key:       action.Key,
+		Headers:   action.Headers,
+	}
+	if action.Payload != nil {
+		data, err := json.Marshal(action.Payload)
+		if err != nil {
+			return nil, err
+		}
+		message.Payload = &structpb.Struct{}
+		if err := protojson.Unmarshal(data, message.Payload); err != nil {
+			return nil, err
+		}
+	}
+	return message, nil
+}
+
+// actionFromProto converts the payload through JSON, as it is bound by the REST API
+func actionFromProto(message *estherpb.Action) (model.Action, error) {
+	if message == nil {
+		return model.Action{}, nil
+	}
+	action := model.Action{
+		PlanID:    message.PlanId,
+		Transport: message.Transport,
+		Method:    message.Method,
+		URI:       message.Uri,
+		Topic:     message.Topic,
+		Key:       message.Key,
+		Headers:   message.Headers,
+	}
+	if message.Payload != nil {
+		data, err := protojson.Marshal(message.Payload)
+		if err != nil {
+			return model.Action{}, err
+		}
+		if err := json.Unmarshal(data, &action.Payload); err != nil {
+			return model.Action{}, err
+		}
+	}
+	return action, nil
+}
+
+func applyRunToProto(run model.ApplyRun) *estherpb.ApplyRun {
+	return &estherpb.ApplyRun{
+		Id:        run.ID,
+		PlanId:    run.PlanID,
+		Status:    run.Status,
+		Owner:     run.Owner,
+		Actor:     run.Actor,
+		StartedAt: timeToProto(&run.StartedAt),
+		UpdatedAt: timeToProto(&run.UpdatedAt),
+		EndedAt:   timeToProto(run.EndedAt),
+		Applied:   int32(run.Applied),
+		Current:   run.Current,
+		Error:     run.Error,
+	}
+}
+
+func timeToProto(t *time.Time) *timestamppb.Timestamp {
+	if t == nil || t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
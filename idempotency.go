@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"gitlab.kardinal.ai/coretech/esther/logging"
+	"gitlab.kardinal.ai/coretech/esther/model"
+)
+
+const (
+	maxIdempotencyKeyLength = 255
+	idempotentReplayHeader  = "Idempotent-Replayed"
+)
+
+// idempotencyRecorder keeps a copy of the response body, to replay it
+type idempotencyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (recorder *idempotencyRecorder) Write(data []byte) (int, error) {
+	recorder.body.Write(data)
+	return recorder.ResponseWriter.Write(data)
+}
+
+func (recorder *idempotencyRecorder) WriteString(data string) (int, error) {
+	recorder.body.WriteString(data)
+	return recorder.ResponseWriter.WriteString(data)
+}
+
+// idempotent replays the response of the first request made with the same Idempotency-Key header.
+// The transient errors (409, 429 and 5xx) are not replayed, so that the request can be retried.
+func idempotent(c *gin.Context) {
+	replayIdempotent(c)
+}
+
+// idempotentWithoutSecret is idempotent for the routes whose response holds a secret, which is not stored with it:
+// only the first response holds the secret, the replays hold the other fields
+func idempotentWithoutSecret(c *gin.Context) {
+	replayIdempotent(c, "secret")
+}
+
+// replayIdempotent replays the first response of an Idempotency-Key, without its secretFields
+func replayIdempotent(c *gin.Context, secretFields ...string) {
+	key := c.GetHeader(model.IdempotencyKeyHeader)
+	if key == "" {
+		return
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		abortWithError(c, http.StatusBadRequest, fmt.Errorf("The %s header is longer than %d characters", model.IdempotencyKeyHeader, maxIdempotencyKeyLength), "Invalid idempotency key")
+		return
+	}
+	body, err := c.GetRawData()
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err, "The request body could not be read")
+		return
+	}
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	hash := sha256.Sum256(body)
+
+	record, reserved, err := model.ReserveIdempotencyKey(c.Request.Context(), scope(c), model.IdempotencyRecord{
+		PlanID:      c.Param("planId"),
+		Key:         key,
+		Route:       c.Request.Method + " " + c.FullPath(),
+		RequestHash: hex.EncodeToString(hash[:]),
+	})
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err, "The idempotency key could not be checked")
+		return
+	}
+	if !reserved {
+		switch {
+		case record.RequestHash != hex.EncodeToString(hash[:]):
+			abortWithError(c, http.StatusUnprocessableEntity, fmt.Errorf("The idempotency key %s was used with another payload", key), "Idempotency key reused")
+		case record.Status == model.IdempotencyProcessing:
+			// The client polls until the response of the first request is stored, or its reservation expires
+			c.Header("Retry-After", "1")
+			abortWithError(c, http.StatusConflict, fmt.Errorf("The request with idempotency key %s is still being processed", key), "Idempotency key in use")
+		default:
+			logging.FromContext(c.Request.Context()).WithField("idempotencyKey", key).Info("Replaying the response of an idempotent request")
+			c.Header(idempotentReplayHeader, "true")
+			c.Data(record.ResponseStatus, record.ContentType, record.ResponseBody)
+			c.Abort()
+		}
+		return
+	}
+
+	recorder := &idempotencyRecorder{ResponseWriter: c.Writer}
+	c.Writer = recorder
+	c.Next()
+
+	status := recorder.Status()
+	if !recorder.Written() || status == http.StatusConflict || status == http.StatusTooManyRequests || status >= http.StatusInternalServerError {
+		err = model.ReleaseIdempotencyKey(c.Request.Context(), scope(c), record)
+	} else {
+		record.ResponseStatus = status
+		record.ContentType = recorder.Header().Get("Content-Type")
+		record.ResponseBody, err = withoutFields(recorder.body.Bytes(), secretFields)
+		if err != nil {
+			record.ResponseBody = nil
+			logging.FromContext(c.Request.Context()).WithField("error", err).Error("The secrets could not be removed from the response, it is stored without body")
+		}
+		err = model.CompleteIdempotencyKey(c.Request.Context(), scope(c), record)
+	}
+	if err != nil {
+		logging.FromContext(c.Request.Context()).WithField("error", err).Error("The idempotency key could not be updated")
+	}
+}
+
+// withoutFields removes fields from a JSON object, the other bodies hold no field and are kept as they are
+func withoutFields(body []byte, fields []string) ([]byte, error) {
+	if len(fields) == 0 {
+		return body, nil
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(body, &object); err != nil || object == nil {
+		return body, nil
+	}
+	for _, field := range fields {
+		delete(object, field)
+	}
+	return json.Marshal(object)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+
+	"gitlab.kardinal.ai/coretech/esther/auth"
+	"gitlab.kardinal.ai/coretech/esther/logging"
+)
+
+const (
+	defaultClientBurst     = 20
+	defaultMaxBodyBytes    = 1 << 20
+	clientLimiterIdleAfter = 10 * time.Minute
+)
+
+var (
+	clientRate   rate.Limit
+	clientBurst  int
+	maxBodyBytes int64
+
+	clientLimitersMutex sync.Mutex
+	clientLimiters      = map[string]*clientLimiter{}
+	clientLimitersSwept time.Time
+)
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func init() {
+	initLimitsEnv()
+}
+
+func initLimitsEnv() []string {
+	errs := []string{}
+
+	clientRate = rate.Inf
+	envRate := "CLIENT_RATE"
+	if value := os.Getenv(envRate); value != "" {
+		limit, err := strconv.ParseFloat(value, 64)
+		if err != nil || limit < 0 {
+			errs = append(errs, fmt.Sprintf("%s must be a positive number of requests per second", envRate))
+		} else if limit > 0 {
+			clientRate = rate.Limit(limit)
+		}
+	}
+
+	clientBurst = defaultClientBurst
+	envBurst := "CLIENT_BURST"
+	if value := os.Getenv(envBurst); value != "" {
+		burst, err := strconv.Atoi(value)
+		if err != nil || burst < 1 {
+			errs = append(errs, fmt.Sprintf("%s must be a strictly positive integer", envBurst))
+		} else {
+			clientBurst = burst
+		}
+	}
+
+	maxBodyBytes = defaultMaxBodyBytes
+	envMaxBody := "REQUEST_MAX_BODY_BYTES"
+	if value := os.Getenv(envMaxBody); value != "" {
+		max, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || max < 1 {
+			errs = append(errs, fmt.Sprintf("%s must be a strictly positive integer", envMaxBody))
+		} else {
+			maxBodyBytes = max
+		}
+	}
+
+	if len(errs) > 0 {
+		logging.Logger.WithField("errors", errs).Error("The limits environment is not properly set")
+	}
+	return errs
+}
+
+// getClientLimiter returns the rate limiter of a client, the limiters of the clients gone idle are forgotten
+func getClientLimiter(client string) *rate.Limiter {
+	clientLimitersMutex.Lock()
+	defer clientLimitersMutex.Unlock()
+	now := time.Now()
+	if now.Sub(clientLimitersSwept) > clientLimiterIdleAfter {
+		for key, limiter := range clientLimiters {
+			if now.Sub(limiter.lastSeen) > clientLimiterIdleAfter {
+				delete(clientLimiters, key)
+			}
+		}
+		clientLimitersSwept = now
+	}
+	limiter, ok := clientLimiters[client]
+	if !ok {
+		limiter = &clientLimiter{limiter: rate.NewLimiter(clientRate, clientBurst)}
+		clientLimiters[client] = limiter
+	}
+	limiter.lastSeen = now
+	return limiter.limiter
+}
+
+// rateLimitKey identifies the client of a request for its rate limit: the tenant of its token when it has one, else its IP.
+// The tenant selected by the X-Tenant-ID header is not authenticated, a client could get a new limit with each value.
+func rateLimitKey(authorization string, clientIP string) string {
+	if principal := auth.AuthenticateTenant(authorization); principal != "" {
+		return principal
+	}
+	return clientIP
+}
+
+// limitClientRate rejects the requests of a client beyond CLIENT_RATE requests per second
+func limitClientRate(c *gin.Context) {
+	if clientRate == rate.Inf {
+		return
+	}
+	client := rateLimitKey(c.GetHeader("Authorization"), c.ClientIP())
+	if getClientLimiter(client).Allow() {
+		return
+	}
+	retryAfter := int(math.Ceil(1 / float64(clientRate)))
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	abortWithError(c, http.StatusTooManyRequests,
+		fmt.Errorf("The client %s is limited to %g requests per second, with bursts of %d requests", client, float64(clientRate), clientBurst),
+		"Too many requests")
+}
+
+// limitBodySize rejects the request bodies larger than REQUEST_MAX_BODY_BYTES
+func limitBodySize(c *gin.Context) {
+	if c.Request.ContentLength > maxBodyBytes {
+		abortWithBodyTooLarge(c, c.Request.ContentLength)
+		return
+	}
+	if c.Request.Body == nil || c.Request.Body == http.NoBody {
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, maxBodyBytes+1))
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, err, "The request body could not be read")
+		return
+	}
+	if int64(len(body)) > maxBodyBytes {
+		abortWithBodyTooLarge(c, int64(len(body)))
+		return
+	}
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+}
+
+func abortWithBodyTooLarge(c *gin.Context, length int64) {
+	abortWithError(c, http.StatusRequestEntityTooLarge,
+		fmt.Errorf("The request body is at least %d bytes long, at most %d bytes are accepted", length, maxBodyBytes),
+		"The payload is too large")
+}
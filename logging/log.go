@@ -1,8 +1,12 @@
 package logging
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"net/http"
 	"os"
+	"regexp"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -13,6 +17,18 @@ var Logger logrus.Logger
 
 type LogFields = map[string]interface{}
 
+const (
+	// RequestIDHeader is the header carrying the request ID, in the requests and the responses
+	RequestIDHeader = "X-Request-ID"
+	// RequestIDKey is the gin context key holding the request ID
+	RequestIDKey = "requestId"
+)
+
+type requestIDContextKey struct{}
+
+// requestIDPattern restricts the accepted request IDs, so that they can be safely logged and forwarded
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
 func init() {
 	var level logrus.Level
 	var logLevel = os.Getenv("LOG_LEVEL")
@@ -25,13 +41,62 @@ func init() {
 	var formatter = new(logrus.JSONFormatter)
 	formatter.DisableTimestamp = true
 
+	// The logs written while the packages are initialized go to the standard error, so that they do not mix with the results of the tools.
+	// The server writes its logs to the standard output once started.
 	Logger = logrus.Logger{
-		Out:       os.Stdout,
+		Out:       os.Stderr,
 		Formatter: formatter,
 		Level:     level,
 	}
 }
 
+// WithRequestID returns a copy of ctx carrying a request ID
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, requestID)
+}
+
+// RequestID returns the request ID carried by ctx, if any
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDContextKey{}).(string)
+	return requestID
+}
+
+// FromContext returns the logger of the request carried by ctx
+func FromContext(ctx context.Context) *logrus.Entry {
+	if requestID := RequestID(ctx); requestID != "" {
+		return Logger.WithField(RequestIDKey, requestID)
+	}
+	return logrus.NewEntry(&Logger)
+}
+
+// NewRequestID generates a random request ID
+func NewRequestID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return time.Now().UTC().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(id)
+}
+
+// ValidRequestID checks that a request ID sent by a client can be accepted
+func ValidRequestID(requestID string) bool {
+	return requestIDPattern.MatchString(requestID)
+}
+
+// GinRequestIDHandler accepts the request ID sent by the client or generates one, and returns it in the response
+func GinRequestIDHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if !ValidRequestID(requestID) {
+			requestID = NewRequestID()
+		}
+		c.Set(RequestIDKey, requestID)
+		c.Request = c.Request.WithContext(WithRequestID(c.Request.Context(), requestID))
+		c.Header(RequestIDHeader, requestID)
+		c.Next()
+	}
+}
+
 func GinLogHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		start := time.Now()
@@ -45,6 +110,7 @@ func GinLogHandler() gin.HandlerFunc {
 			"clientIP":   c.ClientIP(),
 			"dataLength": c.Writer.Size(),
 			"latency":    stop.Seconds(),
+			RequestIDKey: c.GetString(RequestIDKey),
 		}
 		msg := http.StatusText(status)
 		if c.Request.URL.Path != "/" {
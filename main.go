@@ -1,30 +1,56 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 
+	"gitlab.kardinal.ai/coretech/esther/auth"
 	"gitlab.kardinal.ai/coretech/esther/logging"
+	"gitlab.kardinal.ai/coretech/esther/metrics"
+	"gitlab.kardinal.ai/coretech/esther/model"
 	"gitlab.kardinal.ai/coretech/esther/persistence"
+	"gitlab.kardinal.ai/coretech/esther/tracing"
 )
 
 func main() {
 	logging.Logger.SetOutput(os.Stdout)
 	logging.Logger.Info("Starting Esther")
 
+	shutdownTracing, err := tracing.Init()
+	if err != nil {
+		logging.Logger.WithField("error", err).Error("The tracing could not be initialized")
+	}
+	defer shutdownTracing()
+
 	gin.SetMode(gin.ReleaseMode)
 	r := setupRouter()
-	autoCheck()
+	autoCheck(context.Background())
+
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
+	model.StartArchivePurge(baseCtx)
+	model.StartExpirySweep(baseCtx)
+	metrics.RegisterPendingCallbacks(model.CountPendingEventCallbacksByPlan)
+	resumeApplyRuns(baseCtx)
+	runInBackground(baseCtx, model.RunOutboxRelay)
+	runInBackground(baseCtx, model.RunWebhookDeliveries)
 
-	r.Run()
+	serve(r, baseCtx, cancelBase)
 }
 
 func setupRouter() *gin.Engine {
 	r := gin.New()
-	r.Use(logging.GinLogHandler(), gin.Recovery())
+	if err := r.SetTrustedProxies(auth.TrustedProxies()); err != nil {
+		logging.Logger.WithField("error", err).Error("Invalid trusted proxies, forwarded headers are ignored")
+		r.SetTrustedProxies(nil)
+	}
+	r.Use(logging.GinRequestIDHandler(), logging.GinLogHandler(), metrics.GinMetricsHandler(), otelgin.Middleware(tracing.ServiceName()), gin.Recovery())
 
 	// TEMP
 	gin.SetMode(gin.DebugMode)
@@ -32,23 +58,49 @@ func setupRouter() *gin.Engine {
 	/* Monitoring */
 	r.GET("/", healthCheck)
 	r.GET("/ready", readyCheck)
+	r.GET("/metrics", metrics.Handler())
 
 	/* API */
-	api := r.Group("/plans/:planId")
+	api := r.Group("/plans/:planId", limitClientRate, resolveTenant, limitBodySize)
 	{
 		api.GET("/eventCallbacks", getCallbacks)
-		api.POST("/eventCallbacks", postOneCallback)
+		api.POST("/eventCallbacks", idempotent, postOneCallback)
+		api.GET("/eventCallbacks/export", getExportCallbacks)
+		api.POST("/eventCallbacks/import", idempotent, postImportCallbacks)
+		api.POST("/eventCallbacks/copy", idempotent, postCopyCallbacks)
+		api.POST("/eventCallbacks/move", idempotent, postMoveCallbacks)
 		api.GET("/eventCallbacks/:eventId", getOneCallback)
 		api.PUT("/eventCallbacks/:eventId", putOneCallback)
 		api.DELETE("/eventCallbacks/:eventId", deleteOneCallback)
 		api.DELETE("/eventCallbacks", deleteCallbacks)
-		api.PUT("/eventCallbacksToParent", putCallbacksToParent)
+		api.PUT("/eventCallbacksToParent", trackApply, idempotent, putCallbacksToParent)
+		api.PUT("/eventCallbacksToParent/resume", trackApply, idempotent, putResumeCallbacksToParent)
+		api.POST("/merge", idempotent, postMergePlan)
+		api.GET("/applyRuns", getApplyRuns)
+		api.GET("/archivedEventCallbacks", getArchivedCallbacks)
+		api.GET("/deadLetterEventCallbacks", getDeadLetterCallbacks)
+		api.GET("/settings", getPlanSettings)
+		api.PUT("/settings", putPlanSettings)
+		api.GET("/history", getHistory)
+		api.GET("/events/stream", streamPlanEvents)
+	}
+
+	/* Subscriptions */
+	subscriptions := r.Group("/subscriptions", limitClientRate, resolveTenant, limitBodySize)
+	{
+		subscriptions.POST("", idempotentWithoutSecret, postSubscription)
+		subscriptions.GET("", getSubscriptions)
+		subscriptions.GET("/:subscriptionId", getOneSubscription)
+		subscriptions.DELETE("/:subscriptionId", deleteSubscription)
+		subscriptions.GET("/:subscriptionId/deliveries", getSubscriptionDeliveries)
 	}
 
-	/* Local commands */
-	local := r.Group("", isLocalhost)
+	/* Admin commands */
+	admin := r.Group("/admin", isAdmin, auditAdmin)
 	{
-		local.GET("/reset", doReset)
+		admin.POST("/reset", doReset)
+		admin.POST("/tenants/:tenantId/reset", doTenantReset)
+		admin.POST("/migrate", doMigrate)
 	}
 
 	/* OpenAPI doc */
@@ -62,7 +114,13 @@ func healthCheck(c *gin.Context) {
 }
 
 func readyCheck(c *gin.Context) {
-	errors := autoCheck()
+	if isDraining() {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+			"errors": gin.H{"server": []string{"The server is shutting down"}},
+		})
+		return
+	}
+	errors := autoCheck(c.Request.Context())
 	if len(errors) > 0 {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
 			"errors": errors,
@@ -72,18 +130,47 @@ func readyCheck(c *gin.Context) {
 	c.JSON(http.StatusOK, "OK")
 }
 
-func isLocalhost(c *gin.Context) {
-	clientIP := c.ClientIP()
-	logging.Logger.WithField("clientIP", clientIP).Info("Checking localhost by IP")
-	if clientIP != "127.0.0.1" && clientIP != "::1" {
-		err := fmt.Errorf("%s is not authorized for this action", clientIP)
-		abortWithError(c, http.StatusUnauthorized, err, "Unauthorized IP")
+func isAdmin(c *gin.Context) {
+	admin, err := auth.AuthenticateAdmin(c.GetHeader("Authorization"))
+	if err != nil {
+		logging.Logger.WithFields(logging.LogFields{
+			"clientIP": c.ClientIP(),
+			"path":     c.Request.URL.Path,
+		}).Warn("Rejected admin action")
+		c.Header("WWW-Authenticate", "Bearer")
+		abortWithError(c, http.StatusUnauthorized, err, "Unauthorized")
+		return
 	}
+	c.Set(auth.PrincipalKey, admin)
+}
+
+func resolveTenant(c *gin.Context) {
+	tenantID, err := auth.ResolveTenant(c.GetHeader("Authorization"), c.GetHeader(auth.TenantHeader))
+	if err != nil {
+		abortWithError(c, http.StatusUnauthorized, err, "The tenant could not be resolved")
+		return
+	}
+	c.Set(auth.TenantKey, tenantID)
+	if principal := auth.AuthenticateTenant(c.GetHeader("Authorization")); principal != "" {
+		c.Set(auth.PrincipalKey, principal)
+	}
+}
+
+func auditAdmin(c *gin.Context) {
+	c.Next()
+	logging.Logger.WithFields(logging.LogFields{
+		"audit":    true,
+		"admin":    auth.Principal(c),
+		"clientIP": c.ClientIP(),
+		"method":   c.Request.Method,
+		"path":     c.Request.URL.Path,
+		"httpCode": c.Writer.Status(),
+	}).Info("Admin action")
 }
 
 func doReset(c *gin.Context) {
-	logging.Logger.Info("Reset")
-	errors := reset()
+	logging.Logger.WithField("admin", auth.Principal(c)).Info("Reset")
+	errors := reset(c.Request.Context())
 	logging.Logger.Info("End of reset")
 
 	if len(errors) > 0 {
@@ -95,30 +182,112 @@ func doReset(c *gin.Context) {
 	c.JSON(http.StatusOK, "OK")
 }
 
+func doMigrate(c *gin.Context) {
+	logging.Logger.WithField("admin", auth.Principal(c)).Info("Migration")
+	if errors := model.Migrate(c.Request.Context()); len(errors) > 0 {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"errors": errors,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, "OK")
+}
+
+func doTenantReset(c *gin.Context) {
+	tenantID := c.Param("tenantId")
+	if !auth.ValidTenantID(tenantID) {
+		abortWithError(c, http.StatusBadRequest, fmt.Errorf("%s is not a valid tenant identifier", tenantID), "Invalid tenant")
+		return
+	}
+	logging.Logger.WithFields(logging.LogFields{"admin": auth.Principal(c), "tenant": tenantID}).Info("Tenant reset")
+	if errors := persistence.ResetTenant(c.Request.Context(), tenantID); len(errors) > 0 {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"errors": errors,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, "OK")
+}
+
 func abortWithError(c *gin.Context, status int, err error, errTitle string) {
 	errDetail := ""
 	if err != nil {
 		errDetail = fmt.Sprintf("%s", err)
 	}
-	logging.Logger.WithField("error", err).Error(errTitle)
+	requestID := c.GetString(logging.RequestIDKey)
+	logging.FromContext(c.Request.Context()).WithField("error", err).Error(errTitle)
 	c.AbortWithStatusJSON(status, gin.H{
 		"error": gin.H{
-			"title":  errTitle,
-			"detail": errDetail,
+			"title":     errTitle,
+			"detail":    errDetail,
+			"requestId": requestID,
+		},
+	})
+}
+
+// abortWithPlanLocked responds 409, with the holder of the lock of the plan when it is known
+func abortWithPlanLocked(c *gin.Context, err error) {
+	var locked *model.PlanLockedError
+	if !errors.As(err, &locked) {
+		abortWithError(c, http.StatusConflict, err, "The plan is locked")
+		return
+	}
+	logging.FromContext(c.Request.Context()).WithField("error", err).Warn("The plan is locked")
+	c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+		"error": gin.H{
+			"title":     "The plan is locked",
+			"detail":    err.Error(),
+			"requestId": c.GetString(logging.RequestIDKey),
+			"lock":      locked.Lock,
+		},
+	})
+}
+
+// abortWithApplyInterrupted responds 503 to an apply run interrupted by a shutdown or the loss of the lock of its plan, with the run to resume
+func abortWithApplyInterrupted(c *gin.Context, run model.ApplyRun, err error) {
+	logging.FromContext(c.Request.Context()).WithFields(logging.LogFields{
+		"planId": run.PlanID,
+		"run":    run.ID,
+		"error":  err,
+	}).Warn("The application was interrupted")
+	c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+		"error": gin.H{
+			"title":     "The application was interrupted, it is resumed by the next application",
+			"detail":    err.Error(),
+			"requestId": c.GetString(logging.RequestIDKey),
+			"runId":     run.ID,
+		},
+	})
+}
+
+// abortWithResourceConflict responds 409, with the callbacks which conflict with the ones of the target plan
+func abortWithResourceConflict(c *gin.Context, err error) {
+	var conflict *model.ResourceConflictError
+	if !errors.As(err, &conflict) {
+		abortWithError(c, http.StatusConflict, err, "The callbacks conflict with the ones of the target plan")
+		return
+	}
+	logging.FromContext(c.Request.Context()).WithField("error", err).Warn("The callbacks conflict with the ones of the target plan")
+	c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+		"error": gin.H{
+			"title":     "The callbacks conflict with the ones of the target plan",
+			"detail":    err.Error(),
+			"requestId": c.GetString(logging.RequestIDKey),
+			"conflicts": conflict.Conflicts,
 		},
 	})
 }
 
-func autoCheck() map[string][]string {
+func autoCheck(ctx context.Context) map[string][]string {
 	errors := make(map[string][]string)
-	if persistenceErrors := persistence.ReadyCheck(); len(persistenceErrors) > 0 {
+	if persistenceErrors := persistence.ReadyCheck(ctx); len(persistenceErrors) > 0 {
 		errors["persistence"] = persistenceErrors
 	}
 	return errors
 }
 
-func reset() []string {
+func reset(ctx context.Context) []string {
 	errors := []string{}
-	errors = append(errors, persistence.Reset()...)
+	errors = append(errors, persistence.Reset(ctx)...)
 	return errors
 }
@@ -0,0 +1,191 @@
+package metrics
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"gitlab.kardinal.ai/coretech/esther/logging"
+)
+
+const namespace = "esther"
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of HTTP requests by route, method and status.",
+	}, []string{"route", "method", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests by route, method and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	actionAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "action_attempts_total",
+		Help:      "Number of outbound actions (apply to parent or undo) attempted by target host.",
+	}, []string{"action", "host"})
+
+	actionResults = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "action_results_total",
+		Help:      "Number of outbound actions by target host and result (success or failure).",
+	}, []string{"action", "host", "result"})
+
+	actionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "action_duration_seconds",
+		Help:      "Latency of outbound actions by target host.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"action", "host"})
+
+	outboundRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "outbound_rejections_total",
+		Help:      "Number of outbound actions rejected before being sent by target host and reason.",
+	}, []string{"host", "reason"})
+
+	circuitOpen = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "circuit_open",
+		Help:      "Whether the circuit of a target host is open (1) or closed (0).",
+	}, []string{"host"})
+
+	mongoDbDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "mongodb_command_duration_seconds",
+		Help:      "Latency of MongoDB commands by command name and status.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"command", "status"})
+)
+
+func init() {
+	prometheus.MustRegister(httpRequests, httpDuration, actionAttempts, actionResults, actionDuration, outboundRejections, circuitOpen, mongoDbDuration)
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
+
+// GinMetricsHandler measures the HTTP requests by route
+func GinMetricsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		httpRequests.WithLabelValues(route, c.Request.Method, status).Inc()
+		httpDuration.WithLabelValues(route, c.Request.Method, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// ObserveActionAttempt counts an outbound action about to be executed
+func ObserveActionAttempt(action string, host string) {
+	actionAttempts.WithLabelValues(action, host).Inc()
+}
+
+// ObserveActionResult measures an executed outbound action
+func ObserveActionResult(action string, host string, duration time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	actionResults.WithLabelValues(action, host, result).Inc()
+	actionDuration.WithLabelValues(action, host).Observe(duration.Seconds())
+}
+
+// ObserveOutboundRejection counts an outbound action which was not sent
+func ObserveOutboundRejection(host string, reason string) {
+	outboundRejections.WithLabelValues(host, reason).Inc()
+}
+
+// ObserveCircuit records the state of the circuit of a target host
+func ObserveCircuit(host string, open bool) {
+	value := 0.0
+	if open {
+		value = 1
+	}
+	circuitOpen.WithLabelValues(host).Set(value)
+}
+
+// ObserveMongoDbCommand measures an executed MongoDB command
+func ObserveMongoDbCommand(command string, duration time.Duration, err error) {
+	status := "success"
+	if err != nil {
+		status = "failure"
+	}
+	mongoDbDuration.WithLabelValues(command, status).Observe(duration.Seconds())
+}
+
+// PendingCounter returns the number of pending event callbacks of every plan
+type PendingCounter func(ctx context.Context) ([]int64, error)
+
+// RegisterPendingCallbacks exposes the number of plans by number of pending event callbacks, counted at scrape time
+func RegisterPendingCallbacks(counter PendingCounter) {
+	prometheus.MustRegister(&pendingCollector{counter: counter})
+}
+
+// pendingBuckets are the upper bounds of the buckets of plans by number of pending event callbacks
+var (
+	pendingBuckets     = []int64{1, 10, 100, 1000}
+	pendingBucketNames = []string{"1", "2-10", "11-100", "101-1000", "1001+"}
+)
+
+var (
+	pendingPlansDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "plans_pending_callbacks"),
+		"Number of plans by bucket of pending event callbacks.",
+		[]string{"bucket"}, nil,
+	)
+	pendingCallbacksDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "pending_callbacks"),
+		"Number of pending event callbacks.",
+		nil, nil,
+	)
+)
+
+type pendingCollector struct {
+	counter PendingCounter
+}
+
+func (collector *pendingCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- pendingPlansDesc
+	ch <- pendingCallbacksDesc
+}
+
+func (collector *pendingCollector) Collect(ch chan<- prometheus.Metric) {
+	counts, err := collector.counter(context.Background())
+	if err != nil {
+		logging.Logger.WithField("error", err).Error("Can't count the pending event callbacks")
+		return
+	}
+	plans := make([]int64, len(pendingBuckets)+1)
+	var total int64
+	for _, count := range counts {
+		total += count
+		bucket := len(pendingBuckets)
+		for i, bound := range pendingBuckets {
+			if count <= bound {
+				bucket = i
+				break
+			}
+		}
+		plans[bucket]++
+	}
+	for i, name := range pendingBucketNames {
+		ch <- prometheus.MustNewConstMetric(pendingPlansDesc, prometheus.GaugeValue, float64(plans[i]), name)
+	}
+	ch <- prometheus.MustNewConstMetric(pendingCallbacksDesc, prometheus.GaugeValue, float64(total))
+}
@@ -0,0 +1,132 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// applyNode is an event callback to apply, with the event callbacks which have to wait for it
+type applyNode struct {
+	index         int
+	eventCallback EventCallback
+	waiting       int
+	next          []*applyNode
+}
+
+// buildApplyGraph orders the pending events of a plan: an event waits for the events it depends on,
+// and for the previous event of the same resource. The dependencies which are not pending any more are ignored.
+func buildApplyGraph(eventCallbacks []EventCallback) ([]*applyNode, error) {
+	nodes := make([]*applyNode, len(eventCallbacks))
+	byID := make(map[string]*applyNode, len(eventCallbacks))
+	for i, eventCallback := range eventCallbacks {
+		nodes[i] = &applyNode{index: i, eventCallback: eventCallback}
+		byID[eventCallback.ID] = nodes[i]
+	}
+	lastOfResource := map[string]*applyNode{}
+	for _, node := range nodes {
+		previous := map[*applyNode]bool{}
+		for _, dependency := range node.eventCallback.DependsOn {
+			if before, ok := byID[dependency]; ok && before != node {
+				previous[before] = true
+			}
+		}
+		resourceKey := node.eventCallback.resourceKey()
+		if before, ok := lastOfResource[resourceKey]; ok {
+			previous[before] = true
+		}
+		lastOfResource[resourceKey] = node
+		for before := range previous {
+			before.next = append(before.next, node)
+			node.waiting++
+		}
+	}
+	if cycle := findApplyCycle(nodes); len(cycle) > 0 {
+		return nil, fmt.Errorf("The events %s can never be applied, their dependencies form a cycle", strings.Join(cycle, ", "))
+	}
+	return nodes, nil
+}
+
+// findApplyCycle returns the events which can never be applied because of a dependency cycle
+func findApplyCycle(nodes []*applyNode) []string {
+	waiting := make(map[*applyNode]int, len(nodes))
+	ready := []*applyNode{}
+	for _, node := range nodes {
+		waiting[node] = node.waiting
+		if node.waiting == 0 {
+			ready = append(ready, node)
+		}
+	}
+	for len(ready) > 0 {
+		node := ready[0]
+		ready = ready[1:]
+		for _, next := range node.next {
+			waiting[next]--
+			if waiting[next] == 0 {
+				ready = append(ready, next)
+			}
+		}
+	}
+	cycle := []string{}
+	for _, node := range nodes {
+		if waiting[node] > 0 {
+			cycle = append(cycle, node.eventCallback.ID)
+		}
+	}
+	return cycle
+}
+
+// applyScheduler picks the next events to apply, within the limits of workers and of concurrent calls per host
+type applyScheduler struct {
+	ready   []*applyNode
+	running int
+	hosts   map[string]int
+}
+
+func newApplyScheduler(nodes []*applyNode) *applyScheduler {
+	scheduler := &applyScheduler{hosts: map[string]int{}}
+	for _, node := range nodes {
+		if node.waiting == 0 {
+			scheduler.ready = append(scheduler.ready, node)
+		}
+	}
+	return scheduler
+}
+
+// next returns the first ready event which can be applied now, in the order of creation
+func (scheduler *applyScheduler) next() *applyNode {
+	if scheduler.running >= applyWorkers {
+		return nil
+	}
+	var picked *applyNode
+	pickedAt := -1
+	for i, node := range scheduler.ready {
+		if applyHostConcurrency > 0 && scheduler.hosts[node.eventCallback.Parent.Host()] >= applyHostConcurrency {
+			continue
+		}
+		if picked == nil || node.index < picked.index {
+			picked, pickedAt = node, i
+		}
+	}
+	if picked == nil {
+		return nil
+	}
+	scheduler.ready = append(scheduler.ready[:pickedAt], scheduler.ready[pickedAt+1:]...)
+	scheduler.running++
+	scheduler.hosts[picked.eventCallback.Parent.Host()]++
+	return picked
+}
+
+// done releases the slot of an event, and makes the events waiting only for it ready when it was applied
+func (scheduler *applyScheduler) done(node *applyNode, applied bool) {
+	scheduler.running--
+	scheduler.hosts[node.eventCallback.Parent.Host()]--
+	if !applied {
+		return
+	}
+	for _, next := range node.next {
+		next.waiting--
+		if next.waiting == 0 {
+			scheduler.ready = append(scheduler.ready, next)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package model
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testEventCallback returns an event callback of its own resource, which depends on the given events
+func testEventCallback(id string, dependsOn ...string) EventCallback {
+	return EventCallback{
+		ID:        id,
+		PlanID:    "plan-1",
+		Parent:    Action{Method: "POST", URI: "http://parent.example.com/resources/" + id},
+		DependsOn: dependsOn,
+	}
+}
+
+// applyRounds applies the events as the workers of a run would, every round starting the events ready at once
+func applyRounds(t *testing.T, nodes []*applyNode) [][]string {
+	scheduler := newApplyScheduler(nodes)
+	rounds := [][]string{}
+	for {
+		started := []*applyNode{}
+		for node := scheduler.next(); node != nil; node = scheduler.next() {
+			started = append(started, node)
+		}
+		if len(started) == 0 {
+			break
+		}
+		round := []string{}
+		for _, node := range started {
+			round = append(round, node.eventCallback.ID)
+			scheduler.done(node, true)
+		}
+		rounds = append(rounds, round)
+		if len(rounds) > len(nodes) {
+			t.Fatalf("The events are still applied after %d rounds: %v", len(rounds), rounds)
+		}
+	}
+	return rounds
+}
+
+func TestApplyGraph(t *testing.T) {
+	defer func(workers int, concurrency int) {
+		applyWorkers, applyHostConcurrency = workers, concurrency
+	}(applyWorkers, applyHostConcurrency)
+	applyWorkers, applyHostConcurrency = 10, 0
+
+	sameResource := func(id string) EventCallback {
+		eventCallback := testEventCallback(id)
+		eventCallback.ResourceKey = "shift-1"
+		return eventCallback
+	}
+	tests := []struct {
+		name           string
+		eventCallbacks []EventCallback
+		rounds         [][]string
+		cycle          []string
+	}{
+		{
+			name:           "linear chain",
+			eventCallbacks: []EventCallback{testEventCallback("a"), testEventCallback("b", "a"), testEventCallback("c", "b")},
+			rounds:         [][]string{{"a"}, {"b"}, {"c"}},
+		},
+		{
+			name:           "diamond",
+			eventCallbacks: []EventCallback{testEventCallback("a"), testEventCallback("b", "a"), testEventCallback("c", "a"), testEventCallback("d", "b", "c")},
+			rounds:         [][]string{{"a"}, {"b", "c"}, {"d"}},
+		},
+		{
+			name:           "cycle",
+			eventCallbacks: []EventCallback{testEventCallback("a", "c"), testEventCallback("b", "a"), testEventCallback("c", "b"), testEventCallback("free")},
+			cycle:          []string{"a", "b", "c"},
+		},
+		{
+			name:           "missing dependency",
+			eventCallbacks: []EventCallback{testEventCallback("a"), testEventCallback("b", "applied-before")},
+			rounds:         [][]string{{"a", "b"}},
+		},
+		{
+			name:           "same resource",
+			eventCallbacks: []EventCallback{sameResource("a"), testEventCallback("b"), sameResource("c")},
+			rounds:         [][]string{{"a", "b"}, {"c"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			nodes, err := buildApplyGraph(test.eventCallbacks)
+			if test.cycle != nil {
+				if err == nil {
+					t.Fatal("The cycle was not detected")
+				}
+				if !strings.Contains(err.Error(), strings.Join(test.cycle, ", ")) {
+					t.Errorf("The error %q does not name the cycle %v", err, test.cycle)
+				}
+				if strings.Contains(err.Error(), "free") {
+					t.Errorf("The error %q names an event out of the cycle", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cycle := findApplyCycle(nodes); len(cycle) > 0 {
+				t.Errorf("The events %v were found in a cycle", cycle)
+			}
+			if rounds := applyRounds(t, nodes); !reflect.DeepEqual(rounds, test.rounds) {
+				t.Errorf("The events were applied in %v, instead of %v", rounds, test.rounds)
+			}
+		})
+	}
+}
+
+func TestApplySchedulerLimits(t *testing.T) {
+	defer func(workers int, concurrency int) {
+		applyWorkers, applyHostConcurrency = workers, concurrency
+	}(applyWorkers, applyHostConcurrency)
+
+	tests := []struct {
+		name        string
+		workers     int
+		concurrency int
+		rounds      [][]string
+	}{
+		{"sequential", 1, 0, [][]string{{"a"}, {"b"}, {"c"}}},
+		{"two workers", 2, 0, [][]string{{"a", "b"}, {"c"}}},
+		{"one call per host", 10, 1, [][]string{{"a"}, {"b"}, {"c"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			applyWorkers, applyHostConcurrency = test.workers, test.concurrency
+			nodes, err := buildApplyGraph([]EventCallback{testEventCallback("a"), testEventCallback("b"), testEventCallback("c")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rounds := applyRounds(t, nodes); !reflect.DeepEqual(rounds, test.rounds) {
+				t.Errorf("The events were applied in %v, instead of %v", rounds, test.rounds)
+			}
+		})
+	}
+}
+
+func TestApplySchedulerFailure(t *testing.T) {
+	defer func(workers int) { applyWorkers = workers }(applyWorkers)
+	applyWorkers = 10
+
+	nodes, err := buildApplyGraph([]EventCallback{testEventCallback("a"), testEventCallback("b", "a")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheduler := newApplyScheduler(nodes)
+	node := scheduler.next()
+	scheduler.done(node, false)
+	if next := scheduler.next(); next != nil {
+		t.Errorf("The event %s was started although the event it depends on has failed", next.eventCallback.ID)
+	}
+}
@@ -0,0 +1,182 @@
+package model
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"gitlab.kardinal.ai/coretech/esther/logging"
+	"gitlab.kardinal.ai/coretech/esther/persistence"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Statuses of an apply run
+const (
+	ApplyRunRunning     = "running"
+	ApplyRunCompleted   = "completed"
+	ApplyRunFailed      = "failed"
+	ApplyRunInterrupted = "interrupted"
+)
+
+// States of a pending event callback
+const (
+	// StatePending : the event callback has not been sent to its parent
+	StatePending = ""
+	// StateApplying : the event callback is being sent to its parent, or the apply run was interrupted while sending it
+	StateApplying = "applying"
+	// StateApplied : the parent has accepted the event callback, which could not be archived; it is archived again, never sent again
+	StateApplied = "applied"
+)
+
+// What is done on resume with the event callbacks whose apply was interrupted while being sent
+const (
+	InDoubtRetry      = "retry"
+	InDoubtDeadLetter = "deadletter"
+)
+
+// InstanceID identifies this instance of Esther, as the owner of the apply runs
+var InstanceID = newInstanceID()
+
+const defaultApplyRunStaleAfter = 5 * time.Minute
+
+var (
+	resumeInDoubt        string
+	applyRunStaleAfter   time.Duration
+	applyWorkers         int
+	applyHostConcurrency int
+)
+
+// ApplyRun represents the application of the event callbacks of a plan to its parent
+type ApplyRun struct {
+	ID     string `bson:"_id,omitempty" json:"id"`
+	PlanID string `json:"planId"`
+	Status string `json:"status"`
+	Owner  string `json:"owner"`
+	Actor  string `json:"actor,omitempty"`
+	// OnBehalfOf is the X-Actor of the request which started the run
+	OnBehalfOf string     `json:"onBehalfOf,omitempty"`
+	StartedAt  time.Time  `json:"startedAt"`
+	UpdatedAt  time.Time  `json:"updatedAt"`
+	EndedAt    *time.Time `json:"endedAt,omitempty"`
+	Applied    int        `json:"applied"`
+	Current    string     `json:"current,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// ApplyProgress reports the outcome of one event of an apply run
+type ApplyProgress struct {
+	Run             ApplyRun
+	EventCallbackID string
+	Applied         bool
+	Error           string
+}
+
+// ApplyProgressFunc receives the progress of an apply run
+type ApplyProgressFunc func(progress ApplyProgress)
+
+func init() {
+	persistence.Register(ApplyRun{})
+	initApplyRunEnv()
+}
+
+func initApplyRunEnv() []string {
+	errs := []string{}
+	envInDoubt := "RESUME_IN_DOUBT"
+	resumeInDoubt = os.Getenv(envInDoubt)
+	switch resumeInDoubt {
+	case "":
+		resumeInDoubt = InDoubtRetry
+	case InDoubtRetry, InDoubtDeadLetter:
+	default:
+		errs = append(errs, fmt.Sprintf("%s must be either %s or %s", envInDoubt, InDoubtRetry, InDoubtDeadLetter))
+		resumeInDoubt = InDoubtRetry
+	}
+	applyRunStaleAfter, errs = durationFromEnv("APPLY_RUN_STALE_AFTER", defaultApplyRunStaleAfter, errs)
+
+	applyWorkers = 1
+	envWorkers := "APPLY_WORKERS"
+	if value := os.Getenv(envWorkers); value != "" {
+		workers, err := strconv.Atoi(value)
+		if err != nil || workers < 1 {
+			errs = append(errs, fmt.Sprintf("%s must be a strictly positive integer", envWorkers))
+		} else {
+			applyWorkers = workers
+		}
+	}
+
+	applyHostConcurrency = 0
+	envHostConcurrency := "APPLY_HOST_CONCURRENCY"
+	if value := os.Getenv(envHostConcurrency); value != "" {
+		concurrency, err := strconv.Atoi(value)
+		if err != nil || concurrency < 0 {
+			errs = append(errs, fmt.Sprintf("%s must be a positive integer", envHostConcurrency))
+		} else {
+			applyHostConcurrency = concurrency
+		}
+	}
+	if len(errs) > 0 {
+		logging.Logger.WithField("errors", errs).Error("The apply run environment is not properly set")
+	}
+	return errs
+}
+
+func newInstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "esther"
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return hostname + "-" + hex.EncodeToString(suffix)
+}
+
+// Id : Get id
+func (run ApplyRun) Id() string {
+	return run.ID
+}
+
+// ResetId : Reset id (Has to create a new Instance)
+func (run ApplyRun) ResetId(ID string) persistence.Persistable {
+	run.ID = ID
+	return run
+}
+
+// PlanId : Get Plan Id
+func (run ApplyRun) PlanId() string {
+	return run.PlanID
+}
+
+// EntityName : Get entity name
+func (run ApplyRun) EntityName() string {
+	return "apply_run"
+}
+
+// Indexes : Get the indexes of the collection
+func (run ApplyRun) Indexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "planid", Value: 1}, {Key: "startedat", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "status", Value: 1}},
+		},
+	}
+}
+
+// FromBson : Transform to BSON
+func (run ApplyRun) FromBson(sr *mongo.SingleResult) persistence.Persistable {
+	err := sr.Decode(&run)
+	if err != nil {
+		logging.Logger.Error(fmt.Sprintf("Cannot unmarshal apply run : %s", err.Error()))
+	}
+	return run
+}
+
+// Finished : Check whether the run can not be resumed anymore
+func (run ApplyRun) Finished() bool {
+	return run.Status == ApplyRunCompleted
+}
@@ -0,0 +1,386 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gitlab.kardinal.ai/coretech/esther/logging"
+	"gitlab.kardinal.ai/coretech/esther/persistence"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const resumeActor = "esther:resume"
+
+var (
+	// ErrNoApplyRun is returned when a plan has no apply run to resume
+	ErrNoApplyRun = errors.New("no apply run to resume")
+	// ErrApplyInterrupted is returned when an apply run is interrupted by the cancellation of its context
+	ErrApplyInterrupted = errors.New("apply run interrupted")
+)
+
+// FindApplyRunsByPlanId Find all the apply runs of a plan, the latest first
+func FindApplyRunsByPlanId(ctx context.Context, scope Scope, planID string) ([]ApplyRun, error) {
+	collection := persistence.GetCollection(scope.TenantID, ApplyRun{})
+	ctx, cancel := persistence.GetContext(ctx)
+	defer cancel()
+	cur, err := collection.Find(ctx, bson.M{"planid": planID}, options.Find().SetSort(bson.D{{Key: "startedat", Value: -1}}))
+	if err != nil {
+		err := fmt.Sprintf("Can't find ApplyRun with plan-id: %s", planID)
+		logging.FromContext(ctx).Error(err)
+		return []ApplyRun{}, fmt.Errorf(err)
+	}
+	runs := make([]ApplyRun, 0)
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var run ApplyRun
+		if err := cur.Decode(&run); err != nil {
+			logging.FromContext(ctx).Error(err)
+		}
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+// ApplyPlan Apply the events of a plan to their parent, resuming the unfinished apply run of the plan if any
+func ApplyPlan(ctx context.Context, scope Scope, planID string) (ApplyRun, error) {
+	return ApplyPlanWithProgress(ctx, scope, planID, nil)
+}
+
+// ApplyPlanWithProgress Apply the events of a plan to their parent like ApplyPlan, reporting the outcome of every event to progress
+func ApplyPlanWithProgress(ctx context.Context, scope Scope, planID string, progress ApplyProgressFunc) (run ApplyRun, err error) {
+	err = withPlanLock(ctx, scope, planID, LockOperationApply, func(ctx context.Context) error {
+		run, err = claimPlanApplyRun(ctx, scope, planID)
+		if errors.Is(err, ErrNoApplyRun) {
+			run, err = startApplyRun(ctx, scope, planID)
+		}
+		if err != nil {
+			return err
+		}
+		run, err = runApply(ctx, scope, run, progress)
+		return err
+	})
+	return run, err
+}
+
+// ResumeApplyRun Resume the unfinished apply run of a plan from the event it stopped at
+func ResumeApplyRun(ctx context.Context, scope Scope, planID string) (run ApplyRun, err error) {
+	err = withPlanLock(ctx, scope, planID, LockOperationApply, func(ctx context.Context) error {
+		run, err = claimPlanApplyRun(ctx, scope, planID)
+		if err != nil {
+			return err
+		}
+		run, err = runApply(ctx, scope, run, nil)
+		return err
+	})
+	return run, err
+}
+
+// ResumeApplyRuns Resume the apply runs of every tenant which were interrupted, or whose owner has stopped
+// updating them for APPLY_RUN_STALE_AFTER, as happens when an instance crashes
+func ResumeApplyRuns(ctx context.Context) {
+	tenants, err := persistence.ListTenants(ctx)
+	if err != nil {
+		logging.FromContext(ctx).WithField("error", err).Error("The interrupted apply runs could not be listed")
+		return
+	}
+	for _, tenantID := range tenants {
+		scope := Scope{TenantID: tenantID, Actor: resumeActor}
+		// The plans locked by another instance are being applied, they are skipped
+		skipped := bson.A{}
+		for ctx.Err() == nil {
+			run, err := findApplyRun(ctx, scope, bson.M{
+				"planid": bson.M{"$nin": skipped},
+				"$or": bson.A{
+					bson.M{"status": ApplyRunInterrupted},
+					bson.M{"status": ApplyRunRunning, "updatedat": bson.M{"$lt": time.Now().UTC().Add(-applyRunStaleAfter)}},
+				},
+			})
+			if err != nil {
+				if !errors.Is(err, ErrNoApplyRun) {
+					logging.FromContext(ctx).WithFields(logging.LogFields{"tenant": tenantID, "error": err}).Error("The interrupted apply runs could not be resumed")
+				}
+				break
+			}
+			skipped = append(skipped, run.PlanID)
+			logging.FromContext(ctx).WithFields(logging.LogFields{
+				"tenant": tenantID,
+				"planId": run.PlanID,
+				"run":    run.ID,
+			}).Info("Resuming an interrupted apply run")
+			if _, err := ResumeApplyRun(ctx, scope, run.PlanID); err != nil {
+				logging.FromContext(ctx).WithFields(logging.LogFields{
+					"tenant": tenantID,
+					"planId": run.PlanID,
+					"run":    run.ID,
+					"error":  err,
+				}).Warn("The resumed apply run has not completed")
+			}
+		}
+	}
+}
+
+// runApply applies the pending events of the plan of a run, checkpointing each of them before calling its parent.
+// The events which do not depend on each other are applied concurrently, up to APPLY_WORKERS at once.
+// An event found in the applying state was being sent when a previous run stopped: it is handled according to RESUME_IN_DOUBT.
+// progress, if any, is called by the goroutine of the run once each event is applied or has failed.
+func runApply(ctx context.Context, scope Scope, run ApplyRun, progress ApplyProgressFunc) (ApplyRun, error) {
+	eventCallbacks, err := FindEventCallbacksByPlanId(ctx, scope, run.PlanID)
+	if err != nil {
+		return endApplyRun(ctx, scope, run, ApplyRunFailed, err)
+	}
+	nodes, err := buildApplyGraph(eventCallbacks)
+	if err != nil {
+		return endApplyRun(ctx, scope, run, ApplyRunFailed, err)
+	}
+
+	scheduler := newApplyScheduler(nodes)
+	outcomes := make(chan applyOutcome)
+	var failure, interruption error
+	for {
+		for failure == nil && interruption == nil {
+			if ctx.Err() != nil {
+				interruption = fmt.Errorf("%w: %s", ErrApplyInterrupted, ctx.Err())
+				break
+			}
+			node := scheduler.next()
+			if node == nil {
+				break
+			}
+			eventCallback := node.eventCallback
+			if eventCallback.State == StateApplying && resumeInDoubt == InDoubtDeadLetter {
+				failure = deadLetterInDoubtEventCallback(ctx, scope, eventCallback)
+				scheduler.done(node, failure == nil)
+				continue
+			}
+			if eventCallback.State == StateApplied {
+				// The parent has already accepted it, only its archiving is left
+				if failure = archiveAppliedEventCallback(ctx, scope, eventCallback); failure == nil {
+					run.Applied++
+				}
+				scheduler.done(node, failure == nil)
+				continue
+			}
+
+			eventCallback.State = StateApplying
+			eventCallback.ApplyRunID = run.ID
+			run.Current = eventCallback.ID
+			if failure = setEventCallbackState(ctx, scope, eventCallback); failure == nil {
+				failure = saveApplyRun(ctx, scope, &run)
+			}
+			if failure != nil {
+				scheduler.done(node, false)
+				break
+			}
+			go func(node *applyNode, eventCallback EventCallback) {
+				outcomes <- applyOutcome{node: node, err: applyCheckpointedEventCallback(ctx, scope, eventCallback)}
+			}(node, eventCallback)
+		}
+		if scheduler.running == 0 {
+			break
+		}
+
+		outcome := <-outcomes
+		scheduler.done(outcome.node, outcome.err == nil)
+		switch {
+		case outcome.err == nil:
+			run.Applied++
+		case ctx.Err() != nil:
+			if interruption == nil {
+				interruption = fmt.Errorf("%w: %s", ErrApplyInterrupted, outcome.err)
+			}
+		case failure == nil:
+			failure = outcome.err
+		}
+		if progress != nil {
+			report := ApplyProgress{Run: run, EventCallbackID: outcome.node.eventCallback.ID, Applied: outcome.err == nil}
+			if outcome.err != nil {
+				report.Error = outcome.err.Error()
+			}
+			progress(report)
+		}
+	}
+
+	switch {
+	case failure != nil:
+		return endApplyRun(ctx, scope, run, ApplyRunFailed, failure)
+	case interruption != nil:
+		return endApplyRun(ctx, scope, run, ApplyRunInterrupted, interruption)
+	}
+	run.Current = ""
+	return endApplyRun(ctx, scope, run, ApplyRunCompleted, nil)
+}
+
+// applyOutcome is the result of the application of one event of a run
+type applyOutcome struct {
+	node *applyNode
+	err  error
+}
+
+// applyCheckpointedEventCallback applies an event checkpointed in the applying state.
+// When the parent has failed, the event is pending again; when the run was canceled meanwhile, it stays in doubt;
+// when the parent has accepted it but it could not be archived, it is marked applied so that it is not sent again.
+func applyCheckpointedEventCallback(ctx context.Context, scope Scope, eventCallback EventCallback) error {
+	err := ApplyEventCallback(ctx, scope, eventCallback)
+	if errors.Is(err, ErrNotArchived) {
+		eventCallback.State = StateApplied
+		if err := setEventCallbackState(persistence.Detach(ctx), scope, eventCallback); err != nil {
+			logging.FromContext(ctx).WithField("error", err).Error("The state of the applied event callback could not be saved")
+		}
+		return fmt.Errorf("Error during application of event %s: %w", eventCallback.ID, err)
+	}
+	if err == nil || ctx.Err() != nil {
+		return err
+	}
+	eventCallback.State = StatePending
+	eventCallback.ApplyRunID = ""
+	if err := setEventCallbackState(persistence.Detach(ctx), scope, eventCallback); err != nil {
+		logging.FromContext(ctx).WithField("error", err).Error("The state of the failed event callback could not be reset")
+	}
+	return fmt.Errorf("Error during application of event %s: %w", eventCallback.ID, err)
+}
+
+func startApplyRun(ctx context.Context, scope Scope, planID string) (ApplyRun, error) {
+	now := time.Now().UTC()
+	run := ApplyRun{
+		PlanID:     planID,
+		Status:     ApplyRunRunning,
+		Owner:      InstanceID,
+		Actor:      scope.Actor,
+		OnBehalfOf: scope.OnBehalfOf,
+		StartedAt:  now,
+		UpdatedAt:  now,
+	}
+	id := persistence.InsertOne(ctx, scope.TenantID, run)
+	if id == "" {
+		return ApplyRun{}, fmt.Errorf("Can't start the apply run of plan %s", planID)
+	}
+	run.ID = id
+	return run, nil
+}
+
+// claimPlanApplyRun takes the ownership of the unfinished run of a plan, the lock of the plan must be held:
+// a run still running was left by an instance which has lost the lock, a failed run is resumed and is not ended anymore
+func claimPlanApplyRun(ctx context.Context, scope Scope, planID string) (ApplyRun, error) {
+	collection := persistence.GetCollection(scope.TenantID, ApplyRun{})
+	ctx, cancel := persistence.GetContext(ctx)
+	defer cancel()
+	var run ApplyRun
+	err := collection.FindOneAndUpdate(ctx,
+		bson.M{"planid": planID, "status": bson.M{"$in": bson.A{ApplyRunRunning, ApplyRunInterrupted, ApplyRunFailed}}},
+		bson.M{
+			"$set": bson.M{
+				"status":    ApplyRunRunning,
+				"owner":     InstanceID,
+				"updatedat": time.Now().UTC(),
+				"error":     "",
+			},
+			"$unset": bson.M{"endedat": ""},
+		},
+		options.FindOneAndUpdate().
+			SetSort(bson.D{{Key: "startedat", Value: 1}}).
+			SetReturnDocument(options.After)).Decode(&run)
+	if err == mongo.ErrNoDocuments {
+		return ApplyRun{}, fmt.Errorf("%w: plan %s", ErrNoApplyRun, planID)
+	}
+	if err != nil {
+		return ApplyRun{}, fmt.Errorf("Can't claim the apply run of plan %s: %s", planID, err)
+	}
+	return run, nil
+}
+
+func findApplyRun(ctx context.Context, scope Scope, filter bson.M) (ApplyRun, error) {
+	collection := persistence.GetCollection(scope.TenantID, ApplyRun{})
+	ctx, cancel := persistence.GetContext(ctx)
+	defer cancel()
+	var run ApplyRun
+	err := collection.FindOne(ctx, filter, options.FindOne().SetSort(bson.D{{Key: "startedat", Value: 1}})).Decode(&run)
+	if err == mongo.ErrNoDocuments {
+		return ApplyRun{}, ErrNoApplyRun
+	}
+	if err != nil {
+		return ApplyRun{}, fmt.Errorf("Can't find the apply runs of tenant %s: %s", scope.TenantID, err)
+	}
+	return run, nil
+}
+
+// saveApplyRun checkpoints the progress of a run
+func saveApplyRun(ctx context.Context, scope Scope, run *ApplyRun) error {
+	run.UpdatedAt = time.Now().UTC()
+	if persistence.ReplaceOne(ctx, scope.TenantID, *run) == false {
+		return fmt.Errorf("Can't save the apply run %s of plan %s", run.ID, run.PlanID)
+	}
+	return nil
+}
+
+// endApplyRun records the final status of a run, even if ctx was canceled, and returns the error which ended it
+func endApplyRun(ctx context.Context, scope Scope, run ApplyRun, status string, runErr error) (ApplyRun, error) {
+	ctx = persistence.Detach(ctx)
+	run.Status = status
+	if status != ApplyRunInterrupted {
+		endedAt := time.Now().UTC()
+		run.EndedAt = &endedAt
+	}
+	if runErr != nil {
+		run.Error = runErr.Error()
+	}
+	err := persistence.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := saveApplyRun(ctx, scope, &run); err != nil {
+			return err
+		}
+		return notifyApplyRunEnd(ctx, scope, run)
+	})
+	if err != nil {
+		logging.FromContext(ctx).WithField("error", err).Error("The end of the apply run could not be saved")
+	}
+	logging.FromContext(ctx).WithFields(logging.LogFields{
+		"planId":  run.PlanID,
+		"run":     run.ID,
+		"status":  run.Status,
+		"applied": run.Applied,
+	}).Info("Apply run ended")
+	return run, runErr
+}
+
+// setEventCallbackState checkpoints the state of an event without touching the rest of it
+func setEventCallbackState(ctx context.Context, scope Scope, eventCallback EventCallback) error {
+	id, err := primitive.ObjectIDFromHex(eventCallback.ID)
+	if err != nil {
+		return fmt.Errorf("Can't retrieve HEX ObjectId : %s", eventCallback.ID)
+	}
+	collection := persistence.GetCollection(scope.TenantID, EventCallback{})
+	ctx, cancel := persistence.GetContext(ctx)
+	defer cancel()
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": id, "planid": eventCallback.PlanID}, bson.M{"$set": bson.M{
+		"state":      eventCallback.State,
+		"applyrunid": eventCallback.ApplyRunID,
+	}})
+	if err != nil {
+		return fmt.Errorf("Can't checkpoint the event-callback %s in plan %s: %s", eventCallback.ID, eventCallback.PlanID, err)
+	}
+	return nil
+}
+
+// archiveAppliedEventCallback archives an event accepted by its parent during a previous run, whose response is lost
+func archiveAppliedEventCallback(ctx context.Context, scope Scope, eventCallback EventCallback) error {
+	if err := archiveEventCallback(persistence.Detach(ctx), scope, eventCallback, ActionResponse{}); err != nil {
+		return fmt.Errorf("%w: event %s: %s", ErrNotArchived, eventCallback.ID, err)
+	}
+	return nil
+}
+
+// deadLetterInDoubtEventCallback moves an event which may have been sent to its parent to the dead letters, for a manual check
+func deadLetterInDoubtEventCallback(ctx context.Context, scope Scope, eventCallback EventCallback) error {
+	reason := fmt.Sprintf("The apply run %s was interrupted while sending the event callback to its parent", eventCallback.ApplyRunID)
+	result := ApplyResult{Success: false, Error: reason}
+	return persistence.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := recordHistory(ctx, scope, OperationApply, eventCallback.PlanID, eventCallback.ID, &eventCallback, nil, &result); err != nil {
+			return err
+		}
+		return deadLetterEventCallback(ctx, scope, eventCallback, reason)
+	})
+}
@@ -0,0 +1,87 @@
+package model
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gitlab.kardinal.ai/coretech/esther/logging"
+	"gitlab.kardinal.ai/coretech/esther/persistence"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultArchiveRetention     = 30 * 24 * time.Hour
+	defaultArchivePurgeInterval = time.Hour
+)
+
+var (
+	archiveRetention     time.Duration
+	archivePurgeInterval time.Duration
+)
+
+// ArchivedEventCallback represents an event callback which has been applied to its parent
+type ArchivedEventCallback struct {
+	ID            string          `bson:"_id,omitempty" json:"id"`
+	PlanID        string          `json:"planId"`
+	EventCallback EventCallback   `json:"eventCallback"`
+	AppliedAt     time.Time       `json:"appliedAt"`
+	Response      *ActionResponse `json:"response,omitempty"`
+}
+
+func init() {
+	persistence.Register(ArchivedEventCallback{})
+	initArchiveEnv()
+}
+
+func initArchiveEnv() []string {
+	errs := []string{}
+	archiveRetention, errs = durationFromEnv("ARCHIVE_RETENTION", defaultArchiveRetention, errs)
+	archivePurgeInterval, errs = durationFromEnv("ARCHIVE_PURGE_INTERVAL", defaultArchivePurgeInterval, errs)
+	if len(errs) > 0 {
+		logging.Logger.WithField("errors", errs).Error("The archive environment is not properly set")
+	}
+	return errs
+}
+
+func durationFromEnv(env string, defaultValue time.Duration, errs []string) (time.Duration, []string) {
+	value := os.Getenv(env)
+	if value == "" {
+		return defaultValue, errs
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return defaultValue, append(errs, fmt.Sprintf("%s must be a positive duration", env))
+	}
+	return duration, errs
+}
+
+// Id : Get id
+func (archived ArchivedEventCallback) Id() string {
+	return archived.ID
+}
+
+// ResetId : Reset id (Has to create a new Instance)
+func (archived ArchivedEventCallback) ResetId(ID string) persistence.Persistable {
+	archived.ID = ID
+	return archived
+}
+
+// PlanId : Get Plan Id
+func (archived ArchivedEventCallback) PlanId() string {
+	return archived.PlanID
+}
+
+// EntityName : Get entity name
+func (archived ArchivedEventCallback) EntityName() string {
+	return "event_callback_archive"
+}
+
+// FromBson : Transform to BSON
+func (archived ArchivedEventCallback) FromBson(sr *mongo.SingleResult) persistence.Persistable {
+	err := sr.Decode(&archived)
+	if err != nil {
+		logging.Logger.Error(fmt.Sprintf("Cannot unmarshal archived event callback : %s", err.Error()))
+	}
+	return archived
+}
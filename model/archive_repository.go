@@ -0,0 +1,110 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gitlab.kardinal.ai/coretech/esther/logging"
+	"gitlab.kardinal.ai/coretech/esther/persistence"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrNotArchived is returned when an event callback accepted by its parent could not be archived
+var ErrNotArchived = errors.New("applied event callback not archived")
+
+// FindArchivedEventCallbacksByPlanId Find all the applied events of a plan, the latest first
+func FindArchivedEventCallbacksByPlanId(ctx context.Context, scope Scope, planID string) ([]ArchivedEventCallback, error) {
+	collection := persistence.GetCollection(scope.TenantID, ArchivedEventCallback{})
+	ctx, cancel := persistence.GetContext(ctx)
+	defer cancel()
+	cur, err := collection.Find(ctx, bson.M{"planid": planID}, options.Find().SetSort(bson.D{{Key: "appliedat", Value: -1}}))
+	if err != nil {
+		err := fmt.Sprintf("Can't find archived EventCallback with plan-id: %s", planID)
+		logging.FromContext(ctx).Error(err)
+		return []ArchivedEventCallback{}, fmt.Errorf(err)
+	}
+	archived := make([]ArchivedEventCallback, 0)
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var archivedEventCallback ArchivedEventCallback
+		if err := cur.Decode(&archivedEventCallback); err != nil {
+			logging.FromContext(ctx).Error(err)
+		}
+		archived = append(archived, archivedEventCallback)
+	}
+	return archived, nil
+}
+
+// PurgeArchivedEventCallbacks Delete the applied events of every tenant which are older than the retention period
+func PurgeArchivedEventCallbacks(ctx context.Context) error {
+	tenants, err := persistence.ListTenants(ctx)
+	if err != nil {
+		return err
+	}
+	before := time.Now().Add(-archiveRetention)
+	for _, tenantID := range tenants {
+		collection := persistence.GetCollection(tenantID, ArchivedEventCallback{})
+		ctx, cancel := persistence.GetContext(ctx)
+		dr, err := collection.DeleteMany(ctx, bson.M{"appliedat": bson.M{"$lt": before}})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("Can't purge the archived event-callbacks of tenant %s: %s", tenantID, err)
+		}
+		if dr.DeletedCount > 0 {
+			logging.FromContext(ctx).WithFields(logging.LogFields{
+				"tenant":  tenantID,
+				"deleted": dr.DeletedCount,
+				"before":  before,
+			}).Info("Archived event callbacks purged")
+		}
+	}
+	return nil
+}
+
+// StartArchivePurge Purge the archived events periodically in the background, until ctx is done
+func StartArchivePurge(ctx context.Context) {
+	logging.Logger.WithFields(logging.LogFields{
+		"retention": archiveRetention.String(),
+		"interval":  archivePurgeInterval.String(),
+	}).Info("Starting the purge of archived event callbacks")
+	go func() {
+		ticker := time.NewTicker(archivePurgeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := PurgeArchivedEventCallbacks(ctx); err != nil {
+					logging.Logger.WithField("error", err).Error("The purge of archived event callbacks has failed")
+				}
+			}
+		}
+	}()
+}
+
+// archiveEventCallback moves an applied event from the pending ones to the archive
+func archiveEventCallback(ctx context.Context, scope Scope, eventCallback EventCallback, response ActionResponse) error {
+	archived := ArchivedEventCallback{
+		PlanID:        eventCallback.PlanID,
+		EventCallback: eventCallback,
+		AppliedAt:     time.Now().UTC(),
+		Response:      &response,
+	}
+	return persistence.WithTransaction(ctx, func(ctx context.Context) error {
+		if persistence.InsertOne(ctx, scope.TenantID, archived) == "" {
+			return fmt.Errorf("Can't archive the event-callback %s in plan %s", eventCallback.ID, eventCallback.PlanID)
+		}
+		if persistence.DeleteOne(ctx, scope.TenantID, eventCallback) == false {
+			return fmt.Errorf("Can't remove the archived event-callback %s from plan %s", eventCallback.ID, eventCallback.PlanID)
+		}
+		result := ApplyResult{Success: true, Response: &response}
+		if err := recordHistory(ctx, scope, OperationApply, eventCallback.PlanID, eventCallback.ID, &eventCallback, nil, &result); err != nil {
+			return err
+		}
+		return recordOutboxEvent(ctx, scope, EventCallbackApplied, eventCallback)
+	})
+}
@@ -0,0 +1,211 @@
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BundleVersion is the version of the bundles exported, bumped when their format changes incompatibly
+const BundleVersion = 1
+
+// NDJSONContentType is the content type of the bundles exported one record per line
+const NDJSONContentType = "application/x-ndjson"
+
+// ErrInvalidBundle is returned when a bundle imported is malformed, of an unsupported version, or altered
+var ErrInvalidBundle = errors.New("invalid bundle")
+
+// BundleHeader holds the metadata of a bundle, it is the first line of a NDJSON bundle
+type BundleHeader struct {
+	Version    int       `json:"version"`
+	PlanID     string    `json:"planId"`
+	ExportedAt time.Time `json:"exportedAt"`
+	ExportedBy string    `json:"exportedBy,omitempty"`
+	Count      int       `json:"count"`
+	// Checksum is the SHA-256 of the event callbacks, each one encoded as compact JSON and followed by a new line, as in a NDJSON bundle
+	Checksum string `json:"checksum"`
+}
+
+// Bundle holds the pending event callbacks of a plan, exported to be imported in another plan or environment
+type Bundle struct {
+	BundleHeader
+	EventCallbacks []BundledEventCallback `json:"eventCallbacks"`
+}
+
+// BundledEventCallback is an event callback exported, with its position in the plan, the ones of a bundle are imported in this order
+type BundledEventCallback struct {
+	Sequence int `json:"sequence"`
+	EventCallback
+}
+
+// newBundle returns the bundle of the event callbacks of a plan, in their order of creation
+func newBundle(planID string, actor string, eventCallbacks []EventCallback) (Bundle, error) {
+	bundle := Bundle{
+		BundleHeader: BundleHeader{
+			Version:    BundleVersion,
+			PlanID:     planID,
+			ExportedAt: time.Now().UTC(),
+			ExportedBy: actor,
+			Count:      len(eventCallbacks),
+		},
+		EventCallbacks: make([]BundledEventCallback, 0, len(eventCallbacks)),
+	}
+	records := make([]json.RawMessage, 0, len(eventCallbacks))
+	for i, eventCallback := range eventCallbacks {
+		// The checkpoints of the apply runs do not apply to the plan the events are imported into
+		eventCallback.State = ""
+		eventCallback.ApplyRunID = ""
+		bundled := BundledEventCallback{Sequence: i + 1, EventCallback: eventCallback}
+		record, err := json.Marshal(bundled)
+		if err != nil {
+			return Bundle{}, err
+		}
+		bundle.EventCallbacks = append(bundle.EventCallbacks, bundled)
+		records = append(records, record)
+	}
+	checksum, err := bundleChecksum(records)
+	if err != nil {
+		return Bundle{}, err
+	}
+	bundle.Checksum = checksum
+	return bundle, nil
+}
+
+// WriteNDJSON writes the bundle as NDJSON: its header on the first line, then one event callback per line
+func (bundle Bundle) WriteNDJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(bundle.BundleHeader); err != nil {
+		return err
+	}
+	for _, eventCallback := range bundle.EventCallbacks {
+		if err := encoder.Encode(eventCallback); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadBundle reads a bundle in JSON, or in NDJSON when ndjson is set, and checks its version, its count and its checksum.
+// Its event callbacks are returned in their order in the plan exported.
+func ReadBundle(r io.Reader, ndjson bool) (Bundle, error) {
+	var header BundleHeader
+	var records []json.RawMessage
+	if ndjson {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		first := true
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			if first {
+				if err := json.Unmarshal(line, &header); err != nil {
+					return Bundle{}, fmt.Errorf("%w: the header could not be read: %s", ErrInvalidBundle, err)
+				}
+				first = false
+				continue
+			}
+			records = append(records, append(json.RawMessage(nil), line...))
+		}
+		if err := scanner.Err(); err != nil {
+			return Bundle{}, fmt.Errorf("%w: %s", ErrInvalidBundle, err)
+		}
+	} else {
+		var raw struct {
+			BundleHeader
+			EventCallbacks []json.RawMessage `json:"eventCallbacks"`
+		}
+		if err := json.NewDecoder(r).Decode(&raw); err != nil {
+			return Bundle{}, fmt.Errorf("%w: %s", ErrInvalidBundle, err)
+		}
+		header, records = raw.BundleHeader, raw.EventCallbacks
+	}
+
+	if header.Version < 1 || header.Version > BundleVersion {
+		return Bundle{}, fmt.Errorf("%w: the version %d is not supported, the versions supported are 1 to %d", ErrInvalidBundle, header.Version, BundleVersion)
+	}
+	if header.Count != len(records) {
+		return Bundle{}, fmt.Errorf("%w: it holds %d event callbacks instead of %d", ErrInvalidBundle, len(records), header.Count)
+	}
+	checksum, err := bundleChecksum(records)
+	if err != nil {
+		return Bundle{}, fmt.Errorf("%w: %s", ErrInvalidBundle, err)
+	}
+	if checksum != header.Checksum {
+		return Bundle{}, fmt.Errorf("%w: its checksum %s does not match its content %s", ErrInvalidBundle, header.Checksum, checksum)
+	}
+
+	bundle := Bundle{BundleHeader: header, EventCallbacks: make([]BundledEventCallback, 0, len(records))}
+	sequences := map[int]bool{}
+	for _, record := range records {
+		var eventCallback BundledEventCallback
+		if err := json.Unmarshal(record, &eventCallback); err != nil {
+			return Bundle{}, fmt.Errorf("%w: %s", ErrInvalidBundle, err)
+		}
+		if sequences[eventCallback.Sequence] {
+			return Bundle{}, fmt.Errorf("%w: the sequence %d is held by several event callbacks", ErrInvalidBundle, eventCallback.Sequence)
+		}
+		sequences[eventCallback.Sequence] = true
+		bundle.EventCallbacks = append(bundle.EventCallbacks, eventCallback)
+	}
+	sort.SliceStable(bundle.EventCallbacks, func(i, j int) bool {
+		return bundle.EventCallbacks[i].Sequence < bundle.EventCallbacks[j].Sequence
+	})
+	return bundle, nil
+}
+
+// bundleChecksum returns the checksum of the event callbacks of a bundle, whatever the indentation of their JSON
+func bundleChecksum(records []json.RawMessage) (string, error) {
+	hash := sha256.New()
+	var compact bytes.Buffer
+	for _, record := range records {
+		compact.Reset()
+		if err := json.Compact(&compact, record); err != nil {
+			return "", err
+		}
+		compact.WriteByte('\n')
+		hash.Write(compact.Bytes())
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// ImportOptions are the changes done to the event callbacks of a bundle when they are imported
+type ImportOptions struct {
+	// IDMap maps ids of the bundle to event callbacks already pending in the target plan, which the dependencies on them then refer to
+	IDMap map[string]string
+	// URIRewrites replace the start of the URIs of the actions, the first one matching is applied
+	URIRewrites []URIRewrite
+}
+
+// URIRewrite replaces the URIs starting with From, e.g. the host of an environment, with To
+type URIRewrite struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// ImportResult is the outcome of the import of a bundle
+type ImportResult struct {
+	PlanID string `json:"planId"`
+	// IDMap maps the ids of the bundle to the ids of the event callbacks created
+	IDMap          map[string]string `json:"idMap"`
+	EventCallbacks []EventCallback   `json:"eventCallbacks"`
+}
+
+// rewriteURI applies the first rewrite matching uri
+func (options ImportOptions) rewriteURI(uri string) string {
+	for _, rewrite := range options.URIRewrites {
+		if strings.HasPrefix(uri, rewrite.From) {
+			return rewrite.To + strings.TrimPrefix(uri, rewrite.From)
+		}
+	}
+	return uri
+}
@@ -0,0 +1,77 @@
+package model
+
+import (
+	"context"
+	"fmt"
+
+	"gitlab.kardinal.ai/coretech/esther/logging"
+	"gitlab.kardinal.ai/coretech/esther/persistence"
+)
+
+// ExportPlan Export the pending events of a plan into a bundle, in their order of creation
+func ExportPlan(ctx context.Context, scope Scope, planID string) (Bundle, error) {
+	eventCallbacks, err := FindEventCallbacksByPlanId(ctx, scope, planID)
+	if err != nil {
+		return Bundle{}, err
+	}
+	bundle, err := newBundle(planID, scope.Actor, eventCallbacks)
+	if err != nil {
+		return Bundle{}, fmt.Errorf("Can't export the plan %s: %s", planID, err)
+	}
+	logging.FromContext(ctx).WithFields(logging.LogFields{
+		"planId":   planID,
+		"exported": bundle.Count,
+	}).Info("Plan exported")
+	return bundle, nil
+}
+
+// ImportBundle Create the events of a bundle in a plan, in their order in the bundle, with new ids.
+// The dependencies between the events of the bundle are changed to their new ids, the other ones must be mapped by options.IDMap.
+// The events are created in a single transaction, none is created when one fails.
+func ImportBundle(ctx context.Context, scope Scope, planID string, bundle Bundle, options ImportOptions) (ImportResult, error) {
+	var result ImportResult
+	err := persistence.WithTransaction(ctx, func(ctx context.Context) error {
+		// The transaction is retried as a whole
+		result = ImportResult{PlanID: planID, IDMap: map[string]string{}, EventCallbacks: make([]EventCallback, 0, len(bundle.EventCallbacks))}
+		ids := map[string]string{}
+		for exportedID, id := range options.IDMap {
+			ids[exportedID] = id
+		}
+		for _, bundled := range bundle.EventCallbacks {
+			eventCallback := bundled.EventCallback
+			exportedID := eventCallback.ID
+			eventCallback.ID = ""
+			eventCallback.Parent.URI = options.rewriteURI(eventCallback.Parent.URI)
+			eventCallback.Undo.URI = options.rewriteURI(eventCallback.Undo.URI)
+			dependsOn := make([]string, 0, len(eventCallback.DependsOn))
+			for _, dependency := range eventCallback.DependsOn {
+				if id, ok := ids[dependency]; ok {
+					dependency = id
+				}
+				dependsOn = append(dependsOn, dependency)
+			}
+			if len(dependsOn) > 0 {
+				eventCallback.DependsOn = dependsOn
+			}
+			created, err := CreateEventCallback(ctx, scope, planID, eventCallback)
+			if err != nil {
+				return fmt.Errorf("The event-callback %s (sequence %d) of the bundle could not be imported: %w", exportedID, bundled.Sequence, err)
+			}
+			if exportedID != "" {
+				ids[exportedID] = created.ID
+				result.IDMap[exportedID] = created.ID
+			}
+			result.EventCallbacks = append(result.EventCallbacks, created)
+		}
+		return nil
+	})
+	if err != nil {
+		return ImportResult{}, err
+	}
+	logging.FromContext(ctx).WithFields(logging.LogFields{
+		"planId":     planID,
+		"sourcePlan": bundle.PlanID,
+		"imported":   len(result.EventCallbacks),
+	}).Info("Bundle imported")
+	return result, nil
+}
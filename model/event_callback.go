@@ -1,33 +1,94 @@
 package model
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
+	"net/url"
+	"time"
 
 	"gitlab.kardinal.ai/coretech/esther/logging"
+	"gitlab.kardinal.ai/coretech/esther/metrics"
+	"gitlab.kardinal.ai/coretech/esther/outbound"
 	"gitlab.kardinal.ai/coretech/esther/persistence"
+	"gitlab.kardinal.ai/coretech/esther/tracing"
+	"gitlab.kardinal.ai/coretech/esther/transport"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/otel/label"
 )
 
+const (
+	actionParent = "parent"
+	actionUndo   = "undo"
+
+	defaultActionTimeout = 30 * time.Second
+)
+
+var actionTimeout time.Duration
+
+func init() {
+	persistence.Register(EventCallback{})
+	var errs []string
+	if actionTimeout, errs = durationFromEnv("ACTION_TIMEOUT", defaultActionTimeout, nil); len(errs) > 0 {
+		logging.Logger.WithField("errors", errs).Error("The action environment is not properly set")
+	}
+}
+
 // EventCallback represents a JSON input/output payload of an event callback
 type EventCallback struct {
-	ID     string `bson:"_id,omitempty" json:"id"`
-	PlanID string `json:"planId"`
-	Title  string `json:"title"`
-	Undo   Action `json:"undo"`
-	Parent Action `json:"parent"`
+	ID        string     `bson:"_id,omitempty" json:"id"`
+	PlanID    string     `json:"planId"`
+	Title     string     `json:"title"`
+	Undo      Action     `json:"undo"`
+	Parent    Action     `json:"parent"`
+	ExpiresAt *time.Time `bson:",omitempty" json:"expiresAt,omitempty"`
+	OnExpire  string     `bson:",omitempty" json:"onExpire,omitempty"`
+	// DependsOn are the events of the plan to apply before this one, ResourceKey orders the events of a same resource (the parent URI by default)
+	DependsOn   []string `bson:",omitempty" json:"dependsOn,omitempty"`
+	ResourceKey string   `bson:",omitempty" json:"resourceKey,omitempty"`
+	// State and ApplyRunID are checkpointed by the apply runs, they cannot be set through the API
+	State      string `bson:",omitempty" json:"state,omitempty"`
+	ApplyRunID string `bson:",omitempty" json:"applyRunId,omitempty"`
 }
 
-// Action represents a possible action (apply to parent, or undo)
+// Actions done on the event callbacks which have expired
+const (
+	ExpiryActionDelete     = "delete"
+	ExpiryActionUndo       = "undo"
+	ExpiryActionDeadLetter = "deadletter"
+)
+
+// Action represents a possible action (apply to parent, or undo), sent with HTTP or published to a message queue
 type Action struct {
-	PlanID  string      `json:"planId"`
-	Method  string      `json:"method"`
-	URI     string      `json:"uri"`
-	Payload primitive.M `json:"payload"`
+	PlanID    string            `json:"planId"`
+	Transport string            `bson:",omitempty" json:"transport,omitempty"`
+	Method    string            `json:"method"`
+	URI       string            `json:"uri"`
+	Topic     string            `bson:",omitempty" json:"topic,omitempty"`
+	Key       string            `bson:",omitempty" json:"key,omitempty"`
+	Headers   map[string]string `bson:",omitempty" json:"headers,omitempty"`
+	Payload   primitive.M       `json:"payload"`
+}
+
+// Validate : Check that the transport of the action exists and that the action has a target
+func (action Action) Validate() error {
+	return transport.Validate(action.Transport, action.Topic)
+}
+
+// Host : Get the host targeted by the action, or the transport and topic for the message queues
+func (action Action) Host() string {
+	if transport.IsMessageQueue(action.Transport) {
+		return action.Transport + ":" + action.Topic
+	}
+	target, err := url.Parse(action.URI)
+	if err != nil || target.Host == "" {
+		return "unknown"
+	}
+	return target.Host
 }
 
 // Id : Get id
@@ -51,6 +112,19 @@ func (eventCallback EventCallback) EntityName() string {
 	return "event_callback"
 }
 
+// Indexes : Get the indexes of the collection, the expired callbacks are found by the sweep, which runs their expiry action
+func (eventCallback EventCallback) Indexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "planid", Value: 1}},
+		},
+		{
+			Keys:    bson.D{{Key: "expiresat", Value: 1}},
+			Options: options.Index().SetName("expiresat").SetSparse(true),
+		},
+	}
+}
+
 // FromBson : Transform to BSON
 func (eventCallback EventCallback) FromBson(sr *mongo.SingleResult) persistence.Persistable {
 	err := sr.Decode(&eventCallback)
@@ -60,39 +134,124 @@ func (eventCallback EventCallback) FromBson(sr *mongo.SingleResult) persistence.
 	return eventCallback
 }
 
+// validateActions : Check the parent and undo actions
+func (eventCallback EventCallback) validateActions() error {
+	if err := eventCallback.Parent.Validate(); err != nil {
+		return fmt.Errorf("Invalid parent action: %s", err)
+	}
+	if err := eventCallback.Undo.Validate(); err != nil {
+		return fmt.Errorf("Invalid undo action: %s", err)
+	}
+	return nil
+}
+
+func (action Action) transportName() string {
+	if action.Transport == "" {
+		return transport.HTTP
+	}
+	return action.Transport
+}
+
+// resourceKey : Get the key of the resource modified by the event, the events of a resource are applied in order
+func (eventCallback EventCallback) resourceKey() string {
+	if eventCallback.ResourceKey != "" {
+		return eventCallback.ResourceKey
+	}
+	if transport.IsMessageQueue(eventCallback.Parent.Transport) {
+		return eventCallback.Parent.Host() + "/" + eventCallback.Parent.Key
+	}
+	return eventCallback.Parent.URI
+}
+
+// ActionResponse represents what the target of an action responded
+type ActionResponse struct {
+	StatusCode int     `json:"statusCode"`
+	Status     string  `json:"status"`
+	Body       string  `json:"body,omitempty"`
+	Latency    float64 `json:"latency"`
+}
+
 // Apply : Apply a modification event at their parent
-func (eventCallback EventCallback) Apply() error {
-	uri := eventCallback.Parent.URI
-	method := eventCallback.Parent.Method
-	payload, err := json.Marshal(eventCallback.Parent.Payload)
+func (eventCallback EventCallback) Apply(ctx context.Context) (ActionResponse, error) {
+	return eventCallback.execute(ctx, actionParent, eventCallback.Parent)
+}
+
+// ApplyUndo : Undo a modification event
+func (eventCallback EventCallback) ApplyUndo(ctx context.Context) (ActionResponse, error) {
+	return eventCallback.execute(ctx, actionUndo, eventCallback.Undo)
+}
+
+func (eventCallback EventCallback) execute(ctx context.Context, kind string, action Action) (response ActionResponse, err error) {
+	host := action.Host()
+	metrics.ObserveActionAttempt(kind, host)
+	ctx, span := tracing.Start(ctx, "action."+kind,
+		label.String("esther.plan_id", eventCallback.PlanID),
+		label.String("esther.event_callback_id", eventCallback.ID),
+		label.String("esther.transport", action.transportName()),
+		label.String("http.method", action.Method),
+		label.String("http.url", action.URI),
+		label.String("messaging.destination", action.Topic),
+	)
+	start := time.Now()
+	defer func() {
+		metrics.ObserveActionResult(kind, host, time.Since(start), err)
+		tracing.End(ctx, span, err)
+	}()
+
+	payload, err := json.Marshal(action.Payload)
 	if err != nil {
-		logging.Logger.Error("Error occured during JSON creation payload")
-		return err
+		logging.FromContext(ctx).Error("Error occured during JSON creation payload")
+		return ActionResponse{}, err
 	}
-	req, err := http.NewRequest(method, uri, bytes.NewBuffer(payload))
+	sender, err := transport.Get(action.Transport)
 	if err != nil {
-		logging.Logger.WithFields(logging.LogFields{"httpCode": http.StatusInternalServerError, "id": eventCallback.ID, "planId": eventCallback.PlanId, "payload": err}).Warning("Error while constructing publishing request: " + err.Error())
-		return err
+		logging.FromContext(ctx).WithFields(logging.LogFields{"id": eventCallback.ID, "planId": eventCallback.PlanID, "transport": action.transportName()}).Warning("Error while getting the transport: " + err.Error())
+		return ActionResponse{}, err
+	}
+	message := transport.Message{
+		Method:  action.Method,
+		URI:     action.URI,
+		Topic:   action.Topic,
+		Key:     action.Key,
+		Headers: http.Header{},
+		Payload: payload,
+	}
+	for key, value := range action.Headers {
+		message.Headers.Set(key, value)
+	}
+	message.Headers.Set("Content-Type", "application/json")
+	message.Headers.Set(IdempotencyKeyHeader, eventCallback.idempotencyKey(kind))
+	if requestID := logging.RequestID(ctx); requestID != "" {
+		message.Headers.Set(logging.RequestIDHeader, requestID)
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	// The rate limit is waited for before the action timeout starts
+	call, err := outbound.Wait(ctx, host)
 	if err != nil {
-		logging.Logger.WithFields(logging.LogFields{"httpCode": http.StatusInternalServerError, "id": eventCallback.ID, "planId": eventCallback.PlanId, "payload": err}).Warning("Error while publishing: " + err.Error())
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= 300 {
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			ferr := fmt.Errorf("Error when apply event %s to plan %s\nStatus: %s\nBody : %s", eventCallback.ID, eventCallback.PlanID, resp.Status, err)
-			logging.Logger.Error(ferr)
-			return ferr
-		}
-		ferr := fmt.Errorf("Error when apply event %s to plan %s\nStatus: %s\nBody : %s", eventCallback.ID, eventCallback.PlanID, resp.Status, body)
-		logging.Logger.Error(ferr)
-		return ferr
+		logging.FromContext(ctx).WithFields(logging.LogFields{"id": eventCallback.ID, "planId": eventCallback.PlanID, "host": host}).Warning("The action was not sent: " + err.Error())
+		return ActionResponse{}, err
 	}
-	return nil
+	defer func() {
+		call.Report(response.StatusCode, err)
+	}()
+	ctx, cancel := context.WithTimeout(ctx, actionTimeout)
+	defer cancel()
+
+	result, err := sender.Send(ctx, message)
+	response = ActionResponse{
+		StatusCode: result.StatusCode,
+		Status:     result.Status,
+		Body:       string(result.Body),
+		Latency:    time.Since(start).Seconds(),
+	}
+	if err != nil {
+		logging.FromContext(ctx).WithFields(logging.LogFields{"httpCode": http.StatusInternalServerError, "id": eventCallback.ID, "planId": eventCallback.PlanID, "payload": err}).Warning("Error while publishing: " + err.Error())
+		return response, err
+	}
+	if result.StatusCode >= 300 {
+		ferr := fmt.Errorf("Error when apply event %s to plan %s\nStatus: %s\nBody : %s", eventCallback.ID, eventCallback.PlanID, result.Status, result.Body)
+		logging.FromContext(ctx).Error(ferr)
+		return response, ferr
+	}
+	return response, nil
 }
@@ -1,6 +1,8 @@
 package model
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 
 	"github.com/imdario/mergo"
@@ -8,16 +10,19 @@ import (
 	"gitlab.kardinal.ai/coretech/esther/persistence"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // FindEventCallbacksByPlanId Find all events by planId
-func FindEventCallbacksByPlanId(planID string) ([]EventCallback, error) {
-	collection := persistence.GetCollection(EventCallback{})
-	ctx, _ := persistence.GetContext()
-	cur, err := collection.Find(ctx, bson.D{primitive.E{Key: "planid", Value: planID}})
+func FindEventCallbacksByPlanId(ctx context.Context, scope Scope, planID string) ([]EventCallback, error) {
+	collection := persistence.GetCollection(scope.TenantID, EventCallback{})
+	ctx, cancel := persistence.GetContext(ctx)
+	defer cancel()
+	cur, err := collection.Find(ctx, bson.D{primitive.E{Key: "planid", Value: planID}}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
 	if err != nil {
 		err := fmt.Sprintf("Can't find EventCallback with plan-id: %s", planID)
-		logging.Logger.Error(err)
+		logging.FromContext(ctx).Error(err)
 		return []EventCallback{}, fmt.Errorf(err)
 	}
 	eventCallbacks := make([]EventCallback, 0)
@@ -26,7 +31,7 @@ func FindEventCallbacksByPlanId(planID string) ([]EventCallback, error) {
 		var eventCallback EventCallback
 		err := cur.Decode(&eventCallback)
 		if err != nil {
-			logging.Logger.Error(err)
+			logging.FromContext(ctx).Error(err)
 		}
 		eventCallbacks = append(eventCallbacks, eventCallback)
 	}
@@ -35,8 +40,8 @@ func FindEventCallbacksByPlanId(planID string) ([]EventCallback, error) {
 }
 
 // FindEventCallbackById Find one event by his ID
-func FindEventCallbackById(planID string, eventID string) (EventCallback, error) {
-	eventCallbackInterface := persistence.FindOne(EventCallback{ID: eventID, PlanID: planID})
+func FindEventCallbackById(ctx context.Context, scope Scope, planID string, eventID string) (EventCallback, error) {
+	eventCallbackInterface := persistence.FindOne(ctx, scope.TenantID, EventCallback{ID: eventID, PlanID: planID})
 	if eventCallbackInterface == nil {
 		return EventCallback{}, fmt.Errorf("Can't retrieve the event-callback %s in plan %s", eventID, planID)
 	}
@@ -45,51 +50,282 @@ func FindEventCallbackById(planID string, eventID string) (EventCallback, error)
 }
 
 // CreateEventCallback Create one event
-func CreateEventCallback(planID string, eventCallback EventCallback) (EventCallback, error) {
+func CreateEventCallback(ctx context.Context, scope Scope, planID string, eventCallback EventCallback) (EventCallback, error) {
 	eventCallback.PlanID = planID
-	id := persistence.InsertOne(eventCallback)
-	if id == "" {
-		return EventCallback{}, fmt.Errorf("Can't create the event-callback in plan %s", eventCallback.PlanId())
+	eventCallback.State = StatePending
+	eventCallback.ApplyRunID = ""
+	if err := CheckPlanUnlocked(ctx, scope, planID); err != nil {
+		return EventCallback{}, err
+	}
+	if err := checkTenantQuota(ctx, scope); err != nil {
+		return EventCallback{}, err
+	}
+	if err := checkPlanQuota(ctx, scope, eventCallback, true); err != nil {
+		return EventCallback{}, err
+	}
+	if err := eventCallback.validateActions(); err != nil {
+		return EventCallback{}, err
+	}
+	if err := checkDependencies(ctx, scope, eventCallback, nil); err != nil {
+		return EventCallback{}, err
+	}
+	if err := applyExpiryDefaults(ctx, scope, &eventCallback); err != nil {
+		return EventCallback{}, err
+	}
+	err := persistence.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := guardPlanUnlocked(ctx, scope, planID); err != nil {
+			return err
+		}
+		id := persistence.InsertOne(ctx, scope.TenantID, eventCallback)
+		if id == "" {
+			return fmt.Errorf("Can't create the event-callback in plan %s", eventCallback.PlanId())
+		}
+		eventCallback.ID = id
+		if err := recordHistory(ctx, scope, OperationCreate, planID, eventCallback.ID, nil, &eventCallback, nil); err != nil {
+			return err
+		}
+		return recordOutboxEvent(ctx, scope, EventCallbackCreated, eventCallback)
+	})
+	if err != nil {
+		return EventCallback{}, err
 	}
-	eventCallback.ID = id
 	return eventCallback, nil
 }
 
 // UpdateEventCallback Create one event
-func UpdateEventCallback(planID string, eventID string, eventCallback EventCallback) (EventCallback, error) {
-	ec, err := FindEventCallbackById(planID, eventID)
+func UpdateEventCallback(ctx context.Context, scope Scope, planID string, eventID string, eventCallback EventCallback) (EventCallback, error) {
+	if err := CheckPlanUnlocked(ctx, scope, planID); err != nil {
+		return EventCallback{}, err
+	}
+	ec, err := FindEventCallbackById(ctx, scope, planID, eventID)
 	if err != nil {
 		return EventCallback{}, err
 	}
+	before := ec
 	if err := mergo.Merge(&ec, eventCallback, mergo.WithOverride); err != nil {
 		return EventCallback{}, err
 	}
-	if persistence.ReplaceOne(ec) == false {
-		return EventCallback{}, fmt.Errorf("Can't update the event-callback %s in plan %s", eventCallback.Id(), eventCallback.PlanId())
+	ec.State = before.State
+	ec.ApplyRunID = before.ApplyRunID
+	if err := ec.validateActions(); err != nil {
+		return EventCallback{}, err
+	}
+	if err := checkDependencies(ctx, scope, ec, before.DependsOn); err != nil {
+		return EventCallback{}, err
+	}
+	if err := checkPlanQuota(ctx, scope, ec, false); err != nil {
+		return EventCallback{}, err
+	}
+	if err := applyExpiryDefaults(ctx, scope, &ec); err != nil {
+		return EventCallback{}, err
+	}
+	err = persistence.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := guardPlanUnlocked(ctx, scope, planID); err != nil {
+			return err
+		}
+		if persistence.ReplaceOne(ctx, scope.TenantID, ec) == false {
+			return fmt.Errorf("Can't update the event-callback %s in plan %s", eventCallback.Id(), eventCallback.PlanId())
+		}
+		if err := recordHistory(ctx, scope, OperationUpdate, planID, eventID, &before, &ec, nil); err != nil {
+			return err
+		}
+		return recordOutboxEvent(ctx, scope, EventCallbackUpdated, ec)
+	})
+	if err != nil {
+		return EventCallback{}, err
 	}
 	return ec, nil
 }
 
 // DeleteEventCallbacksById Delete one event
-func DeleteEventCallbacksById(planID string, eventID string) error {
-	if persistence.DeleteOne(EventCallback{ID: eventID, PlanID: planID}) == false {
-		return fmt.Errorf("Can't delete the event-callback %s in plan %s", eventID, planID)
+func DeleteEventCallbacksById(ctx context.Context, scope Scope, planID string, eventID string) error {
+	if err := CheckPlanUnlocked(ctx, scope, planID); err != nil {
+		return err
 	}
-	return nil
+	before, err := FindEventCallbackById(ctx, scope, planID, eventID)
+	if err != nil {
+		return err
+	}
+	return persistence.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := guardPlanUnlocked(ctx, scope, planID); err != nil {
+			return err
+		}
+		if persistence.DeleteOne(ctx, scope.TenantID, EventCallback{ID: eventID, PlanID: planID}) == false {
+			return fmt.Errorf("Can't delete the event-callback %s in plan %s", eventID, planID)
+		}
+		if err := recordHistory(ctx, scope, OperationDelete, planID, eventID, &before, nil, nil); err != nil {
+			return err
+		}
+		return recordOutboxEvent(ctx, scope, EventCallbackDeleted, before)
+	})
 }
 
 // DeleteEventCallbacksByPlanId Delete many events by their planId
-func DeleteEventCallbacksByPlanId(planID string) error {
-	collection := persistence.GetCollection(EventCallback{})
-	ctx, _ := persistence.GetContext()
-	dr, err := collection.DeleteMany(ctx, bson.D{
-		primitive.E{Key: "planid", Value: planID},
+func DeleteEventCallbacksByPlanId(ctx context.Context, scope Scope, planID string) error {
+	if err := CheckPlanUnlocked(ctx, scope, planID); err != nil {
+		return err
+	}
+	befores, err := FindEventCallbacksByPlanId(ctx, scope, planID)
+	if err != nil {
+		return err
+	}
+	// Only the events found are deleted, so that every deleted event has its outbox event
+	ids := make(bson.A, 0, len(befores))
+	for _, before := range befores {
+		if id, err := primitive.ObjectIDFromHex(before.ID); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	collection := persistence.GetCollection(scope.TenantID, EventCallback{})
+	return persistence.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := guardPlanUnlocked(ctx, scope, planID); err != nil {
+			return err
+		}
+		deleteCtx, cancel := persistence.GetContext(ctx)
+		defer cancel()
+		_, err := collection.DeleteMany(deleteCtx, bson.D{
+			primitive.E{Key: "planid", Value: planID},
+			primitive.E{Key: "_id", Value: bson.M{"$in": ids}},
+		})
+		if err != nil {
+			err := fmt.Sprintf("Can't delete EventCallback with plan-id: %s", planID)
+			logging.FromContext(ctx).Error(err)
+			return fmt.Errorf(err)
+		}
+		for i := range befores {
+			if err := recordHistory(ctx, scope, OperationDelete, planID, befores[i].ID, &befores[i], nil, nil); err != nil {
+				return err
+			}
+			if err := recordOutboxEvent(ctx, scope, EventCallbackDeleted, befores[i]); err != nil {
+				return err
+			}
+		}
+		return nil
 	})
-	if err != nil && dr.DeletedCount >= 1 {
-		err := fmt.Sprintf("Can't delete EventCallback with plan-id: %s", planID)
-		logging.Logger.Error(err)
-		return fmt.Errorf(err)
+}
+
+// ApplyEventCallback Apply one event to its parent, record the result and archive it once applied
+func ApplyEventCallback(ctx context.Context, scope Scope, eventCallback EventCallback) error {
+	response, err := eventCallback.Apply(ctx)
+	// Once the parent was called, what happened is recorded even if the caller has gone
+	ctx = persistence.Detach(ctx)
+	if err != nil {
+		// The failure changes nothing else, it is recorded on its own
+		result := ApplyResult{Success: false, Error: err.Error(), Response: &response}
+		if err := recordHistory(ctx, scope, OperationApply, eventCallback.PlanID, eventCallback.ID, &eventCallback, nil, &result); err != nil {
+			logging.FromContext(ctx).WithField("error", err).Error("The history entry could not be recorded")
+		}
+		return err
 	}
+	if err := archiveEventCallback(ctx, scope, eventCallback, response); err != nil {
+		return fmt.Errorf("%w: %s", ErrNotArchived, err)
+	}
+	return nil
+}
 
+// CountPendingEventCallbacksByPlan Count the pending events of every plan of every tenant
+func CountPendingEventCallbacksByPlan(ctx context.Context) ([]int64, error) {
+	tenants, err := persistence.ListTenants(ctx)
+	if err != nil {
+		return nil, err
+	}
+	counts := []int64{}
+	for _, tenantID := range tenants {
+		collection := persistence.GetCollection(tenantID, EventCallback{})
+		ctx, cancel := persistence.GetContext(ctx)
+		cur, err := collection.Aggregate(ctx, mongo.Pipeline{
+			{{Key: "$group", Value: bson.M{"_id": "$planid", "count": bson.M{"$sum": 1}}}},
+		})
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("Can't count the event-callbacks of tenant %s: %s", tenantID, err)
+		}
+		for cur.Next(ctx) {
+			var group struct {
+				Count int64 `bson:"count"`
+			}
+			if err := cur.Decode(&group); err != nil {
+				logging.FromContext(ctx).Error(err)
+				continue
+			}
+			counts = append(counts, group.Count)
+		}
+		cur.Close(ctx)
+		cancel()
+	}
+	return counts, nil
+}
+
+// checkDependencies checks that an event depends on pending events of its plan.
+// The known dependencies are not checked, they may have been applied since they were set.
+func checkDependencies(ctx context.Context, scope Scope, eventCallback EventCallback, known []string) error {
+	checked := map[string]bool{}
+	for _, dependency := range known {
+		checked[dependency] = true
+	}
+	for _, dependency := range eventCallback.DependsOn {
+		if checked[dependency] {
+			continue
+		}
+		checked[dependency] = true
+		if dependency == eventCallback.ID {
+			return fmt.Errorf("The event-callback %s cannot depend on itself", dependency)
+		}
+		if _, err := FindEventCallbackById(ctx, scope, eventCallback.PlanID, dependency); err != nil {
+			return fmt.Errorf("The event-callback depends on %s, which is not pending in plan %s", dependency, eventCallback.PlanID)
+		}
+	}
+	return nil
+}
+
+// checkPlanQuota checks the size of an event against the quota of its plan, and the number of events of the plan when it is a new one
+func checkPlanQuota(ctx context.Context, scope Scope, eventCallback EventCallback, created bool) error {
+	plan, err := FindPlan(ctx, scope, eventCallback.PlanID)
+	if err != nil {
+		return err
+	}
+	if max := plan.PayloadQuota(); max > 0 {
+		payload, err := json.Marshal(eventCallback)
+		if err != nil {
+			return err
+		}
+		if int64(len(payload)) > max {
+			return fmt.Errorf("%w: the event-callback is %d bytes long, plan %s accepts at most %d bytes", ErrPayloadTooLarge, len(payload), plan.PlanID, max)
+		}
+	}
+	max := plan.CallbackQuota()
+	if !created || max == 0 {
+		return nil
+	}
+	collection := persistence.GetCollection(scope.TenantID, EventCallback{})
+	ctx, cancel := persistence.GetContext(ctx)
+	defer cancel()
+	count, err := collection.CountDocuments(ctx, bson.M{"planid": plan.PlanID})
+	if err != nil {
+		return fmt.Errorf("Can't count the event-callbacks of plan %s: %s", plan.PlanID, err)
+	}
+	if count >= max {
+		logging.FromContext(ctx).WithFields(logging.LogFields{"planId": plan.PlanID, "max": max}).Warn("Plan quota exceeded")
+		return fmt.Errorf("%w: plan %s cannot store more than %d event-callbacks", ErrQuotaExceeded, plan.PlanID, max)
+	}
+	return nil
+}
+
+func checkTenantQuota(ctx context.Context, scope Scope) error {
+	max := TenantMaxCallbacks(scope.TenantID)
+	if max == 0 {
+		return nil
+	}
+	collection := persistence.GetCollection(scope.TenantID, EventCallback{})
+	ctx, cancel := persistence.GetContext(ctx)
+	defer cancel()
+	count, err := collection.CountDocuments(ctx, bson.D{})
+	if err != nil {
+		return fmt.Errorf("Can't count the event-callbacks of tenant %s: %s", scope.TenantID, err)
+	}
+	if count >= max {
+		logging.FromContext(ctx).WithFields(logging.LogFields{"tenant": scope.TenantID, "max": max}).Warn("Tenant quota exceeded")
+		return fmt.Errorf("%w: tenant %s cannot store more than %d event-callbacks", ErrQuotaExceeded, scope.TenantID, max)
+	}
 	return nil
 }
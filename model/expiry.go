@@ -0,0 +1,90 @@
+package model
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gitlab.kardinal.ai/coretech/esther/logging"
+	"gitlab.kardinal.ai/coretech/esther/persistence"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultExpirySweepInterval = time.Minute
+	expiryActor                = "esther:expiry"
+)
+
+var (
+	defaultExpiryAction string
+	expirySweepInterval time.Duration
+)
+
+// DeadLetterEventCallback represents an event callback which has expired and needs a manual action
+type DeadLetterEventCallback struct {
+	ID             string        `bson:"_id,omitempty" json:"id"`
+	PlanID         string        `json:"planId"`
+	EventCallback  EventCallback `json:"eventCallback"`
+	Reason         string        `json:"reason"`
+	DeadLetteredAt time.Time     `json:"deadLetteredAt"`
+}
+
+func init() {
+	persistence.Register(DeadLetterEventCallback{})
+	initExpiryEnv()
+}
+
+func initExpiryEnv() []string {
+	errs := []string{}
+	envDefaultAction := "EXPIRY_DEFAULT_ACTION"
+	defaultExpiryAction = os.Getenv(envDefaultAction)
+	if defaultExpiryAction == "" {
+		defaultExpiryAction = ExpiryActionDelete
+	} else if err := validateExpiryAction(defaultExpiryAction); err != nil {
+		errs = append(errs, fmt.Sprintf("%s: %s", envDefaultAction, err))
+		defaultExpiryAction = ExpiryActionDelete
+	}
+	expirySweepInterval, errs = durationFromEnv("EXPIRY_SWEEP_INTERVAL", defaultExpirySweepInterval, errs)
+	if len(errs) > 0 {
+		logging.Logger.WithField("errors", errs).Error("The expiry environment is not properly set")
+	}
+	return errs
+}
+
+func validateExpiryAction(action string) error {
+	switch action {
+	case "", ExpiryActionDelete, ExpiryActionUndo, ExpiryActionDeadLetter:
+		return nil
+	}
+	return fmt.Errorf("The expiry action must be one of %s, %s or %s: %s", ExpiryActionDelete, ExpiryActionUndo, ExpiryActionDeadLetter, action)
+}
+
+// Id : Get id
+func (deadLetter DeadLetterEventCallback) Id() string {
+	return deadLetter.ID
+}
+
+// ResetId : Reset id (Has to create a new Instance)
+func (deadLetter DeadLetterEventCallback) ResetId(ID string) persistence.Persistable {
+	deadLetter.ID = ID
+	return deadLetter
+}
+
+// PlanId : Get Plan Id
+func (deadLetter DeadLetterEventCallback) PlanId() string {
+	return deadLetter.PlanID
+}
+
+// EntityName : Get entity name
+func (deadLetter DeadLetterEventCallback) EntityName() string {
+	return "event_callback_deadletter"
+}
+
+// FromBson : Transform to BSON
+func (deadLetter DeadLetterEventCallback) FromBson(sr *mongo.SingleResult) persistence.Persistable {
+	err := sr.Decode(&deadLetter)
+	if err != nil {
+		logging.Logger.Error(fmt.Sprintf("Cannot unmarshal dead letter event callback : %s", err.Error()))
+	}
+	return deadLetter
+}
@@ -0,0 +1,253 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gitlab.kardinal.ai/coretech/esther/logging"
+	"gitlab.kardinal.ai/coretech/esther/persistence"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// FindDeadLetterEventCallbacksByPlanId Find all the expired events of a plan waiting for a manual action
+func FindDeadLetterEventCallbacksByPlanId(ctx context.Context, scope Scope, planID string) ([]DeadLetterEventCallback, error) {
+	collection := persistence.GetCollection(scope.TenantID, DeadLetterEventCallback{})
+	ctx, cancel := persistence.GetContext(ctx)
+	defer cancel()
+	cur, err := collection.Find(ctx, bson.M{"planid": planID}, options.Find().SetSort(bson.D{{Key: "deadletteredat", Value: -1}}))
+	if err != nil {
+		err := fmt.Sprintf("Can't find dead letter EventCallback with plan-id: %s", planID)
+		logging.FromContext(ctx).Error(err)
+		return []DeadLetterEventCallback{}, fmt.Errorf(err)
+	}
+	deadLetters := make([]DeadLetterEventCallback, 0)
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var deadLetter DeadLetterEventCallback
+		if err := cur.Decode(&deadLetter); err != nil {
+			logging.FromContext(ctx).Error(err)
+		}
+		deadLetters = append(deadLetters, deadLetter)
+	}
+	return deadLetters, nil
+}
+
+// SweepExpiredEventCallbacks Run the expiry action of the expired events of every tenant
+func SweepExpiredEventCallbacks(ctx context.Context) error {
+	tenants, err := persistence.ListTenants(ctx)
+	if err != nil {
+		return err
+	}
+	for _, tenantID := range tenants {
+		scope := Scope{TenantID: tenantID, Actor: expiryActor}
+		expired, err := findExpiredEventCallbacks(ctx, scope, time.Now())
+		if err != nil {
+			return err
+		}
+		for _, eventCallback := range expired {
+			operation := LockOperationExpire
+			if eventCallback.OnExpire == ExpiryActionUndo {
+				operation = LockOperationUndo
+			}
+			err := withPlanLock(ctx, scope, eventCallback.PlanID, operation, func(ctx context.Context) error {
+				// The plan may have been applied since the expired events were found, the event is read again under the lock
+				current, found, err := findExpiredEventCallback(ctx, scope, eventCallback.PlanID, eventCallback.ID, time.Now())
+				if err != nil || !found {
+					return err
+				}
+				return expireEventCallback(ctx, scope, current)
+			})
+			if errors.Is(err, ErrPlanLocked) {
+				// The plan is being applied, its expired events are handled by the next sweep
+				logging.FromContext(ctx).WithFields(logging.LogFields{
+					"tenant": tenantID,
+					"planId": eventCallback.PlanID,
+					"id":     eventCallback.ID,
+				}).Info("The plan of the expired event callback is locked, skipping it")
+				continue
+			}
+			if err != nil {
+				logging.FromContext(ctx).WithFields(logging.LogFields{
+					"tenant": tenantID,
+					"planId": eventCallback.PlanID,
+					"id":     eventCallback.ID,
+					"error":  err.Error(),
+				}).Error("The expired event callback could not be handled")
+			}
+		}
+	}
+	return nil
+}
+
+// StartExpirySweep Sweep the expired events periodically in the background, until ctx is done
+func StartExpirySweep(ctx context.Context) {
+	logging.Logger.WithFields(logging.LogFields{
+		"defaultAction": defaultExpiryAction,
+		"interval":      expirySweepInterval.String(),
+	}).Info("Starting the sweep of expired event callbacks")
+	go func() {
+		ticker := time.NewTicker(expirySweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := SweepExpiredEventCallbacks(ctx); err != nil {
+					logging.Logger.WithField("error", err).Error("The sweep of expired event callbacks has failed")
+				}
+			}
+		}
+	}()
+}
+
+// applyExpiryDefaults sets the expiry of an event from the settings of its plan when it has none
+func applyExpiryDefaults(ctx context.Context, scope Scope, eventCallback *EventCallback) error {
+	if err := validateExpiryAction(eventCallback.OnExpire); err != nil {
+		return err
+	}
+	if eventCallback.ExpiresAt != nil && eventCallback.OnExpire != "" {
+		return nil
+	}
+	plan, err := FindPlan(ctx, scope, eventCallback.PlanID)
+	if err != nil {
+		return err
+	}
+	if eventCallback.ExpiresAt == nil {
+		ttl := plan.TTL()
+		if ttl == 0 {
+			return nil
+		}
+		expiresAt := time.Now().UTC().Add(ttl)
+		eventCallback.ExpiresAt = &expiresAt
+	}
+	if eventCallback.OnExpire == "" {
+		eventCallback.OnExpire = plan.OnExpire
+	}
+	if eventCallback.OnExpire == "" {
+		eventCallback.OnExpire = defaultExpiryAction
+	}
+	return nil
+}
+
+func findExpiredEventCallbacks(ctx context.Context, scope Scope, now time.Time) ([]EventCallback, error) {
+	collection := persistence.GetCollection(scope.TenantID, EventCallback{})
+	ctx, cancel := persistence.GetContext(ctx)
+	defer cancel()
+	cur, err := collection.Find(ctx, bson.M{"expiresat": bson.M{"$lt": now}})
+	if err != nil {
+		return nil, fmt.Errorf("Can't find the expired event-callbacks of tenant %s: %s", scope.TenantID, err)
+	}
+	expired := make([]EventCallback, 0)
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var eventCallback EventCallback
+		if err := cur.Decode(&eventCallback); err != nil {
+			logging.FromContext(ctx).Error(err)
+			continue
+		}
+		expired = append(expired, eventCallback)
+	}
+	return expired, nil
+}
+
+// findExpiredEventCallback reads an expired event again, found is false when it was removed, is no longer expired or is no longer pending
+// (it is being applied, or was applied and archived)
+func findExpiredEventCallback(ctx context.Context, scope Scope, planID string, eventID string, now time.Time) (EventCallback, bool, error) {
+	id, err := primitive.ObjectIDFromHex(eventID)
+	if err != nil {
+		return EventCallback{}, false, fmt.Errorf("Invalid event-callback id %s: %s", eventID, err)
+	}
+	collection := persistence.GetCollection(scope.TenantID, EventCallback{})
+	ctx, cancel := persistence.GetContext(ctx)
+	defer cancel()
+	var eventCallback EventCallback
+	err = collection.FindOne(ctx, bson.M{"_id": id, "planid": planID, "expiresat": bson.M{"$lte": now}}).Decode(&eventCallback)
+	if err == mongo.ErrNoDocuments {
+		return EventCallback{}, false, nil
+	}
+	if err != nil {
+		return EventCallback{}, false, fmt.Errorf("Can't read the expired event-callback %s in plan %s: %s", eventID, planID, err)
+	}
+	if eventCallback.State != StatePending {
+		logging.FromContext(ctx).WithFields(logging.LogFields{
+			"tenant": scope.TenantID,
+			"planId": planID,
+			"id":     eventID,
+			"state":  eventCallback.State,
+		}).Info("The expired event callback is no longer pending, skipping it")
+		return EventCallback{}, false, nil
+	}
+	return eventCallback, true, nil
+}
+
+// expireEventCallback runs the expiry action of an event, which is moved to the dead letters when its undo fails
+func expireEventCallback(ctx context.Context, scope Scope, eventCallback EventCallback) error {
+	var result *ApplyResult
+	eventType := EventCallbackExpired
+	switch eventCallback.OnExpire {
+	case ExpiryActionUndo:
+		response, err := eventCallback.ApplyUndo(ctx)
+		ctx = persistence.Detach(ctx)
+		result = &ApplyResult{Success: err == nil, Response: &response}
+		if err != nil {
+			result.Error = err.Error()
+			return persistence.WithTransaction(ctx, func(ctx context.Context) error {
+				if err := recordHistory(ctx, scope, OperationExpire, eventCallback.PlanID, eventCallback.ID, &eventCallback, nil, result); err != nil {
+					return err
+				}
+				if err := deadLetterEventCallback(ctx, scope, eventCallback, fmt.Sprintf("The undo action has failed: %s", result.Error)); err != nil {
+					return err
+				}
+				return notifySubscriptions(ctx, scope, Notification{Type: NotificationUndoFailed, PlanID: eventCallback.PlanID, EventCallback: &eventCallback, Error: result.Error})
+			})
+		}
+		eventType = EventCallbackUndone
+	case ExpiryActionDeadLetter:
+		return persistence.WithTransaction(ctx, func(ctx context.Context) error {
+			if err := recordHistory(ctx, scope, OperationExpire, eventCallback.PlanID, eventCallback.ID, &eventCallback, nil, nil); err != nil {
+				return err
+			}
+			return deadLetterEventCallback(ctx, scope, eventCallback, "The event callback has expired")
+		})
+	}
+	return persistence.WithTransaction(ctx, func(ctx context.Context) error {
+		if persistence.DeleteOne(ctx, scope.TenantID, eventCallback) == false {
+			return fmt.Errorf("Can't delete the expired event-callback %s in plan %s", eventCallback.ID, eventCallback.PlanID)
+		}
+		if err := recordHistory(ctx, scope, OperationExpire, eventCallback.PlanID, eventCallback.ID, &eventCallback, nil, result); err != nil {
+			return err
+		}
+		if err := recordOutboxEvent(ctx, scope, eventType, eventCallback); err != nil {
+			return err
+		}
+		if eventType == EventCallbackUndone {
+			return notifySubscriptions(ctx, scope, Notification{Type: NotificationUndoCompleted, PlanID: eventCallback.PlanID, EventCallback: &eventCallback})
+		}
+		return nil
+	})
+}
+
+// deadLetterEventCallback moves an expired event from the pending ones to the dead letters
+func deadLetterEventCallback(ctx context.Context, scope Scope, eventCallback EventCallback, reason string) error {
+	deadLetter := DeadLetterEventCallback{
+		PlanID:         eventCallback.PlanID,
+		EventCallback:  eventCallback,
+		Reason:         reason,
+		DeadLetteredAt: time.Now().UTC(),
+	}
+	return persistence.WithTransaction(ctx, func(ctx context.Context) error {
+		if persistence.InsertOne(ctx, scope.TenantID, deadLetter) == "" {
+			return fmt.Errorf("Can't move the event-callback %s in plan %s to the dead letters", eventCallback.ID, eventCallback.PlanID)
+		}
+		if persistence.DeleteOne(ctx, scope.TenantID, eventCallback) == false {
+			return fmt.Errorf("Can't remove the dead letter event-callback %s from plan %s", eventCallback.ID, eventCallback.PlanID)
+		}
+		return recordOutboxEvent(ctx, scope, EventCallbackDeadLettered, eventCallback)
+	})
+}
@@ -0,0 +1,85 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"gitlab.kardinal.ai/coretech/esther/logging"
+	"gitlab.kardinal.ai/coretech/esther/persistence"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Operations recorded in the history of event callbacks
+const (
+	OperationCreate = "create"
+	OperationUpdate = "update"
+	OperationDelete = "delete"
+	OperationApply  = "apply"
+	OperationExpire = "expire"
+)
+
+// HistoryEntry represents one change done to an event callback
+type HistoryEntry struct {
+	ID              string         `bson:"_id,omitempty" json:"id"`
+	PlanID          string         `json:"planId"`
+	EventCallbackID string         `json:"eventCallbackId"`
+	Operation       string         `json:"operation"`
+	Actor           string         `json:"actor"`
+	OnBehalfOf      string         `json:"onBehalfOf,omitempty"`
+	ClientIP        string         `json:"clientIp"`
+	Timestamp       time.Time      `json:"timestamp"`
+	Before          *EventCallback `json:"before,omitempty"`
+	After           *EventCallback `json:"after,omitempty"`
+	ApplyResult     *ApplyResult   `json:"applyResult,omitempty"`
+}
+
+// ApplyResult represents the outcome of the application of an event callback
+type ApplyResult struct {
+	Success  bool            `json:"success"`
+	Error    string          `json:"error,omitempty"`
+	Response *ActionResponse `json:"response,omitempty"`
+}
+
+// Id : Get id
+func (entry HistoryEntry) Id() string {
+	return entry.ID
+}
+
+// ResetId : Reset id (Has to create a new Instance)
+func (entry HistoryEntry) ResetId(ID string) persistence.Persistable {
+	entry.ID = ID
+	return entry
+}
+
+// PlanId : Get Plan Id
+func (entry HistoryEntry) PlanId() string {
+	return entry.PlanID
+}
+
+// EntityName : Get entity name
+func (entry HistoryEntry) EntityName() string {
+	return "event_callback_history"
+}
+
+func init() {
+	persistence.Register(HistoryEntry{})
+}
+
+// Indexes : Get the indexes of the collection, the history of a plan is read in the order of the changes
+func (entry HistoryEntry) Indexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "planid", Value: 1}, {Key: "timestamp", Value: 1}},
+		},
+	}
+}
+
+// FromBson : Transform to BSON
+func (entry HistoryEntry) FromBson(sr *mongo.SingleResult) persistence.Persistable {
+	err := sr.Decode(&entry)
+	if err != nil {
+		logging.Logger.Error(fmt.Sprintf("Cannot unmarshal history entry : %s", err.Error()))
+	}
+	return entry
+}
@@ -0,0 +1,67 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gitlab.kardinal.ai/coretech/esther/logging"
+	"gitlab.kardinal.ai/coretech/esther/persistence"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// FindHistoryByPlanId Find the history of a plan, optionally restricted to a time range (zero times are ignored)
+func FindHistoryByPlanId(ctx context.Context, scope Scope, planID string, from time.Time, to time.Time) ([]HistoryEntry, error) {
+	filter := bson.M{"planid": planID}
+	timestamp := bson.M{}
+	if !from.IsZero() {
+		timestamp["$gte"] = from
+	}
+	if !to.IsZero() {
+		timestamp["$lte"] = to
+	}
+	if len(timestamp) > 0 {
+		filter["timestamp"] = timestamp
+	}
+
+	collection := persistence.GetCollection(scope.TenantID, HistoryEntry{})
+	ctx, cancel := persistence.GetContext(ctx)
+	defer cancel()
+	cur, err := collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}}))
+	if err != nil {
+		err := fmt.Sprintf("Can't find history with plan-id: %s", planID)
+		logging.FromContext(ctx).Error(err)
+		return []HistoryEntry{}, fmt.Errorf(err)
+	}
+	entries := make([]HistoryEntry, 0)
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var entry HistoryEntry
+		if err := cur.Decode(&entry); err != nil {
+			logging.FromContext(ctx).Error(err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// recordHistory appends an entry to the history, in the transaction of the change when ctx holds one
+func recordHistory(ctx context.Context, scope Scope, operation string, planID string, eventID string, before *EventCallback, after *EventCallback, result *ApplyResult) error {
+	entry := HistoryEntry{
+		PlanID:          planID,
+		EventCallbackID: eventID,
+		Operation:       operation,
+		Actor:           scope.Actor,
+		OnBehalfOf:      scope.OnBehalfOf,
+		ClientIP:        scope.ClientIP,
+		Timestamp:       time.Now().UTC(),
+		Before:          before,
+		After:           after,
+		ApplyResult:     result,
+	}
+	if persistence.InsertOne(ctx, scope.TenantID, entry) == "" {
+		return fmt.Errorf("Can't record the %s of the event-callback %s in plan %s in the history", operation, eventID, planID)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"gitlab.kardinal.ai/coretech/esther/logging"
+	"gitlab.kardinal.ai/coretech/esther/persistence"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// IdempotencyKeyHeader is the header holding the idempotency key of inbound requests and outbound actions
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	// Statuses of an idempotency record
+	IdempotencyProcessing = "processing"
+	IdempotencyCompleted  = "completed"
+
+	defaultIdempotencyTTL = 24 * time.Hour
+)
+
+var idempotencyTTL time.Duration
+
+// IdempotencyRecord represents a request made with an idempotency key, and the response to replay when it is retried
+type IdempotencyRecord struct {
+	ID             string    `bson:"_id,omitempty" json:"id"`
+	PlanID         string    `json:"planId"`
+	Key            string    `json:"key"`
+	Route          string    `json:"route"`
+	RequestHash    string    `json:"requestHash"`
+	Status         string    `json:"status"`
+	ResponseStatus int       `json:"responseStatus,omitempty"`
+	ContentType    string    `json:"contentType,omitempty"`
+	ResponseBody   []byte    `json:"responseBody,omitempty"`
+	CreatedAt      time.Time `json:"createdAt"`
+	ExpiresAt      time.Time `json:"expiresAt"`
+}
+
+func init() {
+	persistence.Register(IdempotencyRecord{})
+	var errs []string
+	if idempotencyTTL, errs = durationFromEnv("IDEMPOTENCY_TTL", defaultIdempotencyTTL, nil); len(errs) > 0 {
+		logging.Logger.WithField("errors", errs).Error("The idempotency environment is not properly set")
+	}
+}
+
+// Id : Get id
+func (record IdempotencyRecord) Id() string {
+	return record.ID
+}
+
+// ResetId : Reset id (Has to create a new Instance)
+func (record IdempotencyRecord) ResetId(ID string) persistence.Persistable {
+	record.ID = ID
+	return record
+}
+
+// PlanId : Get Plan Id
+func (record IdempotencyRecord) PlanId() string {
+	return record.PlanID
+}
+
+// EntityName : Get entity name
+func (record IdempotencyRecord) EntityName() string {
+	return "idempotency_key"
+}
+
+// Indexes : Get the indexes of the collection, a key is unique per plan and route and is removed by MongoDB once expired
+func (record IdempotencyRecord) Indexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "planid", Value: 1}, {Key: "route", Value: 1}, {Key: "key", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expiresat", Value: 1}},
+			Options: options.Index().SetName("expiresat_ttl").SetExpireAfterSeconds(0),
+		},
+	}
+}
+
+// FromBson : Transform to BSON
+func (record IdempotencyRecord) FromBson(sr *mongo.SingleResult) persistence.Persistable {
+	err := sr.Decode(&record)
+	if err != nil {
+		logging.Logger.Error(fmt.Sprintf("Cannot unmarshal idempotency record : %s", err.Error()))
+	}
+	return record
+}
+
+// idempotencyKey is the key sent to the target of an action, the same for every attempt of this action of this event
+func (eventCallback EventCallback) idempotencyKey(kind string) string {
+	return eventCallback.ID + "-" + kind
+}
@@ -0,0 +1,61 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gitlab.kardinal.ai/coretech/esther/persistence"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ReserveIdempotencyKey Record that a request with an idempotency key is being processed.
+// When the key was already used, the existing record is returned and reserved is false.
+func ReserveIdempotencyKey(ctx context.Context, scope Scope, record IdempotencyRecord) (existing IdempotencyRecord, reserved bool, err error) {
+	now := time.Now().UTC()
+	record.Status = IdempotencyProcessing
+	record.CreatedAt = now
+	// A request still processing when its instance crashed does not lock its key until the TTL
+	record.ExpiresAt = now.Add(applyRunStaleAfter)
+
+	collection := persistence.GetCollection(scope.TenantID, IdempotencyRecord{})
+	ctx, cancel := persistence.GetContext(ctx)
+	defer cancel()
+	filter := bson.M{"planid": record.PlanID, "route": record.Route, "key": record.Key}
+	// MongoDB removes the expired records lazily, they are removed here before reserving their key again
+	if _, err := collection.DeleteOne(ctx, bson.M{"planid": record.PlanID, "route": record.Route, "key": record.Key, "expiresat": bson.M{"$lt": now}}); err != nil {
+		return IdempotencyRecord{}, false, fmt.Errorf("Can't remove the expired idempotency key %s: %s", record.Key, err)
+	}
+	res, err := collection.InsertOne(ctx, persistence.ToBson(record))
+	if err == nil {
+		record.ID = res.InsertedID.(primitive.ObjectID).Hex()
+		return record, true, nil
+	}
+	if !persistence.IsDuplicateKey(err) {
+		return IdempotencyRecord{}, false, fmt.Errorf("Can't reserve the idempotency key %s: %s", record.Key, err)
+	}
+	if err := collection.FindOne(ctx, filter).Decode(&existing); err != nil {
+		return IdempotencyRecord{}, false, fmt.Errorf("Can't retrieve the idempotency key %s: %s", record.Key, err)
+	}
+	return existing, false, nil
+}
+
+// CompleteIdempotencyKey Store the response to replay to the requests retried with the same idempotency key
+func CompleteIdempotencyKey(ctx context.Context, scope Scope, record IdempotencyRecord) error {
+	record.Status = IdempotencyCompleted
+	record.ExpiresAt = time.Now().UTC().Add(idempotencyTTL)
+	// The response is stored even if the client has gone, it is the one which will retry
+	if persistence.ReplaceOne(persistence.Detach(ctx), scope.TenantID, record) == false {
+		return fmt.Errorf("Can't store the response of the idempotency key %s", record.Key)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey Forget a request with an idempotency key, so that it can be retried
+func ReleaseIdempotencyKey(ctx context.Context, scope Scope, record IdempotencyRecord) error {
+	if persistence.DeleteOne(persistence.Detach(ctx), scope.TenantID, record) == false {
+		return fmt.Errorf("Can't release the idempotency key %s", record.Key)
+	}
+	return nil
+}
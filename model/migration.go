@@ -0,0 +1,32 @@
+package model
+
+import (
+	"context"
+
+	"gitlab.kardinal.ai/coretech/esther/logging"
+	"gitlab.kardinal.ai/coretech/esther/persistence"
+)
+
+// Migrate Create the indexes of the collections of every registered tenant, which are otherwise created when the collections are first used.
+// It is run before a new version is deployed, so that its indexes are built before it serves requests.
+// The entities are the ones registered in persistence by their init.
+func Migrate(ctx context.Context) []string {
+	errors := []string{}
+	tenants, err := persistence.ListTenants(ctx)
+	if err != nil {
+		return append(errors, err.Error())
+	}
+	for _, tenantID := range tenants {
+		for _, entity := range persistence.Entities() {
+			if err := persistence.EnsureIndexes(ctx, tenantID, entity); err != nil {
+				errors = append(errors, err.Error())
+			}
+		}
+	}
+	if len(errors) > 0 {
+		logging.Logger.WithField("errors", errors).Error("The migration has failed")
+	} else {
+		logging.Logger.WithField("tenants", len(tenants)).Info("The collections have been migrated")
+	}
+	return errors
+}
@@ -0,0 +1,158 @@
+package model
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"gitlab.kardinal.ai/coretech/esther/logging"
+	"gitlab.kardinal.ai/coretech/esther/persistence"
+	"gitlab.kardinal.ai/coretech/esther/transport"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Types of the lifecycle events of event callbacks
+const (
+	EventCallbackCreated      = "eventCallback.created"
+	EventCallbackUpdated      = "eventCallback.updated"
+	EventCallbackDeleted      = "eventCallback.deleted"
+	EventCallbackApplied      = "eventCallback.applied"
+	EventCallbackUndone       = "eventCallback.undone"
+	EventCallbackExpired      = "eventCallback.expired"
+	EventCallbackDeadLettered = "eventCallback.deadLettered"
+)
+
+// Statuses of an outbox event
+const (
+	OutboxPending   = "pending"
+	OutboxPublished = "published"
+)
+
+// Sinks the outbox events can be published to
+const (
+	OutboxSinkWebhook = "webhook"
+	OutboxSinkNATS    = transport.NATS
+	OutboxSinkKafka   = transport.Kafka
+
+	// OutboxEventTypeHeader is the header holding the type of a published event
+	OutboxEventTypeHeader = "Esther-Event-Type"
+
+	defaultOutboxPollInterval = time.Second
+	defaultOutboxRetention    = 7 * 24 * time.Hour
+	defaultOutboxMaxBackoff   = 5 * time.Minute
+	outboxBatchSize           = 100
+)
+
+var (
+	outboxSink         string
+	outboxWebhookURL   string
+	outboxTopic        string
+	outboxPollInterval time.Duration
+	outboxRetention    time.Duration
+	outboxMaxBackoff   time.Duration
+)
+
+// OutboxEvent represents a lifecycle event of an event callback, written with the change it describes
+// and published once it is committed
+type OutboxEvent struct {
+	ID              string         `bson:"_id,omitempty" json:"id"`
+	Type            string         `json:"type"`
+	TenantID        string         `json:"tenantId,omitempty"`
+	PlanID          string         `json:"planId"`
+	EventCallbackID string         `json:"eventCallbackId"`
+	EventCallback   *EventCallback `json:"eventCallback,omitempty"`
+	Actor           string         `json:"actor,omitempty"`
+	OnBehalfOf      string         `json:"onBehalfOf,omitempty"`
+	RequestID       string         `json:"requestId,omitempty"`
+	OccurredAt      time.Time      `json:"occurredAt"`
+	Status          string         `json:"-"`
+	Attempts        int            `json:"-"`
+	NextAttemptAt   time.Time      `json:"-"`
+	PublishedAt     *time.Time     `json:"-"`
+	LastError       string         `json:"-"`
+}
+
+func init() {
+	persistence.Register(OutboxEvent{})
+	initOutboxEnv()
+}
+
+func initOutboxEnv() []string {
+	errs := []string{}
+	envSink := "OUTBOX_SINK"
+	outboxSink = os.Getenv(envSink)
+	outboxWebhookURL = os.Getenv("OUTBOX_WEBHOOK_URL")
+	outboxTopic = os.Getenv("OUTBOX_TOPIC")
+	switch outboxSink {
+	case "":
+	case OutboxSinkWebhook:
+		if u, err := url.Parse(outboxWebhookURL); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Sprintf("OUTBOX_WEBHOOK_URL must be an absolute URL when %s is %s", envSink, OutboxSinkWebhook))
+			outboxSink = ""
+		}
+	case OutboxSinkNATS, OutboxSinkKafka:
+		if outboxTopic == "" {
+			errs = append(errs, fmt.Sprintf("OUTBOX_TOPIC is required when %s is %s", envSink, outboxSink))
+			outboxSink = ""
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("%s must be one of %s, %s or %s", envSink, OutboxSinkWebhook, OutboxSinkNATS, OutboxSinkKafka))
+		outboxSink = ""
+	}
+	outboxPollInterval, errs = durationFromEnv("OUTBOX_POLL_INTERVAL", defaultOutboxPollInterval, errs)
+	outboxRetention, errs = durationFromEnv("OUTBOX_RETENTION", defaultOutboxRetention, errs)
+	outboxMaxBackoff, errs = durationFromEnv("OUTBOX_MAX_BACKOFF", defaultOutboxMaxBackoff, errs)
+	if len(errs) > 0 {
+		logging.Logger.WithField("errors", errs).Error("The outbox environment is not properly set")
+	}
+	return errs
+}
+
+// Id : Get id
+func (event OutboxEvent) Id() string {
+	return event.ID
+}
+
+// ResetId : Reset id (Has to create a new Instance)
+func (event OutboxEvent) ResetId(ID string) persistence.Persistable {
+	event.ID = ID
+	return event
+}
+
+// PlanId : Get Plan Id
+func (event OutboxEvent) PlanId() string {
+	return event.PlanID
+}
+
+// EntityName : Get entity name
+func (event OutboxEvent) EntityName() string {
+	return "outbox"
+}
+
+// Indexes : Get the indexes of the collection, the events are polled by status and removed by MongoDB after the retention.
+// With a sink, only the published events are removed: the pending ones are kept until they are published, however long the sink is unavailable.
+// Without a sink, nothing publishes them and the outbox only serves the replay of the event streams, every event is removed.
+func (event OutboxEvent) Indexes() []mongo.IndexModel {
+	ttl := options.Index().
+		SetName("occurredat_ttl").
+		SetExpireAfterSeconds(int32(outboxRetention.Seconds()))
+	if outboxSink != "" {
+		ttl.SetPartialFilterExpression(bson.M{"status": OutboxPublished})
+	}
+	return []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "nextattemptat", Value: 1}}},
+		{Keys: bson.D{{Key: "occurredat", Value: 1}}, Options: ttl},
+	}
+}
+
+// FromBson : Transform to BSON
+func (event OutboxEvent) FromBson(sr *mongo.SingleResult) persistence.Persistable {
+	err := sr.Decode(&event)
+	if err != nil {
+		logging.Logger.Error(fmt.Sprintf("Cannot unmarshal outbox event : %s", err.Error()))
+	}
+	return event
+}
@@ -0,0 +1,216 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gitlab.kardinal.ai/coretech/esther/logging"
+	"gitlab.kardinal.ai/coretech/esther/persistence"
+	"gitlab.kardinal.ai/coretech/esther/transport"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// outboxClaimLease is how long a claimed event is hidden from the other relays, it outlasts the publication
+const outboxClaimLease = time.Minute
+
+// RunOutboxRelay Publish the outbox events of every tenant to the sink periodically, until ctx is done
+func RunOutboxRelay(ctx context.Context) {
+	if outboxSink == "" {
+		logging.Logger.WithField("retention", outboxRetention.String()).Info("OUTBOX_SINK is not set, the outbox events are not published and are removed after the retention")
+		return
+	}
+	logging.Logger.WithFields(logging.LogFields{
+		"sink":     outboxSink,
+		"interval": outboxPollInterval.String(),
+	}).Info("Starting the relay of outbox events")
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := RelayOutboxEvents(ctx); err != nil {
+				logging.Logger.WithField("error", err).Error("The relay of outbox events has failed")
+			}
+		}
+	}
+}
+
+// RelayOutboxEvents Publish the pending outbox events of every tenant, in the order they occurred.
+// An event which could not be published is retried with an exponential backoff, after the following ones.
+func RelayOutboxEvents(ctx context.Context) error {
+	tenants, err := persistence.ListTenants(ctx)
+	if err != nil {
+		return err
+	}
+	for _, tenantID := range tenants {
+		for i := 0; i < outboxBatchSize && ctx.Err() == nil; i++ {
+			event, found, err := claimOutboxEvent(ctx, tenantID)
+			if err != nil {
+				return err
+			}
+			if !found {
+				break
+			}
+			if err := publishOutboxEvent(ctx, event); err != nil {
+				logging.FromContext(ctx).WithFields(logging.LogFields{
+					"tenant":   tenantID,
+					"event":    event.ID,
+					"type":     event.Type,
+					"attempts": event.Attempts,
+					"error":    err.Error(),
+				}).Warn("The outbox event could not be published")
+				retryOutboxEvent(ctx, tenantID, event, err)
+				continue
+			}
+			markOutboxEventPublished(ctx, tenantID, event)
+		}
+	}
+	return nil
+}
+
+// recordOutboxEvent writes a lifecycle event of an event callback, in the transaction of the change when ctx holds one
+func recordOutboxEvent(ctx context.Context, scope Scope, eventType string, eventCallback EventCallback) error {
+	now := time.Now().UTC()
+	event := OutboxEvent{
+		Type:            eventType,
+		TenantID:        scope.TenantID,
+		PlanID:          eventCallback.PlanID,
+		EventCallbackID: eventCallback.ID,
+		EventCallback:   &eventCallback,
+		Actor:           scope.Actor,
+		OnBehalfOf:      scope.OnBehalfOf,
+		RequestID:       logging.RequestID(ctx),
+		OccurredAt:      now,
+		Status:          OutboxPending,
+		NextAttemptAt:   now,
+	}
+	id := persistence.InsertOne(ctx, scope.TenantID, event)
+	if id == "" {
+		return fmt.Errorf("Can't record the %s event of the event-callback %s in plan %s", eventType, eventCallback.ID, eventCallback.PlanID)
+	}
+	event.ID = id
+	persistence.AfterCommit(ctx, func() {
+		planEvents.publish(event)
+	})
+	return nil
+}
+
+// claimOutboxEvent takes the oldest pending event of a tenant, which is hidden from the other relays until its lease ends
+func claimOutboxEvent(ctx context.Context, tenantID string) (OutboxEvent, bool, error) {
+	collection := persistence.GetCollection(tenantID, OutboxEvent{})
+	ctx, cancel := persistence.GetContext(ctx)
+	defer cancel()
+	now := time.Now().UTC()
+	var event OutboxEvent
+	err := collection.FindOneAndUpdate(ctx,
+		bson.M{"status": OutboxPending, "nextattemptat": bson.M{"$lte": now}},
+		bson.M{
+			"$set": bson.M{"nextattemptat": now.Add(outboxClaimLease)},
+			"$inc": bson.M{"attempts": 1},
+		},
+		options.FindOneAndUpdate().
+			SetSort(bson.D{{Key: "_id", Value: 1}}).
+			SetReturnDocument(options.After)).Decode(&event)
+	if err == mongo.ErrNoDocuments {
+		return OutboxEvent{}, false, nil
+	}
+	if err != nil {
+		return OutboxEvent{}, false, fmt.Errorf("Can't claim the outbox events of tenant %s: %s", tenantID, err)
+	}
+	return event, true, nil
+}
+
+// publishOutboxEvent sends an event to the sink, the consumers deduplicate it by its id sent as idempotency key
+func publishOutboxEvent(ctx context.Context, event OutboxEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	message := transport.Message{Headers: http.Header{}, Payload: payload}
+	message.Headers.Set("Content-Type", "application/json")
+	message.Headers.Set(IdempotencyKeyHeader, event.ID)
+	message.Headers.Set(OutboxEventTypeHeader, event.Type)
+	if event.RequestID != "" {
+		message.Headers.Set(logging.RequestIDHeader, event.RequestID)
+	}
+	name := outboxSink
+	if outboxSink == OutboxSinkWebhook {
+		name = transport.HTTP
+		message.Method = http.MethodPost
+		message.URI = outboxWebhookURL
+	} else {
+		message.Topic = outboxTopic
+		message.Key = event.PlanID
+	}
+	sender, err := transport.Get(name)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, actionTimeout)
+	defer cancel()
+	result, err := sender.Send(ctx, message)
+	if err != nil {
+		return err
+	}
+	if outboxSink == OutboxSinkWebhook && (result.StatusCode < 200 || result.StatusCode >= 300) {
+		return fmt.Errorf("The webhook has responded %s", result.Status)
+	}
+	return nil
+}
+
+func markOutboxEventPublished(ctx context.Context, tenantID string, event OutboxEvent) {
+	now := time.Now().UTC()
+	updateOutboxEvent(ctx, tenantID, event, bson.M{
+		"status":      OutboxPublished,
+		"publishedat": now,
+		"lasterror":   "",
+	})
+}
+
+// retryOutboxEvent schedules the next attempt of an event, doubling the delay after every failure
+func retryOutboxEvent(ctx context.Context, tenantID string, event OutboxEvent, publishErr error) {
+	updateOutboxEvent(ctx, tenantID, event, bson.M{
+		"nextattemptat": time.Now().UTC().Add(retryDelay(event.Attempts, outboxPollInterval, outboxMaxBackoff)),
+		"lasterror":     publishErr.Error(),
+	})
+}
+
+// retryDelay is the delay before the next attempt, doubling after every failed attempt up to max
+func retryDelay(attempts int, base time.Duration, max time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// updateOutboxEvent records the outcome of a publication, even when the relay is stopping.
+// An outcome which is not recorded makes the event be published again once its lease ends.
+func updateOutboxEvent(ctx context.Context, tenantID string, event OutboxEvent, set bson.M) {
+	id, err := primitive.ObjectIDFromHex(event.ID)
+	if err != nil {
+		logging.FromContext(ctx).Error(fmt.Sprintf("Can't retrieve HEX ObjectId : %s", event.ID))
+		return
+	}
+	collection := persistence.GetCollection(tenantID, OutboxEvent{})
+	ctx, cancel := persistence.GetContext(persistence.Detach(ctx))
+	defer cancel()
+	if _, err := collection.UpdateOne(ctx, bson.M{"_id": id, "status": OutboxPending}, bson.M{"$set": set}); err != nil {
+		logging.FromContext(ctx).WithFields(logging.LogFields{
+			"tenant": tenantID,
+			"event":  event.ID,
+			"error":  err.Error(),
+		}).Error("The outcome of the outbox event could not be recorded")
+	}
+}
@@ -0,0 +1,60 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// outboxTTLIndex returns the index removing the outbox events after the retention
+func outboxTTLIndex(t *testing.T) mongo.IndexModel {
+	for _, index := range (OutboxEvent{}).Indexes() {
+		if index.Options != nil && index.Options.ExpireAfterSeconds != nil {
+			return index
+		}
+	}
+	t.Fatal("The outbox has no TTL index")
+	return mongo.IndexModel{}
+}
+
+// removedByTTL checks whether MongoDB removes an event once it is older than the retention of a TTL index on occurredat
+func removedByTTL(index mongo.IndexModel, event OutboxEvent) bool {
+	if filter, ok := index.Options.PartialFilterExpression.(bson.M); ok {
+		for key, value := range filter {
+			if key != "status" || value != event.Status {
+				return false
+			}
+		}
+	}
+	return time.Since(event.OccurredAt) > time.Duration(*index.Options.ExpireAfterSeconds)*time.Second
+}
+
+func TestOutboxRetention(t *testing.T) {
+	defer func(sink string) { outboxSink = sink }(outboxSink)
+	old := time.Now().Add(-2 * outboxRetention)
+	pending := OutboxEvent{Status: OutboxPending, OccurredAt: old}
+	published := OutboxEvent{Status: OutboxPublished, OccurredAt: old}
+	recent := OutboxEvent{Status: OutboxPending, OccurredAt: time.Now()}
+
+	tests := []struct {
+		sink    string
+		event   OutboxEvent
+		removed bool
+	}{
+		// Without a sink, nothing publishes the events
+		{"", pending, true},
+		{"", published, true},
+		{"", recent, false},
+		// With a sink, the pending events are kept until they are published
+		{OutboxSinkWebhook, pending, false},
+		{OutboxSinkWebhook, published, true},
+	}
+	for _, test := range tests {
+		outboxSink = test.sink
+		if removed := removedByTTL(outboxTTLIndex(t), test.event); removed != test.removed {
+			t.Errorf("sink %q, %s event which occurred at %s: removed %t, instead of %t", test.sink, test.event.Status, test.event.OccurredAt, removed, test.removed)
+		}
+	}
+}
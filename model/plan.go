@@ -0,0 +1,111 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"gitlab.kardinal.ai/coretech/esther/logging"
+	"gitlab.kardinal.ai/coretech/esther/persistence"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Plan represents the settings of a plan
+type Plan struct {
+	ID              string `bson:"_id,omitempty" json:"-"`
+	PlanID          string `json:"planId"`
+	DefaultTTL      string `json:"defaultTtl,omitempty"`
+	OnExpire        string `json:"onExpire,omitempty"`
+	MaxCallbacks    int64  `json:"maxCallbacks,omitempty"`
+	MaxPayloadBytes int64  `json:"maxPayloadBytes,omitempty"`
+}
+
+// Id : Get id
+func (plan Plan) Id() string {
+	return plan.ID
+}
+
+// ResetId : Reset id (Has to create a new Instance)
+func (plan Plan) ResetId(ID string) persistence.Persistable {
+	plan.ID = ID
+	return plan
+}
+
+// PlanId : Get Plan Id
+func (plan Plan) PlanId() string {
+	return plan.PlanID
+}
+
+// EntityName : Get entity name
+func (plan Plan) EntityName() string {
+	return "plan"
+}
+
+func init() {
+	persistence.Register(Plan{})
+}
+
+// Indexes : Get the indexes of the collection
+func (plan Plan) Indexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "planid", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	}
+}
+
+// FromBson : Transform to BSON
+func (plan Plan) FromBson(sr *mongo.SingleResult) persistence.Persistable {
+	err := sr.Decode(&plan)
+	if err != nil {
+		logging.Logger.Error(fmt.Sprintf("Cannot unmarshal plan : %s", err.Error()))
+	}
+	return plan
+}
+
+// TTL : Get the default time to live of the event callbacks of the plan (0 means no expiry)
+func (plan Plan) TTL() time.Duration {
+	ttl, _ := time.ParseDuration(plan.DefaultTTL)
+	return ttl
+}
+
+// Validate : Check the settings of the plan
+func (plan Plan) Validate() error {
+	if plan.DefaultTTL != "" {
+		if ttl, err := time.ParseDuration(plan.DefaultTTL); err != nil || ttl < 0 {
+			return fmt.Errorf("The default TTL must be a positive duration (e.g. 72h): %s", plan.DefaultTTL)
+		}
+	}
+	if plan.MaxCallbacks < 0 || plan.MaxPayloadBytes < 0 {
+		return fmt.Errorf("The quotas of a plan must be positive (0 means the default quota)")
+	}
+	// The quotas of a plan can only be stricter than the limits set by the operator
+	if defaultPlanMaxCallbacks > 0 && plan.MaxCallbacks > defaultPlanMaxCallbacks {
+		return fmt.Errorf("The maximum number of event callbacks of a plan cannot exceed %d", defaultPlanMaxCallbacks)
+	}
+	if defaultPlanMaxPayloadBytes > 0 && plan.MaxPayloadBytes > defaultPlanMaxPayloadBytes {
+		return fmt.Errorf("The maximum size of an event callback of a plan cannot exceed %d bytes", defaultPlanMaxPayloadBytes)
+	}
+	return validateExpiryAction(plan.OnExpire)
+}
+
+// CallbackQuota : Get the maximum number of pending event callbacks of the plan (0 means unlimited)
+func (plan Plan) CallbackQuota() int64 {
+	return stricterQuota(plan.MaxCallbacks, defaultPlanMaxCallbacks)
+}
+
+// PayloadQuota : Get the maximum size in bytes of an event callback of the plan (0 means unlimited)
+func (plan Plan) PayloadQuota() int64 {
+	return stricterQuota(plan.MaxPayloadBytes, defaultPlanMaxPayloadBytes)
+}
+
+// stricterQuota returns the quota of a plan when it is stricter than the limit of the operator, which applies otherwise (0 means unlimited),
+// as the limit may have been lowered since the quota was set
+func stricterQuota(quota int64, limit int64) int64 {
+	if quota > 0 && (limit == 0 || quota < limit) {
+		return quota
+	}
+	return limit
+}
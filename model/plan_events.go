@@ -0,0 +1,200 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"gitlab.kardinal.ai/coretech/esther/logging"
+	"gitlab.kardinal.ai/coretech/esther/persistence"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// planEventsBuffer is the number of events a subscriber can lag behind before its stream is broken
+const planEventsBuffer = 64
+
+// ErrInvalidEventID is returned when a stream is resumed from an event id which is not one
+var ErrInvalidEventID = errors.New("invalid event id")
+
+// planEvents broadcasts the committed lifecycle events of this instance, when the outbox cannot be watched
+var planEvents = &planEventBroker{subscribers: map[string]map[chan OutboxEvent]bool{}}
+
+// planEventBroker broadcasts the lifecycle events to the subscribers of their plan
+type planEventBroker struct {
+	mutex       sync.Mutex
+	subscribers map[string]map[chan OutboxEvent]bool
+}
+
+func planEventsKey(tenantID string, planID string) string {
+	return tenantID + "/" + planID
+}
+
+func (broker *planEventBroker) subscribe(tenantID string, planID string) chan OutboxEvent {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	key := planEventsKey(tenantID, planID)
+	if broker.subscribers[key] == nil {
+		broker.subscribers[key] = map[chan OutboxEvent]bool{}
+	}
+	events := make(chan OutboxEvent, planEventsBuffer)
+	broker.subscribers[key][events] = true
+	return events
+}
+
+func (broker *planEventBroker) unsubscribe(tenantID string, planID string, events chan OutboxEvent) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	key := planEventsKey(tenantID, planID)
+	if broker.subscribers[key][events] {
+		delete(broker.subscribers[key], events)
+		close(events)
+	}
+	if len(broker.subscribers[key]) == 0 {
+		delete(broker.subscribers, key)
+	}
+}
+
+// publish never blocks: the stream of a subscriber which lags behind is closed, so that it resumes from its last event
+func (broker *planEventBroker) publish(event OutboxEvent) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	key := planEventsKey(event.TenantID, event.PlanID)
+	for events := range broker.subscribers[key] {
+		select {
+		case events <- event:
+		default:
+			delete(broker.subscribers[key], events)
+			close(events)
+		}
+	}
+	if len(broker.subscribers[key]) == 0 {
+		delete(broker.subscribers, key)
+	}
+}
+
+// SubscribePlanEvents Stream the lifecycle events of the event callbacks of a plan, starting after lastEventID when given.
+// The events are watched in the outbox, or received from this instance only when the database cannot be watched.
+// The channel is closed once ctx is done, or when the stream is broken and has to be resumed.
+func SubscribePlanEvents(ctx context.Context, scope Scope, planID string, lastEventID string) (<-chan OutboxEvent, error) {
+	var lastID primitive.ObjectID
+	if lastEventID != "" {
+		var err error
+		if lastID, err = primitive.ObjectIDFromHex(lastEventID); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidEventID, lastEventID)
+		}
+	}
+	// The live events are subscribed before the missed ones are read, so that none is lost in between
+	live, err := watchPlanEvents(ctx, scope, planID)
+	if err != nil {
+		return nil, err
+	}
+	events := make(chan OutboxEvent)
+	go func() {
+		defer close(events)
+		replayed := map[string]bool{}
+		if lastEventID != "" {
+			missed, err := findOutboxEventsAfter(ctx, scope, planID, lastID)
+			if err != nil {
+				logging.FromContext(ctx).WithField("error", err).Error("The missed plan events could not be replayed")
+				return
+			}
+			for _, event := range missed {
+				replayed[event.ID] = true
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+		for {
+			select {
+			case event, ok := <-live:
+				if !ok {
+					return
+				}
+				if replayed[event.ID] {
+					continue
+				}
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// watchPlanEvents receives the events of a plan written from now on, until ctx is done
+func watchPlanEvents(ctx context.Context, scope Scope, planID string) (<-chan OutboxEvent, error) {
+	if !persistence.SupportsChangeStreams(ctx) {
+		events := planEvents.subscribe(scope.TenantID, planID)
+		go func() {
+			<-ctx.Done()
+			planEvents.unsubscribe(scope.TenantID, planID, events)
+		}()
+		return events, nil
+	}
+
+	collection := persistence.GetCollection(scope.TenantID, OutboxEvent{})
+	pipeline := mongo.Pipeline{
+		bson.D{{Key: "$match", Value: bson.M{"operationType": "insert", "fullDocument.planid": planID}}},
+	}
+	stream, err := collection.Watch(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("Can't watch the events of plan %s: %s", planID, err)
+	}
+	events := make(chan OutboxEvent)
+	go func() {
+		defer close(events)
+		defer stream.Close(persistence.Detach(ctx))
+		for stream.Next(ctx) {
+			var change struct {
+				FullDocument OutboxEvent `bson:"fullDocument"`
+			}
+			if err := stream.Decode(&change); err != nil {
+				logging.FromContext(ctx).Error(err)
+				continue
+			}
+			select {
+			case events <- change.FullDocument:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err := stream.Err(); err != nil && ctx.Err() == nil {
+			logging.FromContext(ctx).WithField("error", err.Error()).Error("The watch of the plan events has failed")
+		}
+	}()
+	return events, nil
+}
+
+// findOutboxEventsAfter finds the events of a plan written after an event, which are kept for OUTBOX_RETENTION
+func findOutboxEventsAfter(ctx context.Context, scope Scope, planID string, lastID primitive.ObjectID) ([]OutboxEvent, error) {
+	collection := persistence.GetCollection(scope.TenantID, OutboxEvent{})
+	ctx, cancel := persistence.GetContext(ctx)
+	defer cancel()
+	cur, err := collection.Find(ctx, bson.M{"planid": planID, "_id": bson.M{"$gt": lastID}}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("Can't find the events of plan %s: %s", planID, err)
+	}
+	events := make([]OutboxEvent, 0)
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var event OutboxEvent
+		if err := cur.Decode(&event); err != nil {
+			logging.FromContext(ctx).Error(err)
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
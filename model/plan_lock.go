@@ -0,0 +1,106 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gitlab.kardinal.ai/coretech/esther/logging"
+	"gitlab.kardinal.ai/coretech/esther/persistence"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Operations holding the lock of a plan
+const (
+	LockOperationApply  = "apply"
+	LockOperationUndo   = "undo"
+	LockOperationExpire = "expire"
+
+	defaultPlanLockLease = time.Minute
+)
+
+// ErrPlanLocked is returned when the lock of a plan is held by another operation
+var ErrPlanLocked = errors.New("plan locked")
+
+var planLockLease time.Duration
+
+// PlanLock represents the lease taken on a plan by an operation, it is renewed until the operation ends
+type PlanLock struct {
+	ID         string    `bson:"_id,omitempty" json:"-"`
+	PlanID     string    `json:"planId"`
+	Token      string    `json:"-"`
+	Owner      string    `json:"owner"`
+	Holder     string    `json:"holder,omitempty"`
+	Operation  string    `json:"operation"`
+	RequestID  string    `json:"requestId,omitempty"`
+	AcquiredAt time.Time `json:"acquiredAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// PlanLockedError is returned when the lock of a plan is held, with the current holder of the lock
+type PlanLockedError struct {
+	Lock PlanLock
+}
+
+func (err *PlanLockedError) Error() string {
+	return fmt.Sprintf("%s: plan %s is locked by %s on %s since %s", ErrPlanLocked, err.Lock.PlanID, err.Lock.Operation, err.Lock.Owner, err.Lock.AcquiredAt.Format(time.RFC3339))
+}
+
+// Is : Match ErrPlanLocked
+func (err *PlanLockedError) Is(target error) bool {
+	return target == ErrPlanLocked
+}
+
+func init() {
+	persistence.Register(PlanLock{})
+	var errs []string
+	if planLockLease, errs = durationFromEnv("PLAN_LOCK_LEASE", defaultPlanLockLease, nil); len(errs) > 0 {
+		logging.Logger.WithField("errors", errs).Error("The plan lock environment is not properly set")
+	}
+}
+
+// Id : Get id
+func (lock PlanLock) Id() string {
+	return lock.ID
+}
+
+// ResetId : Reset id (Has to create a new Instance)
+func (lock PlanLock) ResetId(ID string) persistence.Persistable {
+	lock.ID = ID
+	return lock
+}
+
+// PlanId : Get Plan Id
+func (lock PlanLock) PlanId() string {
+	return lock.PlanID
+}
+
+// EntityName : Get entity name
+func (lock PlanLock) EntityName() string {
+	return "plan_lock"
+}
+
+// Indexes : Get the indexes of the collection, a plan has one lock which is removed by MongoDB some time after it has expired
+func (lock PlanLock) Indexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "planid", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expiresat", Value: 1}},
+			Options: options.Index().SetName("expiresat_ttl").SetExpireAfterSeconds(int32(planLockLease.Seconds())),
+		},
+	}
+}
+
+// FromBson : Transform to BSON
+func (lock PlanLock) FromBson(sr *mongo.SingleResult) persistence.Persistable {
+	err := sr.Decode(&lock)
+	if err != nil {
+		logging.Logger.Error(fmt.Sprintf("Cannot unmarshal plan lock : %s", err.Error()))
+	}
+	return lock
+}
@@ -0,0 +1,162 @@
+package model
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"gitlab.kardinal.ai/coretech/esther/logging"
+	"gitlab.kardinal.ai/coretech/esther/persistence"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CheckPlanUnlocked Check that no operation holds the lock of a plan, before editing its events.
+// The edit checks the lock again in its transaction with guardPlanUnlocked, this check reports the holder of the lock.
+func CheckPlanUnlocked(ctx context.Context, scope Scope, planID string) error {
+	collection := persistence.GetCollection(scope.TenantID, PlanLock{})
+	ctx, cancel := persistence.GetContext(ctx)
+	defer cancel()
+	var lock PlanLock
+	err := collection.FindOne(ctx, bson.M{"planid": planID, "expiresat": bson.M{"$gte": time.Now().UTC()}}).Decode(&lock)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Can't check the lock of plan %s: %s", planID, err)
+	}
+	return &PlanLockedError{Lock: lock}
+}
+
+// guardPlanUnlocked Check, in the transaction of ctx, that no operation holds the lock of a plan before editing its events.
+// The lock document of the plan is written, so that the lock cannot be acquired until the edit is committed:
+// when the lock is held, the upsert tries to insert a second lock of the plan, which the unique index rejects.
+func guardPlanUnlocked(ctx context.Context, scope Scope, planID string) error {
+	collection := persistence.GetCollection(scope.TenantID, PlanLock{})
+	ctx, cancel := persistence.GetContext(ctx)
+	defer cancel()
+	now := time.Now().UTC()
+	_, err := collection.UpdateOne(ctx,
+		bson.M{"planid": planID, "expiresat": bson.M{"$lt": now}},
+		bson.M{
+			"$set":         bson.M{"editedat": now},
+			"$setOnInsert": bson.M{"expiresat": time.Time{}},
+		},
+		options.Update().SetUpsert(true))
+	if err == nil {
+		return nil
+	}
+	if persistence.IsDuplicateKey(err) {
+		return fmt.Errorf("%w: plan %s is locked", ErrPlanLocked, planID)
+	}
+	return fmt.Errorf("Can't check the lock of plan %s: %s", planID, err)
+}
+
+// withPlanLock runs an operation while holding the lock of a plan. The lease of the lock is renewed until the operation ends,
+// the context of the operation is canceled if the lease is lost.
+func withPlanLock(ctx context.Context, scope Scope, planID string, operation string, run func(ctx context.Context) error) error {
+	lock, err := acquirePlanLock(ctx, scope, planID, operation)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		renewPlanLock(ctx, scope, lock, cancel)
+	}()
+	defer func() {
+		cancel()
+		<-renewed
+		releasePlanLock(ctx, scope, lock)
+	}()
+	return run(ctx)
+}
+
+// acquirePlanLock takes the lock of a plan when it is free or its lease has expired
+func acquirePlanLock(ctx context.Context, scope Scope, planID string, operation string) (PlanLock, error) {
+	token := make([]byte, 16)
+	rand.Read(token)
+	now := time.Now().UTC()
+	lock := PlanLock{
+		PlanID:     planID,
+		Token:      hex.EncodeToString(token),
+		Owner:      InstanceID,
+		Holder:     scope.Actor,
+		Operation:  operation,
+		RequestID:  logging.RequestID(ctx),
+		AcquiredAt: now,
+		ExpiresAt:  now.Add(planLockLease),
+	}
+
+	collection := persistence.GetCollection(scope.TenantID, PlanLock{})
+	ctx, cancel := persistence.GetContext(ctx)
+	defer cancel()
+	// When the lock is held, the upsert tries to insert a second lock of the plan, which the unique index rejects
+	_, err := collection.UpdateOne(ctx,
+		bson.M{"planid": planID, "expiresat": bson.M{"$lt": now}},
+		bson.M{"$set": bson.M{
+			"token":      lock.Token,
+			"owner":      lock.Owner,
+			"holder":     lock.Holder,
+			"operation":  lock.Operation,
+			"requestid":  lock.RequestID,
+			"acquiredat": lock.AcquiredAt,
+			"expiresat":  lock.ExpiresAt,
+		}},
+		options.Update().SetUpsert(true))
+	if err == nil {
+		logging.FromContext(ctx).WithFields(logging.LogFields{"planId": planID, "operation": operation}).Info("Plan locked")
+		return lock, nil
+	}
+	if !persistence.IsDuplicateKey(err) {
+		return PlanLock{}, fmt.Errorf("Can't lock plan %s: %s", planID, err)
+	}
+	var holder PlanLock
+	if err := collection.FindOne(ctx, bson.M{"planid": planID}).Decode(&holder); err != nil {
+		return PlanLock{}, fmt.Errorf("%w: plan %s is locked", ErrPlanLocked, planID)
+	}
+	return PlanLock{}, &PlanLockedError{Lock: holder}
+}
+
+// renewPlanLock extends the lease of a lock until ctx is done, and calls lost when the lease could not be extended
+func renewPlanLock(ctx context.Context, scope Scope, lock PlanLock, lost context.CancelFunc) {
+	ticker := time.NewTicker(planLockLease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			collection := persistence.GetCollection(scope.TenantID, PlanLock{})
+			renewCtx, cancel := persistence.GetContext(ctx)
+			ur, err := collection.UpdateOne(renewCtx,
+				bson.M{"planid": lock.PlanID, "token": lock.Token},
+				bson.M{"$set": bson.M{"expiresat": time.Now().UTC().Add(planLockLease)}})
+			cancel()
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil || ur.MatchedCount == 0 {
+				logging.FromContext(ctx).WithFields(logging.LogFields{"planId": lock.PlanID, "error": err}).Error("The lock of the plan was lost, stopping its operation")
+				lost()
+				return
+			}
+		}
+	}
+}
+
+// releasePlanLock frees the lock of a plan, if it is still held
+func releasePlanLock(ctx context.Context, scope Scope, lock PlanLock) {
+	collection := persistence.GetCollection(scope.TenantID, PlanLock{})
+	ctx, cancel := persistence.GetContext(persistence.Detach(ctx))
+	defer cancel()
+	if _, err := collection.DeleteOne(ctx, bson.M{"planid": lock.PlanID, "token": lock.Token}); err != nil {
+		logging.FromContext(ctx).WithFields(logging.LogFields{"planId": lock.PlanID, "error": err}).Error("The lock of the plan could not be released, it will expire")
+		return
+	}
+	logging.FromContext(ctx).WithFields(logging.LogFields{"planId": lock.PlanID, "operation": lock.Operation}).Info("Plan unlocked")
+}
@@ -0,0 +1,44 @@
+package model
+
+import (
+	"context"
+	"fmt"
+
+	"gitlab.kardinal.ai/coretech/esther/logging"
+	"gitlab.kardinal.ai/coretech/esther/persistence"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// FindPlan Find the settings of a plan, the default settings are returned for a plan without settings
+func FindPlan(ctx context.Context, scope Scope, planID string) (Plan, error) {
+	collection := persistence.GetCollection(scope.TenantID, Plan{})
+	ctx, cancel := persistence.GetContext(ctx)
+	defer cancel()
+	plan := Plan{PlanID: planID}
+	err := collection.FindOne(ctx, bson.M{"planid": planID}).Decode(&plan)
+	if err != nil && err != mongo.ErrNoDocuments {
+		logging.FromContext(ctx).WithField("error", err).Error(fmt.Sprintf("Can't retrieve the plan %s", planID))
+		return Plan{}, fmt.Errorf("Can't retrieve the plan %s", planID)
+	}
+	return plan, nil
+}
+
+// SavePlan Create or replace the settings of a plan
+func SavePlan(ctx context.Context, scope Scope, planID string, plan Plan) (Plan, error) {
+	plan.ID = ""
+	plan.PlanID = planID
+	if err := plan.Validate(); err != nil {
+		return Plan{}, err
+	}
+	collection := persistence.GetCollection(scope.TenantID, Plan{})
+	ctx, cancel := persistence.GetContext(ctx)
+	defer cancel()
+	_, err := collection.ReplaceOne(ctx, bson.M{"planid": planID}, plan, options.Replace().SetUpsert(true))
+	if err != nil {
+		logging.FromContext(ctx).WithField("error", err).Error(fmt.Sprintf("Can't save the plan %s", planID))
+		return Plan{}, fmt.Errorf("Can't save the plan %s", planID)
+	}
+	return plan, nil
+}
@@ -0,0 +1,83 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gitlab.kardinal.ai/coretech/esther/logging"
+)
+
+var (
+	// ErrQuotaExceeded is returned when a tenant or a plan cannot store more event callbacks
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	// ErrPayloadTooLarge is returned when an event callback is larger than the quota of its plan
+	ErrPayloadTooLarge = errors.New("payload too large")
+)
+
+var (
+	defaultTenantMaxCallbacks  int64
+	tenantMaxCallbacks         map[string]int64
+	defaultPlanMaxCallbacks    int64
+	defaultPlanMaxPayloadBytes int64
+)
+
+func init() {
+	initQuotaEnv()
+}
+
+func initQuotaEnv() []string {
+	errs := []string{}
+	tenantMaxCallbacks = map[string]int64{}
+
+	defaultTenantMaxCallbacks, errs = positiveIntFromEnv("TENANT_MAX_CALLBACKS", errs)
+
+	defaultPlanMaxCallbacks, errs = positiveIntFromEnv("PLAN_MAX_CALLBACKS", errs)
+	defaultPlanMaxPayloadBytes, errs = positiveIntFromEnv("PLAN_MAX_PAYLOAD_BYTES", errs)
+
+	envQuotas := "TENANT_QUOTAS"
+	for _, entry := range strings.Split(os.Getenv(envQuotas), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 2)
+		var max int64
+		var err error
+		if len(parts) == 2 {
+			max, err = strconv.ParseInt(parts[1], 10, 64)
+		}
+		if len(parts) != 2 || err != nil || max < 0 {
+			errs = append(errs, fmt.Sprintf("%s contains an invalid entry, expected <tenant>:<max callbacks>", envQuotas))
+			continue
+		}
+		tenantMaxCallbacks[parts[0]] = max
+	}
+
+	if len(errs) > 0 {
+		logging.Logger.WithField("errors", errs).Error("The quota environment is not properly set")
+	}
+	return errs
+}
+
+// TenantMaxCallbacks returns the maximum number of pending event callbacks of a tenant (0 means unlimited)
+func TenantMaxCallbacks(tenantID string) int64 {
+	if max, ok := tenantMaxCallbacks[tenantID]; ok {
+		return max
+	}
+	return defaultTenantMaxCallbacks
+}
+
+// positiveIntFromEnv reads a positive integer, 0 when it is not set
+func positiveIntFromEnv(env string, errs []string) (int64, []string) {
+	value := os.Getenv(env)
+	if value == "" {
+		return 0, errs
+	}
+	max, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || max < 0 {
+		return 0, append(errs, fmt.Sprintf("%s must be a positive integer", env))
+	}
+	return max, errs
+}
@@ -0,0 +1,11 @@
+package model
+
+// Scope identifies on behalf of whom a model operation is done
+type Scope struct {
+	TenantID string
+	// Actor is the authenticated principal, or the component of Esther doing the operation
+	Actor string
+	// OnBehalfOf is who the client declares acting for (X-Actor), it is not authenticated
+	OnBehalfOf string
+	ClientIP   string
+}
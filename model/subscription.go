@@ -0,0 +1,225 @@
+package model
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"gitlab.kardinal.ai/coretech/esther/logging"
+	"gitlab.kardinal.ai/coretech/esther/persistence"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Types of the notifications delivered to the subscriptions
+const (
+	NotificationApplyCompleted = "apply.completed"
+	NotificationApplyFailed    = "apply.failed"
+	NotificationUndoCompleted  = "undo.completed"
+	NotificationUndoFailed     = "undo.failed"
+)
+
+// Statuses of a webhook delivery
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+const (
+	// SignatureHeader is the header holding the signature of a notification: t=<unix time>,v1=<hex HMAC-SHA256 of "<t>.<body>">
+	SignatureHeader = "Esther-Signature"
+	// NotificationTypeHeader is the header holding the type of a notification
+	NotificationTypeHeader = "Esther-Notification-Type"
+
+	minSubscriptionSecretLength = 16
+
+	defaultWebhookPollInterval      = time.Second
+	defaultWebhookMaxAttempts       = 10
+	defaultWebhookMaxBackoff        = 5 * time.Minute
+	defaultWebhookDeliveryRetention = 7 * 24 * time.Hour
+)
+
+var (
+	webhookPollInterval      time.Duration
+	webhookMaxAttempts       int
+	webhookMaxBackoff        time.Duration
+	webhookDeliveryRetention time.Duration
+)
+
+// Subscription represents a webhook notified when the applications and undos of plans complete or fail
+type Subscription struct {
+	ID          string    `bson:"_id,omitempty" json:"id"`
+	URL         string    `json:"url" binding:"required"`
+	EventTypes  []string  `json:"eventTypes" binding:"required"`
+	PlanIDs     []string  `json:"planIds"`
+	Secret      string    `json:"secret,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Actor       string    `json:"actor,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Notification is the body of a webhook delivery
+type Notification struct {
+	ID            string         `json:"id"`
+	Type          string         `json:"type"`
+	TenantID      string         `json:"tenantId,omitempty"`
+	PlanID        string         `json:"planId"`
+	OccurredAt    time.Time      `json:"occurredAt"`
+	ApplyRun      *ApplyRun      `json:"applyRun,omitempty"`
+	EventCallback *EventCallback `json:"eventCallback,omitempty"`
+	Error         string         `json:"error,omitempty"`
+}
+
+// WebhookDelivery represents the delivery of a notification to a subscription, with the log of its attempts
+type WebhookDelivery struct {
+	ID             string            `bson:"_id,omitempty" json:"id"`
+	SubscriptionID string            `json:"subscriptionId"`
+	PlanID         string            `json:"planId"`
+	Notification   Notification      `json:"notification"`
+	Status         string            `json:"status"`
+	AttemptCount   int               `json:"attemptCount"`
+	Attempts       []DeliveryAttempt `json:"attempts"`
+	NextAttemptAt  time.Time         `json:"nextAttemptAt"`
+	CreatedAt      time.Time         `json:"createdAt"`
+	DeliveredAt    *time.Time        `json:"deliveredAt,omitempty"`
+	// EndedAt is set once the delivery is delivered or has failed, the deliveries still retried are never removed
+	EndedAt *time.Time `json:"endedAt,omitempty"`
+}
+
+// DeliveryAttempt represents one attempt to deliver a notification
+type DeliveryAttempt struct {
+	AttemptedAt time.Time `json:"attemptedAt"`
+	StatusCode  int       `json:"statusCode,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Latency     float64   `json:"latency"`
+}
+
+func init() {
+	persistence.Register(Subscription{}, WebhookDelivery{})
+	initWebhookEnv()
+}
+
+func initWebhookEnv() []string {
+	errs := []string{}
+	webhookPollInterval, errs = durationFromEnv("WEBHOOK_POLL_INTERVAL", defaultWebhookPollInterval, errs)
+	webhookMaxBackoff, errs = durationFromEnv("WEBHOOK_MAX_BACKOFF", defaultWebhookMaxBackoff, errs)
+	webhookDeliveryRetention, errs = durationFromEnv("WEBHOOK_DELIVERY_RETENTION", defaultWebhookDeliveryRetention, errs)
+	var maxAttempts int64
+	maxAttempts, errs = positiveIntFromEnv("WEBHOOK_MAX_ATTEMPTS", errs)
+	webhookMaxAttempts = int(maxAttempts)
+	if webhookMaxAttempts == 0 {
+		webhookMaxAttempts = defaultWebhookMaxAttempts
+	}
+	if len(errs) > 0 {
+		logging.Logger.WithField("errors", errs).Error("The webhook environment is not properly set")
+	}
+	return errs
+}
+
+// Validate : Check the URL, the event types and the secret of a subscription
+func (subscription Subscription) Validate() error {
+	u, err := url.Parse(subscription.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("The url must be an absolute http or https URL: %s", subscription.URL)
+	}
+	if len(subscription.EventTypes) == 0 {
+		return fmt.Errorf("At least one event type is required")
+	}
+	for _, eventType := range subscription.EventTypes {
+		switch eventType {
+		case NotificationApplyCompleted, NotificationApplyFailed, NotificationUndoCompleted, NotificationUndoFailed:
+		default:
+			return fmt.Errorf("The event type must be one of %s, %s, %s or %s: %s",
+				NotificationApplyCompleted, NotificationApplyFailed, NotificationUndoCompleted, NotificationUndoFailed, eventType)
+		}
+	}
+	if subscription.Secret != "" && len(subscription.Secret) < minSubscriptionSecretLength {
+		return fmt.Errorf("The secret must be at least %d characters long", minSubscriptionSecretLength)
+	}
+	return nil
+}
+
+// Id : Get id
+func (subscription Subscription) Id() string {
+	return subscription.ID
+}
+
+// ResetId : Reset id (Has to create a new Instance)
+func (subscription Subscription) ResetId(ID string) persistence.Persistable {
+	subscription.ID = ID
+	return subscription
+}
+
+// PlanId : Get Plan Id, a subscription is not bound to one plan
+func (subscription Subscription) PlanId() string {
+	return ""
+}
+
+// EntityName : Get entity name
+func (subscription Subscription) EntityName() string {
+	return "subscription"
+}
+
+// Indexes : Get the indexes of the collection, the subscriptions are matched by event type
+func (subscription Subscription) Indexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{Keys: bson.D{{Key: "eventtypes", Value: 1}}},
+	}
+}
+
+// FromBson : Transform to BSON
+func (subscription Subscription) FromBson(sr *mongo.SingleResult) persistence.Persistable {
+	err := sr.Decode(&subscription)
+	if err != nil {
+		logging.Logger.Error(fmt.Sprintf("Cannot unmarshal subscription : %s", err.Error()))
+	}
+	return subscription
+}
+
+// Id : Get id
+func (delivery WebhookDelivery) Id() string {
+	return delivery.ID
+}
+
+// ResetId : Reset id (Has to create a new Instance)
+func (delivery WebhookDelivery) ResetId(ID string) persistence.Persistable {
+	delivery.ID = ID
+	return delivery
+}
+
+// PlanId : Get Plan Id
+func (delivery WebhookDelivery) PlanId() string {
+	return delivery.PlanID
+}
+
+// EntityName : Get entity name
+func (delivery WebhookDelivery) EntityName() string {
+	return "webhook_delivery"
+}
+
+// Indexes : Get the indexes of the collection, the deliveries are polled by status and removed by MongoDB after the retention
+// once delivered or failed (the partial indexes of MongoDB 4.4 cannot match a list of statuses, they match the end of the delivery)
+func (delivery WebhookDelivery) Indexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "nextattemptat", Value: 1}}},
+		{Keys: bson.D{{Key: "subscriptionid", Value: 1}, {Key: "createdat", Value: -1}}},
+		{
+			Keys: bson.D{{Key: "createdat", Value: 1}},
+			Options: options.Index().
+				SetName("createdat_ttl").
+				SetExpireAfterSeconds(int32(webhookDeliveryRetention.Seconds())).
+				SetPartialFilterExpression(bson.M{"endedat": bson.M{"$exists": true}}),
+		},
+	}
+}
+
+// FromBson : Transform to BSON
+func (delivery WebhookDelivery) FromBson(sr *mongo.SingleResult) persistence.Persistable {
+	err := sr.Decode(&delivery)
+	if err != nil {
+		logging.Logger.Error(fmt.Sprintf("Cannot unmarshal webhook delivery : %s", err.Error()))
+	}
+	return delivery
+}
@@ -0,0 +1,372 @@
+package model
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gitlab.kardinal.ai/coretech/esther/logging"
+	"gitlab.kardinal.ai/coretech/esther/persistence"
+	"gitlab.kardinal.ai/coretech/esther/transport"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// webhookClaimLease is how long a claimed delivery is hidden from the other instances, it outlasts the attempt
+const webhookClaimLease = time.Minute
+
+// ErrNoSubscription is returned when a subscription does not exist
+var ErrNoSubscription = errors.New("no subscription")
+
+// CreateSubscription Create a subscription, a secret is generated when none is given
+func CreateSubscription(ctx context.Context, scope Scope, subscription Subscription) (Subscription, error) {
+	if err := subscription.Validate(); err != nil {
+		return Subscription{}, err
+	}
+	if subscription.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return Subscription{}, fmt.Errorf("Can't generate the secret of the subscription: %s", err)
+		}
+		subscription.Secret = hex.EncodeToString(secret)
+	}
+	if subscription.PlanIDs == nil {
+		subscription.PlanIDs = []string{}
+	}
+	subscription.Actor = scope.Actor
+	subscription.CreatedAt = time.Now().UTC()
+	id := persistence.InsertOne(ctx, scope.TenantID, subscription)
+	if id == "" {
+		return Subscription{}, fmt.Errorf("Can't create the subscription to %s", subscription.URL)
+	}
+	subscription.ID = id
+	return subscription, nil
+}
+
+// FindSubscriptions Find all the subscriptions of a tenant, without their secret
+func FindSubscriptions(ctx context.Context, scope Scope) ([]Subscription, error) {
+	collection := persistence.GetCollection(scope.TenantID, Subscription{})
+	ctx, cancel := persistence.GetContext(ctx)
+	defer cancel()
+	cur, err := collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("Can't find the subscriptions of tenant %s: %s", scope.TenantID, err)
+	}
+	subscriptions := make([]Subscription, 0)
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var subscription Subscription
+		if err := cur.Decode(&subscription); err != nil {
+			logging.FromContext(ctx).Error(err)
+			continue
+		}
+		subscription.Secret = ""
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, nil
+}
+
+// FindSubscription Find one subscription, without its secret
+func FindSubscription(ctx context.Context, scope Scope, subscriptionID string) (Subscription, error) {
+	subscription, err := findSubscription(ctx, scope.TenantID, subscriptionID)
+	subscription.Secret = ""
+	return subscription, err
+}
+
+// DeleteSubscription Delete one subscription, its pending deliveries fail
+func DeleteSubscription(ctx context.Context, scope Scope, subscriptionID string) error {
+	id, err := primitive.ObjectIDFromHex(subscriptionID)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrNoSubscription, subscriptionID)
+	}
+	collection := persistence.GetCollection(scope.TenantID, Subscription{})
+	ctx, cancel := persistence.GetContext(ctx)
+	defer cancel()
+	dr, err := collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return fmt.Errorf("Can't delete the subscription %s: %s", subscriptionID, err)
+	}
+	if dr.DeletedCount == 0 {
+		return fmt.Errorf("%w: %s", ErrNoSubscription, subscriptionID)
+	}
+	return nil
+}
+
+// FindWebhookDeliveries Find the deliveries of a subscription, the latest first
+func FindWebhookDeliveries(ctx context.Context, scope Scope, subscriptionID string) ([]WebhookDelivery, error) {
+	collection := persistence.GetCollection(scope.TenantID, WebhookDelivery{})
+	ctx, cancel := persistence.GetContext(ctx)
+	defer cancel()
+	cur, err := collection.Find(ctx, bson.M{"subscriptionid": subscriptionID}, options.Find().SetSort(bson.D{{Key: "createdat", Value: -1}}))
+	if err != nil {
+		return nil, fmt.Errorf("Can't find the deliveries of the subscription %s: %s", subscriptionID, err)
+	}
+	deliveries := make([]WebhookDelivery, 0)
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var delivery WebhookDelivery
+		if err := cur.Decode(&delivery); err != nil {
+			logging.FromContext(ctx).Error(err)
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+// RunWebhookDeliveries Deliver the pending notifications of every tenant periodically, until ctx is done
+func RunWebhookDeliveries(ctx context.Context) {
+	logging.Logger.WithFields(logging.LogFields{
+		"interval":    webhookPollInterval.String(),
+		"maxAttempts": webhookMaxAttempts,
+	}).Info("Starting the delivery of webhook notifications")
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := DeliverWebhooks(ctx); err != nil {
+				logging.Logger.WithField("error", err).Error("The delivery of webhook notifications has failed")
+			}
+		}
+	}
+}
+
+// DeliverWebhooks Deliver the pending notifications of every tenant.
+// A notification which could not be delivered is retried with an exponential backoff, until WEBHOOK_MAX_ATTEMPTS.
+func DeliverWebhooks(ctx context.Context) error {
+	tenants, err := persistence.ListTenants(ctx)
+	if err != nil {
+		return err
+	}
+	for _, tenantID := range tenants {
+		for i := 0; i < outboxBatchSize && ctx.Err() == nil; i++ {
+			delivery, found, err := claimWebhookDelivery(ctx, tenantID)
+			if err != nil {
+				return err
+			}
+			if !found {
+				break
+			}
+			deliverWebhook(ctx, tenantID, delivery)
+		}
+	}
+	return nil
+}
+
+// notifySubscriptions queues the delivery of a notification to the matching subscriptions, in the transaction of ctx if any
+func notifySubscriptions(ctx context.Context, scope Scope, notification Notification) error {
+	notification.ID = primitive.NewObjectID().Hex()
+	notification.TenantID = scope.TenantID
+	notification.OccurredAt = time.Now().UTC()
+	collection := persistence.GetCollection(scope.TenantID, Subscription{})
+	findCtx, cancel := persistence.GetContext(ctx)
+	defer cancel()
+	cur, err := collection.Find(findCtx, bson.M{
+		"eventtypes": notification.Type,
+		"$or": bson.A{
+			bson.M{"planids": bson.M{"$size": 0}},
+			bson.M{"planids": notification.PlanID},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("Can't find the subscriptions to %s of tenant %s: %s", notification.Type, scope.TenantID, err)
+	}
+	subscriptions := make([]Subscription, 0)
+	defer cur.Close(findCtx)
+	for cur.Next(findCtx) {
+		var subscription Subscription
+		if err := cur.Decode(&subscription); err != nil {
+			logging.FromContext(ctx).Error(err)
+			continue
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	for _, subscription := range subscriptions {
+		delivery := WebhookDelivery{
+			SubscriptionID: subscription.ID,
+			PlanID:         notification.PlanID,
+			Notification:   notification,
+			Status:         DeliveryPending,
+			Attempts:       []DeliveryAttempt{},
+			NextAttemptAt:  notification.OccurredAt,
+			CreatedAt:      notification.OccurredAt,
+		}
+		if persistence.InsertOne(ctx, scope.TenantID, delivery) == "" {
+			return fmt.Errorf("Can't queue the %s notification of plan %s for the subscription %s", notification.Type, notification.PlanID, subscription.ID)
+		}
+	}
+	return nil
+}
+
+// notifyApplyRunEnd notifies the subscriptions that a run has completed or failed, an interrupted run is not notified
+func notifyApplyRunEnd(ctx context.Context, scope Scope, run ApplyRun) error {
+	notification := Notification{PlanID: run.PlanID, ApplyRun: &run, Error: run.Error}
+	switch run.Status {
+	case ApplyRunCompleted:
+		notification.Type = NotificationApplyCompleted
+	case ApplyRunFailed:
+		notification.Type = NotificationApplyFailed
+	default:
+		return nil
+	}
+	return notifySubscriptions(ctx, scope, notification)
+}
+
+func findSubscription(ctx context.Context, tenantID string, subscriptionID string) (Subscription, error) {
+	id, err := primitive.ObjectIDFromHex(subscriptionID)
+	if err != nil {
+		return Subscription{}, fmt.Errorf("%w: %s", ErrNoSubscription, subscriptionID)
+	}
+	collection := persistence.GetCollection(tenantID, Subscription{})
+	ctx, cancel := persistence.GetContext(ctx)
+	defer cancel()
+	var subscription Subscription
+	err = collection.FindOne(ctx, bson.M{"_id": id}).Decode(&subscription)
+	if err == mongo.ErrNoDocuments {
+		return Subscription{}, fmt.Errorf("%w: %s", ErrNoSubscription, subscriptionID)
+	}
+	if err != nil {
+		return Subscription{}, fmt.Errorf("Can't find the subscription %s: %s", subscriptionID, err)
+	}
+	return subscription, nil
+}
+
+// claimWebhookDelivery takes the oldest pending delivery of a tenant, which is hidden from the other instances until its lease ends
+func claimWebhookDelivery(ctx context.Context, tenantID string) (WebhookDelivery, bool, error) {
+	collection := persistence.GetCollection(tenantID, WebhookDelivery{})
+	ctx, cancel := persistence.GetContext(ctx)
+	defer cancel()
+	now := time.Now().UTC()
+	var delivery WebhookDelivery
+	err := collection.FindOneAndUpdate(ctx,
+		bson.M{"status": DeliveryPending, "nextattemptat": bson.M{"$lte": now}},
+		bson.M{
+			"$set": bson.M{"nextattemptat": now.Add(webhookClaimLease)},
+			"$inc": bson.M{"attemptcount": 1},
+		},
+		options.FindOneAndUpdate().
+			SetSort(bson.D{{Key: "_id", Value: 1}}).
+			SetReturnDocument(options.After)).Decode(&delivery)
+	if err == mongo.ErrNoDocuments {
+		return WebhookDelivery{}, false, nil
+	}
+	if err != nil {
+		return WebhookDelivery{}, false, fmt.Errorf("Can't claim the webhook deliveries of tenant %s: %s", tenantID, err)
+	}
+	return delivery, true, nil
+}
+
+// deliverWebhook makes one attempt to deliver a notification, and records it in the delivery log
+func deliverWebhook(ctx context.Context, tenantID string, delivery WebhookDelivery) {
+	start := time.Now()
+	attempt := DeliveryAttempt{AttemptedAt: start.UTC()}
+	subscription, err := findSubscription(ctx, tenantID, delivery.SubscriptionID)
+	if err == nil {
+		attempt.StatusCode, err = sendNotification(ctx, subscription, delivery)
+	}
+	attempt.Latency = time.Since(start).Seconds()
+
+	set := deliveryOutcome(delivery, err, time.Now().UTC())
+	if err != nil {
+		attempt.Error = err.Error()
+		if errors.Is(err, ErrNoSubscription) {
+			attempt.Error = "The subscription was deleted"
+		}
+		logging.FromContext(ctx).WithFields(logging.LogFields{
+			"tenant":       tenantID,
+			"delivery":     delivery.ID,
+			"subscription": delivery.SubscriptionID,
+			"attempts":     delivery.AttemptCount,
+			"error":        attempt.Error,
+		}).Warn("The webhook notification could not be delivered")
+	}
+
+	id, err := primitive.ObjectIDFromHex(delivery.ID)
+	if err != nil {
+		logging.FromContext(ctx).Error(fmt.Sprintf("Can't retrieve HEX ObjectId : %s", delivery.ID))
+		return
+	}
+	// The attempt is recorded even when the worker is stopping, or the notification is delivered again once its lease ends
+	collection := persistence.GetCollection(tenantID, WebhookDelivery{})
+	updateCtx, cancel := persistence.GetContext(persistence.Detach(ctx))
+	defer cancel()
+	_, err = collection.UpdateOne(updateCtx, bson.M{"_id": id, "status": DeliveryPending}, bson.M{
+		"$set":  set,
+		"$push": bson.M{"attempts": attempt},
+	})
+	if err != nil {
+		logging.FromContext(ctx).WithFields(logging.LogFields{
+			"tenant":   tenantID,
+			"delivery": delivery.ID,
+			"error":    err.Error(),
+		}).Error("The webhook delivery attempt could not be recorded")
+	}
+}
+
+// deliveryOutcome is the change of a delivery once its attempt has ended with err:
+// it is delivered, retried with a backoff, or failed once its subscription is deleted or WEBHOOK_MAX_ATTEMPTS is reached
+func deliveryOutcome(delivery WebhookDelivery, err error, now time.Time) bson.M {
+	switch {
+	case err == nil:
+		return bson.M{"status": DeliveryDelivered, "deliveredat": now, "endedat": now}
+	case errors.Is(err, ErrNoSubscription), delivery.AttemptCount >= webhookMaxAttempts:
+		return bson.M{"status": DeliveryFailed, "endedat": now}
+	default:
+		return bson.M{"nextattemptat": now.Add(retryDelay(delivery.AttemptCount, webhookPollInterval, webhookMaxBackoff))}
+	}
+}
+
+// sendNotification posts a signed notification to the URL of a subscription, which must respond 2xx
+func sendNotification(ctx context.Context, subscription Subscription, delivery WebhookDelivery) (int, error) {
+	body, err := json.Marshal(delivery.Notification)
+	if err != nil {
+		return 0, err
+	}
+	message := transport.Message{
+		Method:  http.MethodPost,
+		URI:     subscription.URL,
+		Headers: http.Header{},
+		Payload: body,
+	}
+	message.Headers.Set("Content-Type", "application/json")
+	message.Headers.Set(IdempotencyKeyHeader, delivery.ID)
+	message.Headers.Set(NotificationTypeHeader, delivery.Notification.Type)
+	message.Headers.Set(SignatureHeader, signNotification(subscription.Secret, time.Now(), body))
+	sender, err := transport.Get(transport.HTTP)
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, actionTimeout)
+	defer cancel()
+	result, err := sender.Send(ctx, message)
+	if err != nil {
+		return 0, err
+	}
+	if result.StatusCode < 200 || result.StatusCode >= 300 {
+		return result.StatusCode, fmt.Errorf("The webhook has responded %s", result.Status)
+	}
+	return result.StatusCode, nil
+}
+
+// signNotification is the signature header of a notification sent at the given time: "t=<unix time>,v1=<signature>",
+// the signature being the hex HMAC-SHA256 of "<unix time>.<body>" with the secret of the subscription
+func signNotification(secret string, sentAt time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(sentAt.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,130 @@
+package model
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSignNotification(t *testing.T) {
+	// The signature of the receivers, e.g. with: printf '1700000000.{"type":"apply.completed"}' | openssl dgst -sha256 -hmac whsec-test
+	signature := signNotification("whsec-test", time.Unix(1700000000, 0), []byte(`{"type":"apply.completed"}`))
+	expected := "t=1700000000,v1=0416639d08e77512c82dbc13354c5cbce279090a26a8919528ee3f8d103a23e7"
+	if signature != expected {
+		t.Errorf("The signature is %s, instead of %s", signature, expected)
+	}
+}
+
+// webhookReceiver records the notifications posted to it, and responds with status
+type webhookReceiver struct {
+	mutex    sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (receiver *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+	receiver.requests = append(receiver.requests, r)
+	receiver.bodies = append(receiver.bodies, body)
+	w.WriteHeader(receiver.status)
+}
+
+func testDelivery() WebhookDelivery {
+	return WebhookDelivery{
+		ID:             "delivery-1",
+		SubscriptionID: "subscription-1",
+		PlanID:         "plan-1",
+		Notification:   Notification{ID: "notification-1", Type: NotificationApplyCompleted, PlanID: "plan-1"},
+		Status:         DeliveryPending,
+	}
+}
+
+func TestSendNotificationSignature(t *testing.T) {
+	receiver := &webhookReceiver{status: http.StatusNoContent}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	subscription := Subscription{ID: "subscription-1", URL: server.URL, Secret: "whsec-test"}
+
+	before := time.Now().Unix()
+	if _, err := sendNotification(context.Background(), subscription, testDelivery()); err != nil {
+		t.Fatalf("The notification was not delivered: %s", err)
+	}
+	if len(receiver.requests) != 1 {
+		t.Fatalf("%d notifications were received, instead of 1", len(receiver.requests))
+	}
+	header := receiver.requests[0].Header.Get(SignatureHeader)
+	if !strings.HasPrefix(header, "t=") || !strings.Contains(header, ",v1=") {
+		t.Fatalf("The signature header %q is not t=<unix time>,v1=<signature>", header)
+	}
+	timestamp, err := strconv.ParseInt(strings.TrimPrefix(strings.Split(header, ",")[0], "t="), 10, 64)
+	if err != nil || timestamp < before || timestamp > time.Now().Unix() {
+		t.Fatalf("The signature header %q does not hold the time the notification was sent", header)
+	}
+	if expected := signNotification(subscription.Secret, time.Unix(timestamp, 0), receiver.bodies[0]); header != expected {
+		t.Errorf("The signature header is %s, instead of %s", header, expected)
+	}
+	if key := receiver.requests[0].Header.Get(IdempotencyKeyHeader); key != "delivery-1" {
+		t.Errorf("The idempotency key is %s, instead of the id of the delivery", key)
+	}
+}
+
+func TestDeliveryRetriedUntilMaxAttempts(t *testing.T) {
+	defer func(maxAttempts int) { webhookMaxAttempts = maxAttempts }(webhookMaxAttempts)
+	webhookMaxAttempts = 3
+	receiver := &webhookReceiver{status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	subscription := Subscription{ID: "subscription-1", URL: server.URL, Secret: "whsec-test"}
+
+	// The attempts of the worker: the delivery is claimed, which counts the attempt, and sent
+	delivery := testDelivery()
+	now := time.Now().UTC()
+	for delivery.Status == DeliveryPending {
+		if delivery.AttemptCount > webhookMaxAttempts {
+			t.Fatalf("The delivery is still retried after %d attempts", delivery.AttemptCount)
+		}
+		delivery.AttemptCount++
+		_, err := sendNotification(context.Background(), subscription, delivery)
+		if err == nil {
+			t.Fatal("The notification was delivered to a webhook responding 503")
+		}
+		set := deliveryOutcome(delivery, err, now)
+		if status, ok := set["status"].(string); ok {
+			delivery.Status = status
+		} else if next, _ := set["nextattemptat"].(time.Time); !next.After(now) {
+			t.Errorf("The attempt %d is retried at %s, not after it has failed", delivery.AttemptCount, next)
+		}
+	}
+	if delivery.Status != DeliveryFailed {
+		t.Errorf("The delivery is %s, instead of %s", delivery.Status, DeliveryFailed)
+	}
+	if len(receiver.requests) != webhookMaxAttempts {
+		t.Errorf("The notification was sent %d times, instead of %d", len(receiver.requests), webhookMaxAttempts)
+	}
+	for _, request := range receiver.requests {
+		if key := request.Header.Get(IdempotencyKeyHeader); key != delivery.ID {
+			t.Errorf("The idempotency key of an attempt is %s, instead of the id of the delivery", key)
+		}
+	}
+}
+
+func TestDeliveryOutcome(t *testing.T) {
+	now := time.Now().UTC()
+	delivery := testDelivery()
+	delivery.AttemptCount = 1
+	if set := deliveryOutcome(delivery, nil, now); set["status"] != DeliveryDelivered || set["endedat"] != now {
+		t.Errorf("A delivered notification is recorded as %v", set)
+	}
+	if set := deliveryOutcome(delivery, ErrNoSubscription, now); set["status"] != DeliveryFailed {
+		t.Errorf("The notification of a deleted subscription is recorded as %v", set)
+	}
+}
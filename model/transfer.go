@@ -0,0 +1,67 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Policies of a transfer when an event callback modifies a resource already modified by an event callback pending in the target plan
+const (
+	// ConflictFail transfers nothing, it is the default
+	ConflictFail = "fail"
+	// ConflictSkip leaves the conflicting event callbacks, and the ones depending on them, out of the transfer
+	ConflictSkip = "skip"
+	// ConflictAllow transfers the conflicting event callbacks, which are applied after the ones of the target plan
+	ConflictAllow = "allow"
+)
+
+// ErrResourceConflict is returned when event callbacks of a transfer modify resources already modified in the target plan
+var ErrResourceConflict = errors.New("resource conflict")
+
+// TransferRequest represents the event callbacks of a plan to copy or to move to another plan
+type TransferRequest struct {
+	TargetPlanID     string   `json:"targetPlanId" binding:"required"`
+	EventCallbackIDs []string `json:"eventCallbackIds" binding:"required"`
+	OnConflict       string   `json:"onConflict"`
+}
+
+// MergeRequest represents a plan whose event callbacks are merged into another plan
+type MergeRequest struct {
+	SourcePlanID string `json:"sourcePlanId" binding:"required"`
+	OnConflict   string `json:"onConflict"`
+	// DeleteSource removes the event callbacks merged from the source plan
+	DeleteSource bool `json:"deleteSource"`
+}
+
+// Conflict represents an event callback transferred to a plan where an event callback of the same resource is pending
+type Conflict struct {
+	ResourceKey           string `json:"resourceKey"`
+	EventCallbackID       string `json:"eventCallbackId"`
+	TargetEventCallbackID string `json:"targetEventCallbackId"`
+}
+
+// TransferResult is the outcome of a copy, a move or a merge
+type TransferResult struct {
+	SourcePlanID string `json:"sourcePlanId"`
+	TargetPlanID string `json:"targetPlanId"`
+	// IDMap maps the ids of the event callbacks transferred to the ids of the event callbacks created in the target plan
+	IDMap          map[string]string `json:"idMap"`
+	EventCallbacks []EventCallback   `json:"eventCallbacks"`
+	Conflicts      []Conflict        `json:"conflicts"`
+	// Skipped are the event callbacks left out of the transfer because of a conflict
+	Skipped []string `json:"skipped"`
+}
+
+// ResourceConflictError is returned when a transfer fails on conflicts, with the conflicts
+type ResourceConflictError struct {
+	Conflicts []Conflict
+}
+
+func (err *ResourceConflictError) Error() string {
+	return fmt.Sprintf("%s: %d event-callbacks modify resources already modified in the target plan, the first one is %s on %s", ErrResourceConflict, len(err.Conflicts), err.Conflicts[0].EventCallbackID, err.Conflicts[0].ResourceKey)
+}
+
+// Is : Match ErrResourceConflict
+func (err *ResourceConflictError) Is(target error) bool {
+	return target == ErrResourceConflict
+}
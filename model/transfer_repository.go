@@ -0,0 +1,199 @@
+package model
+
+import (
+	"context"
+	"fmt"
+
+	"gitlab.kardinal.ai/coretech/esther/logging"
+	"gitlab.kardinal.ai/coretech/esther/persistence"
+)
+
+// CopyEventCallbacks Copy events of a plan to another plan, where they are created after its own events in their order in the plan
+func CopyEventCallbacks(ctx context.Context, scope Scope, planID string, request TransferRequest) (TransferResult, error) {
+	if len(request.EventCallbackIDs) == 0 {
+		return TransferResult{}, fmt.Errorf("No event-callback of plan %s to copy", planID)
+	}
+	return transferEventCallbacks(ctx, scope, planID, request.TargetPlanID, request.EventCallbackIDs, request.OnConflict, false)
+}
+
+// MoveEventCallbacks Move events of a plan to another plan like CopyEventCallbacks, and remove them from their plan
+func MoveEventCallbacks(ctx context.Context, scope Scope, planID string, request TransferRequest) (TransferResult, error) {
+	if len(request.EventCallbackIDs) == 0 {
+		return TransferResult{}, fmt.Errorf("No event-callback of plan %s to move", planID)
+	}
+	return transferEventCallbacks(ctx, scope, planID, request.TargetPlanID, request.EventCallbackIDs, request.OnConflict, true)
+}
+
+// MergePlans Copy all the events of the source plan of the request to a plan, and remove them from the source plan if asked.
+// The events of the source plan are not interleaved with the ones of the plan by their creation: they follow all the events of the plan.
+func MergePlans(ctx context.Context, scope Scope, planID string, request MergeRequest) (TransferResult, error) {
+	return transferEventCallbacks(ctx, scope, request.SourcePlanID, planID, nil, request.OnConflict, request.DeleteSource)
+}
+
+// transferEventCallbacks creates events of the source plan in the target plan, all of them when eventIDs is nil, in a single transaction.
+// The dependencies between the events transferred are changed to their new ids; an event cannot be transferred without the pending events it depends on,
+// and when the events are removed from the source plan, none of the events left there can depend on them.
+// Neither plan can be locked, the source plan is read in the transaction and its events must not be applied meanwhile.
+func transferEventCallbacks(ctx context.Context, scope Scope, sourcePlanID string, targetPlanID string, eventIDs []string, onConflict string, remove bool) (TransferResult, error) {
+	if sourcePlanID == targetPlanID {
+		return TransferResult{}, fmt.Errorf("The event-callbacks of plan %s cannot be transferred to the same plan", sourcePlanID)
+	}
+	switch onConflict {
+	case "":
+		onConflict = ConflictFail
+	case ConflictFail, ConflictSkip, ConflictAllow:
+	default:
+		return TransferResult{}, fmt.Errorf("Invalid conflict policy %s, it must be %s, %s or %s", onConflict, ConflictFail, ConflictSkip, ConflictAllow)
+	}
+
+	for _, planID := range []string{sourcePlanID, targetPlanID} {
+		if err := CheckPlanUnlocked(ctx, scope, planID); err != nil {
+			return TransferResult{}, err
+		}
+	}
+
+	var result TransferResult
+	err := persistence.WithTransaction(ctx, func(ctx context.Context) error {
+		// The transaction is retried as a whole
+		if err := guardPlanUnlocked(ctx, scope, sourcePlanID); err != nil {
+			return err
+		}
+		result = TransferResult{
+			SourcePlanID:   sourcePlanID,
+			TargetPlanID:   targetPlanID,
+			IDMap:          map[string]string{},
+			EventCallbacks: []EventCallback{},
+			Conflicts:      []Conflict{},
+			Skipped:        []string{},
+		}
+		sourceEventCallbacks, err := FindEventCallbacksByPlanId(ctx, scope, sourcePlanID)
+		if err != nil {
+			return err
+		}
+		selected, err := selectEventCallbacks(sourceEventCallbacks, sourcePlanID, eventIDs)
+		if err != nil {
+			return err
+		}
+		targetEventCallbacks, err := FindEventCallbacksByPlanId(ctx, scope, targetPlanID)
+		if err != nil {
+			return err
+		}
+
+		// An event conflicts with the last pending event of the same resource in the target plan
+		targetResources := map[string]string{}
+		for _, eventCallback := range targetEventCallbacks {
+			targetResources[eventCallback.resourceKey()] = eventCallback.ID
+		}
+		pending := map[string]bool{}
+		for _, eventCallback := range sourceEventCallbacks {
+			pending[eventCallback.ID] = true
+		}
+		transferred := make([]EventCallback, 0, len(selected))
+		included := map[string]bool{}
+		skipped := map[string]bool{}
+		for _, eventCallback := range selected {
+			skip := false
+			if targetID, ok := targetResources[eventCallback.resourceKey()]; ok {
+				result.Conflicts = append(result.Conflicts, Conflict{ResourceKey: eventCallback.resourceKey(), EventCallbackID: eventCallback.ID, TargetEventCallbackID: targetID})
+				skip = onConflict == ConflictSkip
+			}
+			// The events depending on a skipped event are skipped with it
+			for _, dependency := range eventCallback.DependsOn {
+				if skipped[dependency] {
+					skip = true
+				}
+			}
+			if skip {
+				skipped[eventCallback.ID] = true
+				result.Skipped = append(result.Skipped, eventCallback.ID)
+				continue
+			}
+			included[eventCallback.ID] = true
+			transferred = append(transferred, eventCallback)
+		}
+		if onConflict == ConflictFail && len(result.Conflicts) > 0 {
+			return &ResourceConflictError{Conflicts: result.Conflicts}
+		}
+		if remove {
+			for _, eventCallback := range sourceEventCallbacks {
+				if included[eventCallback.ID] {
+					continue
+				}
+				for _, dependency := range eventCallback.DependsOn {
+					if included[dependency] {
+						return fmt.Errorf("The event-callback %s cannot be moved, the event-callback %s left in plan %s depends on it", dependency, eventCallback.ID, sourcePlanID)
+					}
+				}
+			}
+		}
+
+		for _, eventCallback := range transferred {
+			sourceID := eventCallback.ID
+			eventCallback.ID = ""
+			dependsOn := make([]string, 0, len(eventCallback.DependsOn))
+			for _, dependency := range eventCallback.DependsOn {
+				switch {
+				case result.IDMap[dependency] != "":
+					dependsOn = append(dependsOn, result.IDMap[dependency])
+				case pending[dependency]:
+					return fmt.Errorf("The event-callback %s depends on the event-callback %s, which is not transferred", sourceID, dependency)
+				}
+				// The dependencies which are not pending any more are fulfilled
+			}
+			eventCallback.DependsOn = nil
+			if len(dependsOn) > 0 {
+				eventCallback.DependsOn = dependsOn
+			}
+			created, err := CreateEventCallback(ctx, scope, targetPlanID, eventCallback)
+			if err != nil {
+				return fmt.Errorf("The event-callback %s could not be transferred to plan %s: %w", sourceID, targetPlanID, err)
+			}
+			result.IDMap[sourceID] = created.ID
+			result.EventCallbacks = append(result.EventCallbacks, created)
+		}
+		if remove {
+			for _, eventCallback := range transferred {
+				if err := DeleteEventCallbacksById(ctx, scope, sourcePlanID, eventCallback.ID); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return TransferResult{}, err
+	}
+	logging.FromContext(ctx).WithFields(logging.LogFields{
+		"planId":       sourcePlanID,
+		"targetPlanId": targetPlanID,
+		"transferred":  len(result.EventCallbacks),
+		"conflicts":    len(result.Conflicts),
+		"skipped":      len(result.Skipped),
+		"removed":      remove,
+	}).Info("Event-callbacks transferred")
+	return result, nil
+}
+
+// selectEventCallbacks returns the events of a plan with the given ids in their order in the plan, or all of them when ids is nil
+func selectEventCallbacks(eventCallbacks []EventCallback, planID string, ids []string) ([]EventCallback, error) {
+	if ids == nil {
+		return eventCallbacks, nil
+	}
+	wanted := map[string]bool{}
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	selected := make([]EventCallback, 0, len(wanted))
+	for _, eventCallback := range eventCallbacks {
+		if wanted[eventCallback.ID] {
+			selected = append(selected, eventCallback)
+			delete(wanted, eventCallback.ID)
+		}
+	}
+	for _, id := range ids {
+		if wanted[id] {
+			return nil, fmt.Errorf("The event-callback %s is not pending in plan %s", id, planID)
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,211 @@
+package outbound
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"gitlab.kardinal.ai/coretech/esther/logging"
+	"gitlab.kardinal.ai/coretech/esther/metrics"
+)
+
+const (
+	defaultCircuitFailureThreshold = 5
+	defaultCircuitOpenDuration     = 30 * time.Second
+)
+
+// ErrCircuitOpen is returned when the calls to a host are suspended after too many consecutive failures
+var ErrCircuitOpen = errors.New("circuit open")
+
+var (
+	hostRate                rate.Limit
+	hostBurst               int
+	circuitFailureThreshold int
+	circuitOpenDuration     time.Duration
+
+	hostsMutex sync.Mutex
+	hosts      = map[string]*host{}
+)
+
+// host holds the rate limiter and the circuit breaker of a target host
+type host struct {
+	limiter *rate.Limiter
+
+	mutex     sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func init() {
+	initEnv()
+}
+
+func initEnv() []string {
+	errs := []string{}
+
+	hostRate = rate.Inf
+	envRate := "OUTBOUND_RATE"
+	if value := os.Getenv(envRate); value != "" {
+		limit, err := strconv.ParseFloat(value, 64)
+		if err != nil || limit < 0 {
+			errs = append(errs, fmt.Sprintf("%s must be a positive number of calls per second", envRate))
+		} else if limit > 0 {
+			hostRate = rate.Limit(limit)
+		}
+	}
+
+	hostBurst = 1
+	envBurst := "OUTBOUND_BURST"
+	if value := os.Getenv(envBurst); value != "" {
+		burst, err := strconv.Atoi(value)
+		if err != nil || burst < 1 {
+			errs = append(errs, fmt.Sprintf("%s must be a strictly positive integer", envBurst))
+		} else {
+			hostBurst = burst
+		}
+	}
+
+	circuitFailureThreshold = defaultCircuitFailureThreshold
+	envThreshold := "CIRCUIT_FAILURE_THRESHOLD"
+	if value := os.Getenv(envThreshold); value != "" {
+		threshold, err := strconv.Atoi(value)
+		if err != nil || threshold < 0 {
+			errs = append(errs, fmt.Sprintf("%s must be a positive integer", envThreshold))
+		} else {
+			circuitFailureThreshold = threshold
+		}
+	}
+
+	circuitOpenDuration = defaultCircuitOpenDuration
+	envOpenDuration := "CIRCUIT_OPEN_DURATION"
+	if value := os.Getenv(envOpenDuration); value != "" {
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration <= 0 {
+			errs = append(errs, fmt.Sprintf("%s must be a positive duration", envOpenDuration))
+		} else {
+			circuitOpenDuration = duration
+		}
+	}
+
+	logging.Logger.WithFields(logging.LogFields{
+		"rate":             float64(hostRate),
+		"burst":            hostBurst,
+		"failureThreshold": circuitFailureThreshold,
+		"openDuration":     circuitOpenDuration.String(),
+	}).Info("The outbound environment has been set")
+	if len(errs) > 0 {
+		logging.Logger.WithField("errors", errs).Error("The outbound environment is not properly set")
+	}
+	return errs
+}
+
+func getHost(name string) *host {
+	hostsMutex.Lock()
+	defer hostsMutex.Unlock()
+	h, ok := hosts[name]
+	if !ok {
+		h = &host{limiter: rate.NewLimiter(hostRate, hostBurst)}
+		hosts[name] = h
+	}
+	return h
+}
+
+// Call is a call to a host allowed by Wait, whose outcome is given to Report
+type Call struct {
+	hostName string
+	host     *host
+	probe    bool
+}
+
+// Wait waits until a call to a host is allowed by its rate limit.
+// ErrCircuitOpen is returned when the calls to the host are suspended.
+func Wait(ctx context.Context, hostName string) (Call, error) {
+	h := getHost(hostName)
+	probe, err := h.allow()
+	if err != nil {
+		metrics.ObserveOutboundRejection(hostName, "circuit_open")
+		return Call{}, fmt.Errorf("%w for host %s", err, hostName)
+	}
+	if err := h.limiter.Wait(ctx); err != nil {
+		h.release(probe)
+		return Call{}, err
+	}
+	return Call{hostName: hostName, host: h, probe: probe}, nil
+}
+
+// Report records the outcome of the call.
+// The transport errors, 429 and 5xx responses are failures, which open the circuit of the host once they are consecutive.
+func (call Call) Report(statusCode int, err error) {
+	if call.host == nil {
+		return
+	}
+	if errors.Is(err, context.Canceled) {
+		// The caller has gone, nothing is known about the host
+		call.host.release(call.probe)
+		return
+	}
+	failed := err != nil && (statusCode == 0 || statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError)
+	call.host.report(call.hostName, call.probe, failed)
+}
+
+// allow checks the circuit: once open, it lets a single probe call through after CIRCUIT_OPEN_DURATION, and tells whether this call is the probe
+func (h *host) allow() (bool, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.openUntil.IsZero() {
+		return false, nil
+	}
+	if h.probing || time.Now().Before(h.openUntil) {
+		return false, ErrCircuitOpen
+	}
+	h.probing = true
+	return true, nil
+}
+
+// release gives back the probe of a call which was not made, the calls which did not take the probe have nothing to give back
+func (h *host) release(probe bool) {
+	if !probe {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.probing = false
+}
+
+func (h *host) report(hostName string, probe bool, failed bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	wasOpen := !h.openUntil.IsZero()
+	if probe {
+		h.probing = false
+	}
+	if !failed {
+		h.failures = 0
+		h.openUntil = time.Time{}
+		if wasOpen {
+			logging.Logger.WithField("host", hostName).Info("Circuit closed")
+			metrics.ObserveCircuit(hostName, false)
+		}
+		return
+	}
+	h.failures++
+	if circuitFailureThreshold > 0 && (wasOpen || h.failures >= circuitFailureThreshold) {
+		h.openUntil = time.Now().Add(circuitOpenDuration)
+		if !wasOpen {
+			logging.Logger.WithFields(logging.LogFields{
+				"host":     hostName,
+				"failures": h.failures,
+				"duration": circuitOpenDuration.String(),
+			}).Warn("Circuit opened")
+			metrics.ObserveCircuit(hostName, true)
+		}
+	}
+}
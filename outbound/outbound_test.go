@@ -0,0 +1,142 @@
+package outbound
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+const testOpenDuration = 20 * time.Millisecond
+
+func TestMain(m *testing.M) {
+	circuitFailureThreshold, circuitOpenDuration = 3, testOpenDuration
+	os.Exit(m.Run())
+}
+
+// testHost returns a host of its own to each test
+func testHost(t *testing.T) string {
+	return fmt.Sprintf("%s-%d.example.com", t.Name(), time.Now().UnixNano())
+}
+
+// call makes a call to the host which ends with the given outcome, and returns the error of Wait
+func call(hostName string, statusCode int, err error) error {
+	c, waitErr := Wait(context.Background(), hostName)
+	if waitErr != nil {
+		return waitErr
+	}
+	c.Report(statusCode, err)
+	return nil
+}
+
+// openCircuit fails the calls to the host until its circuit opens, and waits until a probe is let through
+func openCircuit(t *testing.T, hostName string) {
+	for i := 0; i < circuitFailureThreshold; i++ {
+		if err := call(hostName, http.StatusBadGateway, errors.New("bad gateway")); err != nil {
+			t.Fatalf("The call %d was rejected: %s", i+1, err)
+		}
+	}
+	if err := call(hostName, http.StatusOK, nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("The circuit is not open: %v", err)
+	}
+	time.Sleep(2 * testOpenDuration)
+}
+
+func TestCircuitOpensAtThreshold(t *testing.T) {
+	hostName := testHost(t)
+	for i := 0; i < circuitFailureThreshold-1; i++ {
+		if err := call(hostName, 0, errors.New("connection refused")); err != nil {
+			t.Fatalf("The call %d was rejected: %s", i+1, err)
+		}
+	}
+	// A success resets the count, and the responses which are not failures do not count
+	if err := call(hostName, http.StatusBadRequest, errors.New("bad request")); err != nil {
+		t.Fatal(err)
+	}
+	if err := call(hostName, http.StatusOK, nil); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < circuitFailureThreshold-1; i++ {
+		if err := call(hostName, http.StatusTooManyRequests, errors.New("too many requests")); err != nil {
+			t.Fatalf("The call %d was rejected: %s", i+1, err)
+		}
+	}
+	if err := call(hostName, http.StatusServiceUnavailable, errors.New("unavailable")); err != nil {
+		t.Fatalf("The call which reaches the threshold was rejected: %s", err)
+	}
+	if err := call(hostName, http.StatusOK, nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("The circuit is not open after %d failures: %v", circuitFailureThreshold, err)
+	}
+}
+
+func TestCircuitSingleProbe(t *testing.T) {
+	hostName := testHost(t)
+	openCircuit(t, hostName)
+	probe, err := Wait(context.Background(), hostName)
+	if err != nil {
+		t.Fatalf("The probe was rejected: %s", err)
+	}
+	if _, err := Wait(context.Background(), hostName); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("A second call was let through during the probe: %v", err)
+	}
+	probe.Report(http.StatusOK, nil)
+}
+
+func TestCircuitReopensWhenProbeFails(t *testing.T) {
+	hostName := testHost(t)
+	openCircuit(t, hostName)
+	if err := call(hostName, http.StatusInternalServerError, errors.New("internal error")); err != nil {
+		t.Fatalf("The probe was rejected: %s", err)
+	}
+	if err := call(hostName, http.StatusOK, nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("The circuit is not open again after the probe has failed: %v", err)
+	}
+	time.Sleep(2 * testOpenDuration)
+	if err := call(hostName, http.StatusOK, nil); err != nil {
+		t.Errorf("No probe was let through once the circuit was open again: %s", err)
+	}
+}
+
+func TestCircuitClosesWhenProbeSucceeds(t *testing.T) {
+	hostName := testHost(t)
+	openCircuit(t, hostName)
+	if err := call(hostName, http.StatusOK, nil); err != nil {
+		t.Fatalf("The probe was rejected: %s", err)
+	}
+	for i := 0; i < circuitFailureThreshold; i++ {
+		if err := call(hostName, http.StatusOK, nil); err != nil {
+			t.Errorf("The call %d was rejected once the circuit was closed: %s", i+1, err)
+		}
+	}
+}
+
+func TestReportCanceled(t *testing.T) {
+	hostName := testHost(t)
+	// A call started before the circuit opened, which ends during the probe
+	late, err := Wait(context.Background(), hostName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	openCircuit(t, hostName)
+	probe, err := Wait(context.Background(), hostName)
+	if err != nil {
+		t.Fatalf("The probe was rejected: %s", err)
+	}
+
+	late.Report(0, context.Canceled)
+	if _, err := Wait(context.Background(), hostName); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("The probe was given back by another call: %v", err)
+	}
+
+	// The caller of the probe has gone, another call can probe the host
+	probe.Report(0, fmt.Errorf("The action was canceled: %w", context.Canceled))
+	if err := call(hostName, http.StatusOK, nil); err != nil {
+		t.Errorf("The probe was not given back: %s", err)
+	}
+	if err := call(hostName, http.StatusOK, nil); err != nil {
+		t.Errorf("The circuit is not closed after the probe has succeeded: %s", err)
+	}
+}
@@ -2,35 +2,66 @@ package persistence
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/label"
 
 	"gitlab.kardinal.ai/coretech/esther/logging"
+	"gitlab.kardinal.ai/coretech/esther/metrics"
+	"gitlab.kardinal.ai/coretech/esther/tracing"
 )
 
 const (
-	contextTimeout = 10 * time.Second
-	mongoDbName    = "esther"
+	defaultMongoDbTimeout = 10 * time.Second
+	defaultMongoDbName    = "esther"
+	tenantSeparator       = "_"
+	isolationByDatabase   = "database"
+	isolationByCollection = "collection"
+	duplicateKeyCode      = 11000
+
+	// tenantsCollection is the registry of the tenants, in the database of the default tenant
+	tenantsCollection = "tenants"
+
+	indexOptionsConflictCode  = 85
+	indexKeySpecsConflictCode = 86
 )
 
 var (
-	mongoDbHost   string
-	mongoDbPort   string
-	mongoDbURI    string
-	mongoDbClient *mongo.Client
+	mongoDbHost     string
+	mongoDbPort     string
+	mongoDbURI      string
+	mongoDbName     string
+	mongoDbTimeout  time.Duration
+	tenantIsolation string
+	mongoDbClient   *mongo.Client
+	ensuredIndexes  sync.Map
+	// registeredTenants are the tenants this instance has recorded in the registry
+	registeredTenants sync.Map
+	commandSpans      sync.Map
+
+	transactionsMutex     sync.Mutex
+	transactionsSupported *bool
+
+	// entities are the persisted entities, registered by the packages which define them
+	entities []Persistable
 )
 
 // ReadyCheck checks if the package is ready to work
-func ReadyCheck() []string {
+func ReadyCheck(ctx context.Context) []string {
 	checkErrors := []string{}
-	if err := checkConnection(); err != nil {
+	if err := checkConnection(ctx); err != nil {
 		checkErrors = append(checkErrors, fmt.Sprintf("Not connected to the database: %s", err))
 	}
 	if len(checkErrors) > 0 {
@@ -39,30 +70,84 @@ func ReadyCheck() []string {
 	return nil
 }
 
-// Reset will reload the package and clean the data
-func Reset() []string {
+// Reset will reload the package and clean the data of every tenant
+func Reset(ctx context.Context) []string {
 	errors := initEnv()
 	if len(errors) == 0 {
 		errors = initConnection()
 	}
 	if len(errors) == 0 {
-		ctx, cancel := GetContext()
-		defer cancel()
-		if err := mongoDbClient.Database(mongoDbName).Drop(ctx); err != nil {
+		// Only the databases of the registered tenants are dropped, another deployment may share the prefix of their names
+		names := []string{mongoDbName}
+		tenants, err := ListTenants(ctx)
+		if err != nil {
 			errors = append(errors, err.Error())
-		} else {
-			logging.Logger.WithFields(logging.LogFields{
-				"uri":  mongoDbURI,
-				"name": mongoDbName,
-			}).Info("The database has been reset")
+		} else if tenantIsolation == isolationByDatabase {
+			for _, tenantID := range tenants[1:] {
+				names = append(names, Database(tenantID).Name())
+			}
+		}
+		ctx, cancel := GetContext(ctx)
+		defer cancel()
+		for _, name := range names {
+			if err := mongoDbClient.Database(name).Drop(ctx); err != nil {
+				errors = append(errors, err.Error())
+			} else {
+				logging.Logger.WithFields(logging.LogFields{
+					"uri":  mongoDbURI,
+					"name": name,
+				}).Info("The database has been reset")
+			}
 		}
 	}
+	forgetIndexes()
+	forgetTenants()
+	transactionsMutex.Lock()
+	transactionsSupported = nil
+	transactionsMutex.Unlock()
 	if len(errors) > 0 {
 		logging.Logger.WithField("errors", errors).Error("The reset has failed")
 	}
 	return errors
 }
 
+// ResetTenant cleans the data of a single tenant
+func ResetTenant(ctx context.Context, tenantID string) []string {
+	errors := []string{}
+	if err := checkConnection(ctx); err != nil {
+		errors = append(errors, err.Error())
+	} else {
+		ctx, cancel := GetContext(ctx)
+		defer cancel()
+		if tenantIsolation == isolationByCollection {
+			// Only the collections of the tenant are dropped, the ones of the default tenant may share their prefix
+			for _, p := range entities {
+				if err := collectionOf(tenantID, p).Drop(ctx); err != nil {
+					errors = append(errors, err.Error())
+				}
+			}
+		} else if err := Database(tenantID).Drop(ctx); err != nil {
+			errors = append(errors, err.Error())
+		}
+		if len(errors) == 0 {
+			if _, err := Database("").Collection(tenantsCollection).DeleteOne(ctx, bson.M{"_id": tenantID}); err != nil {
+				errors = append(errors, err.Error())
+			}
+		}
+	}
+	registeredTenants.Delete(tenantID)
+	forgetIndexes()
+	if len(errors) > 0 {
+		logging.Logger.WithFields(logging.LogFields{
+			"tenant": tenantID,
+			"errors": errors,
+		}).Error("The tenant reset has failed")
+	} else {
+		logging.Logger.WithField("tenant", tenantID).Info("The tenant has been reset")
+	}
+	return errors
+}
+
 func init() {
 	if errors := initEnv(); len(errors) == 0 {
 		initConnection()
@@ -87,6 +172,26 @@ func initEnv() []string {
 			}).Info("The database connection URI has been set")
 		}
 	}
+	mongoDbName = os.Getenv("MONGODB_DATABASE_NAME")
+	if mongoDbName == "" {
+		mongoDbName = defaultMongoDbName
+	}
+	envDbTimeout := "MONGODB_TIMEOUT"
+	mongoDbTimeout = defaultMongoDbTimeout
+	if value := os.Getenv(envDbTimeout); value != "" {
+		if timeout, err := time.ParseDuration(value); err != nil || timeout <= 0 {
+			errors = append(errors, fmt.Sprintf("%s must be a positive duration", envDbTimeout))
+		} else {
+			mongoDbTimeout = timeout
+		}
+	}
+	envTenantIsolation := "TENANT_ISOLATION"
+	tenantIsolation = os.Getenv(envTenantIsolation)
+	if tenantIsolation == "" {
+		tenantIsolation = isolationByDatabase
+	} else if tenantIsolation != isolationByDatabase && tenantIsolation != isolationByCollection {
+		errors = append(errors, fmt.Sprintf("%s must be either %s or %s", envTenantIsolation, isolationByDatabase, isolationByCollection))
+	}
 	if len(errors) > 0 {
 		logging.Logger.WithField("errors", errors).Error("The persistence environment is not properly set")
 	}
@@ -96,9 +201,9 @@ func initEnv() []string {
 func initConnection() []string {
 	errors := []string{}
 	var err error
-	ctx, cancel := GetContext()
+	ctx, cancel := GetContext(context.Background())
 	defer cancel()
-	mongoDbClient, err = mongo.Connect(ctx, options.Client().ApplyURI(mongoDbURI))
+	mongoDbClient, err = mongo.Connect(ctx, options.Client().ApplyURI(mongoDbURI).SetMonitor(commandMonitor()))
 	if err != nil {
 		errors = append(errors, err.Error())
 	}
@@ -116,11 +221,52 @@ func initConnection() []string {
 	return errors
 }
 
-func checkConnection() error {
+// Disconnect closes the connections to the database
+func Disconnect(ctx context.Context) error {
+	if mongoDbClient == nil {
+		return nil
+	}
+	if err := mongoDbClient.Disconnect(ctx); err != nil {
+		return err
+	}
+	logging.Logger.WithField("dbname", mongoDbName).Info("Disconnected from the database")
+	return nil
+}
+
+// commandMonitor measures the latency of every MongoDB command, and traces it as a child of the span of its ctx
+func commandMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			_, span := tracing.Start(ctx, "mongodb."+e.CommandName,
+				label.String("db.system", "mongodb"),
+				label.String("db.name", e.DatabaseName),
+				label.String("db.operation", e.CommandName),
+			)
+			commandSpans.Store(e.RequestID, span)
+		},
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			metrics.ObserveMongoDbCommand(e.CommandName, time.Duration(e.DurationNanos), nil)
+			if span, ok := commandSpans.Load(e.RequestID); ok {
+				commandSpans.Delete(e.RequestID)
+				tracing.End(ctx, span.(trace.Span), nil)
+			}
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			err := errors.New(e.Failure)
+			metrics.ObserveMongoDbCommand(e.CommandName, time.Duration(e.DurationNanos), err)
+			if span, ok := commandSpans.Load(e.RequestID); ok {
+				commandSpans.Delete(e.RequestID)
+				tracing.End(ctx, span.(trace.Span), err)
+			}
+		},
+	}
+}
+
+func checkConnection(ctx context.Context) error {
 	if mongoDbClient == nil {
 		return fmt.Errorf("The database connection seems to be not initialized")
 	}
-	ctx, cancel := GetContext()
+	ctx, cancel := GetContext(ctx)
 	defer cancel()
 	if err := mongoDbClient.Ping(ctx, readpref.Primary()); err != nil {
 		return err
@@ -128,15 +274,381 @@ func checkConnection() error {
 	return nil
 }
 
-// GetContext Retrieve Database ctx
-func GetContext() (context.Context, context.CancelFunc) {
-	ctx, cancel := context.WithTimeout(context.Background(), contextTimeout)
+// GetContext Retrieve Database ctx, which is canceled with the given ctx or once the database timeout is reached
+func GetContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(ctx, mongoDbTimeout)
 	return ctx, cancel
 }
 
-// GetCollection Retrieve Collection
-func GetCollection(p Persistable) *mongo.Collection {
-	return mongoDbClient.Database(mongoDbName).Collection(p.EntityName())
+// Detach returns a ctx which keeps the values of the given ctx (e.g. the current span) but not its deadline nor its cancellation.
+// It is used to record what was done even when the caller is gone.
+func Detach(ctx context.Context) context.Context {
+	return detachedContext{parent: ctx}
+}
+
+// detachedContext exposes the values of its parent without its deadline nor its cancellation
+type detachedContext struct {
+	parent context.Context
+}
+
+func (ctx detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (ctx detachedContext) Done() <-chan struct{}       { return nil }
+func (ctx detachedContext) Err() error                  { return nil }
+func (ctx detachedContext) Value(key interface{}) interface{} {
+	return ctx.parent.Value(key)
+}
+
+// WithTransaction runs fn in a MongoDB transaction, which is retried as a whole on transient errors:
+// fn must only write through the given ctx and must not have other side effects.
+// fn joins the transaction of ctx when it already holds one.
+// A standalone MongoDB does not support transactions, fn is then run without one.
+func WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+	hooks := &commitHooks{}
+	ctx = context.WithValue(ctx, commitHooksKey{}, hooks)
+	if !supportsTransactions(ctx) {
+		if err := fn(ctx); err != nil {
+			return err
+		}
+		hooks.run()
+		return nil
+	}
+	session, err := mongoDbClient.StartSession()
+	if err != nil {
+		return fmt.Errorf("Can't start a database session: %s", err)
+	}
+	defer session.EndSession(context.Background())
+	_, err = session.WithTransaction(ctx, func(sessionCtx mongo.SessionContext) (interface{}, error) {
+		// The hooks of an aborted attempt are dropped
+		hooks.reset()
+		return nil, fn(sessionCtx)
+	})
+	if err != nil {
+		return err
+	}
+	hooks.run()
+	return nil
+}
+
+// AfterCommit runs hook once the transaction of ctx is committed, or right away when ctx holds no transaction
+func AfterCommit(ctx context.Context, hook func()) {
+	if hooks, ok := ctx.Value(commitHooksKey{}).(*commitHooks); ok {
+		hooks.add(hook)
+		return
+	}
+	hook()
+}
+
+type commitHooksKey struct{}
+
+// commitHooks are the functions to run once a transaction is committed
+type commitHooks struct {
+	mutex sync.Mutex
+	hooks []func()
+}
+
+func (hooks *commitHooks) add(hook func()) {
+	hooks.mutex.Lock()
+	defer hooks.mutex.Unlock()
+	hooks.hooks = append(hooks.hooks, hook)
+}
+
+func (hooks *commitHooks) reset() {
+	hooks.mutex.Lock()
+	defer hooks.mutex.Unlock()
+	hooks.hooks = nil
+}
+
+func (hooks *commitHooks) run() {
+	hooks.mutex.Lock()
+	defer hooks.mutex.Unlock()
+	for _, hook := range hooks.hooks {
+		hook()
+	}
+	hooks.hooks = nil
+}
+
+// SupportsChangeStreams checks whether the collections can be watched, i.e. the database is a replica set or a sharded cluster
+func SupportsChangeStreams(ctx context.Context) bool {
+	return supportsTransactions(ctx)
+}
+
+// supportsTransactions checks once whether the database is a replica set or a sharded cluster
+func supportsTransactions(ctx context.Context) bool {
+	transactionsMutex.Lock()
+	defer transactionsMutex.Unlock()
+	if transactionsSupported != nil {
+		return *transactionsSupported
+	}
+	if mongoDbClient == nil {
+		return false
+	}
+	ctx, cancel := GetContext(ctx)
+	defer cancel()
+	var status struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := mongoDbClient.Database("admin").RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&status); err != nil {
+		// Checked again next time, the database may be unreachable for now
+		logging.FromContext(ctx).WithField("error", err.Error()).Error("Can't check whether the database supports transactions")
+		return false
+	}
+	supported := status.SetName != "" || status.Msg == "isdbgrid"
+	transactionsSupported = &supported
+	if !supported {
+		logging.Logger.WithField("uri", mongoDbURI).Warn("The database is a standalone server, the writes are done without transactions")
+	}
+	return supported
+}
+
+// Database Retrieve the database holding the data of a tenant (the default tenant is "")
+func Database(tenantID string) *mongo.Database {
+	if tenantID == "" || tenantIsolation == isolationByCollection {
+		return mongoDbClient.Database(mongoDbName)
+	}
+	return mongoDbClient.Database(mongoDbName + tenantSeparator + tenantID)
+}
+
+// GetCollection Retrieve the collection of an entity for a tenant
+func GetCollection(tenantID string, p Persistable) *mongo.Collection {
+	registerTenant(tenantID)
+	collection := collectionOf(tenantID, p)
+	if indexable, ok := p.(Indexable); ok {
+		ensureIndexes(collection, indexable)
+	}
+	return collection
+}
+
+// collectionOf returns the collection of an entity for a tenant
+func collectionOf(tenantID string, p Persistable) *mongo.Collection {
+	name := p.EntityName()
+	if tenantID != "" && tenantIsolation == isolationByCollection {
+		name = tenantID + tenantSeparator + name
+	}
+	return Database(tenantID).Collection(name)
+}
+
+// forgetIndexes makes the indexes be created again, once their collections were dropped
+func forgetIndexes() {
+	ensuredIndexes.Range(func(key, _ interface{}) bool {
+		ensuredIndexes.Delete(key)
+		return true
+	})
+}
+
+// ensureIndexes creates the indexes of a collection the first time it is used.
+// A failure is logged once, the indexes are created again by the migration.
+func ensureIndexes(collection *mongo.Collection, indexable Indexable) {
+	key := collection.Database().Name() + "." + collection.Name()
+	if _, done := ensuredIndexes.LoadOrStore(key, true); done {
+		return
+	}
+	if err := createIndexes(context.Background(), collection, indexable); err != nil {
+		logging.Logger.WithFields(logging.LogFields{
+			"collection": key,
+			"error":      err.Error(),
+		}).Error("Can't create the indexes, they are created again by the migration")
+	}
+}
+
+// EnsureIndexes creates the indexes of the collection of an entity for a tenant, even if they were already ensured
+func EnsureIndexes(ctx context.Context, tenantID string, p Persistable) error {
+	indexable, ok := p.(Indexable)
+	if !ok {
+		return nil
+	}
+	collection := collectionOf(tenantID, p)
+	if err := createIndexes(ctx, collection, indexable); err != nil {
+		return fmt.Errorf("Can't create the indexes of %s.%s: %s", collection.Database().Name(), collection.Name(), err)
+	}
+	ensuredIndexes.Store(collection.Database().Name()+"."+collection.Name(), true)
+	return nil
+}
+
+// createIndexes creates the indexes of a collection one by one, so that an index whose options have changed is reconciled
+func createIndexes(ctx context.Context, collection *mongo.Collection, indexable Indexable) error {
+	ctx, cancel := GetContext(ctx)
+	defer cancel()
+	for _, model := range indexable.Indexes() {
+		_, err := collection.Indexes().CreateOne(ctx, model)
+		if isIndexConflict(err) {
+			err = reconcileIndex(ctx, collection, model)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reconcileIndex replaces the index which has the name or the keys of a model but other options:
+// the retention of a TTL index is changed in place, the other indexes are dropped and created again
+func reconcileIndex(ctx context.Context, collection *mongo.Collection, model mongo.IndexModel) error {
+	keys, err := indexKeys(model.Keys)
+	if err != nil {
+		return err
+	}
+	name := keys
+	if model.Options != nil && model.Options.Name != nil {
+		name = *model.Options.Name
+	}
+	existing, err := conflictingIndex(ctx, collection, name, keys)
+	if err != nil {
+		return err
+	}
+	fields := logging.LogFields{"collection": collection.Database().Name() + "." + collection.Name(), "index": name}
+
+	if existing == name && model.Options != nil && model.Options.ExpireAfterSeconds != nil {
+		err := collection.Database().RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: collection.Name()},
+			{Key: "index", Value: bson.D{{Key: "name", Value: name}, {Key: "expireAfterSeconds", Value: *model.Options.ExpireAfterSeconds}}},
+		}).Err()
+		if err == nil {
+			// The index may differ by other options than its retention
+			if _, err := collection.Indexes().CreateOne(ctx, model); !isIndexConflict(err) {
+				logging.Logger.WithFields(fields).Info("The retention of the index was changed")
+				return err
+			}
+		}
+	}
+
+	if _, err := collection.Indexes().DropOne(ctx, existing); err != nil {
+		return fmt.Errorf("Can't drop the index %s, which conflicts with %s: %s", existing, name, err)
+	}
+	if _, err := collection.Indexes().CreateOne(ctx, model); err != nil {
+		return err
+	}
+	fields["replaced"] = existing
+	logging.Logger.WithFields(fields).Warn("The index was replaced")
+	return nil
+}
+
+// conflictingIndex finds the index of a collection which has the given name, or else the given keys
+func conflictingIndex(ctx context.Context, collection *mongo.Collection, name string, keys string) (string, error) {
+	cur, err := collection.Indexes().List(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer cur.Close(ctx)
+	sameKeys := ""
+	for cur.Next(ctx) {
+		var spec struct {
+			Name string `bson:"name"`
+			Key  bson.D `bson:"key"`
+		}
+		if err := cur.Decode(&spec); err != nil {
+			return "", err
+		}
+		if spec.Name == name {
+			return spec.Name, nil
+		}
+		if specKeys, err := indexKeys(spec.Key); err == nil && specKeys == keys {
+			sameKeys = spec.Name
+		}
+	}
+	if sameKeys == "" {
+		return "", fmt.Errorf("Can't find the index which conflicts with %s", name)
+	}
+	return sameKeys, nil
+}
+
+// indexKeys returns the keys of an index the way MongoDB names the index by default, e.g. "planid_1_timestamp_-1"
+func indexKeys(keys interface{}) (string, error) {
+	raw, err := bson.Marshal(keys)
+	if err != nil {
+		return "", err
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return "", err
+	}
+	parts := make([]string, 0, 2*len(doc))
+	for _, e := range doc {
+		parts = append(parts, e.Key, fmt.Sprintf("%v", e.Value))
+	}
+	return strings.Join(parts, "_"), nil
+}
+
+// isIndexConflict checks whether an index could not be created because one with the same name or keys has other options
+func isIndexConflict(err error) bool {
+	var commandError mongo.CommandError
+	return errors.As(err, &commandError) && (commandError.Code == indexOptionsConflictCode || commandError.Code == indexKeySpecsConflictCode)
+}
+
+// registerTenant records a tenant in the registry the first time this instance uses its data.
+// The tenants iterated and reset are the registered ones, not the databases or collections which look like theirs.
+func registerTenant(tenantID string) {
+	if tenantID == "" {
+		return
+	}
+	if _, done := registeredTenants.Load(tenantID); done {
+		return
+	}
+	ctx, cancel := GetContext(context.Background())
+	defer cancel()
+	_, err := Database("").Collection(tenantsCollection).UpdateOne(ctx,
+		bson.M{"_id": tenantID},
+		bson.M{"$setOnInsert": bson.M{"registeredat": time.Now().UTC()}},
+		options.Update().SetUpsert(true))
+	if err != nil && !IsDuplicateKey(err) {
+		logging.Logger.WithFields(logging.LogFields{
+			"tenant": tenantID,
+			"error":  err.Error(),
+		}).Error("Can't register the tenant, it is registered again when its data is next used")
+		return
+	}
+	registeredTenants.Store(tenantID, true)
+}
+
+// forgetTenants makes the tenants be registered again, once the registry was dropped
+func forgetTenants() {
+	registeredTenants.Range(func(key, _ interface{}) bool {
+		registeredTenants.Delete(key)
+		return true
+	})
+}
+
+// ListTenants Retrieve the registered tenants, the default one ("") first
+func ListTenants(ctx context.Context) ([]string, error) {
+	if err := checkConnection(ctx); err != nil {
+		return nil, err
+	}
+	ctx, cancel := GetContext(ctx)
+	defer cancel()
+	cur, err := Database("").Collection(tenantsCollection).Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("Can't list the tenants: %s", err)
+	}
+	defer cur.Close(ctx)
+	tenants := []string{""}
+	for cur.Next(ctx) {
+		var tenant struct {
+			ID string `bson:"_id"`
+		}
+		if err := cur.Decode(&tenant); err != nil {
+			return nil, fmt.Errorf("Can't list the tenants: %s", err)
+		}
+		tenants = append(tenants, tenant.ID)
+	}
+	return tenants, cur.Err()
+}
+
+// Register Register persisted entities, so that their collections are reset and migrated with the ones of the other entities
+func Register(p ...Persistable) {
+	entities = append(entities, p...)
+}
+
+// Entities Retrieve the registered entities
+func Entities() []Persistable {
+	return append([]Persistable{}, entities...)
+}
+
+// Indexable is implemented by the entities whose collection needs some indexes
+type Indexable interface {
+	Indexes() []mongo.IndexModel
 }
 
 type Persistable interface {
@@ -159,18 +671,18 @@ func ToBson(p Persistable) []byte {
 }
 
 // FindOne: Find one entity
-func FindOne(p Persistable) *Persistable {
+func FindOne(ctx context.Context, tenantID string, p Persistable) *Persistable {
 	id, err := primitive.ObjectIDFromHex(p.Id())
 	if err != nil {
-		logging.Logger.Error(fmt.Sprintf("Can't retrieve HEX ObjectId : %s", p.Id()))
+		logging.FromContext(ctx).Error(fmt.Sprintf("Can't retrieve HEX ObjectId : %s", p.Id()))
 		return nil
 	}
-	ctx, cancel := GetContext()
+	ctx, cancel := GetContext(ctx)
+	defer cancel()
 
-	sr := GetCollection(p).FindOne(ctx, bson.M{"_id": id, "planid": p.PlanId()})
+	sr := GetCollection(tenantID, p).FindOne(ctx, bson.M{"_id": id, "planid": p.PlanId()})
 	if sr.Err() != nil {
-		cancel()
-		logging.Logger.WithFields(logging.LogFields{
+		logging.FromContext(ctx).WithFields(logging.LogFields{
 			"stacktrace": sr.Err().Error(),
 		}).Error(fmt.Sprintf("Can't retrieve %s with id : %s and planId : %s", p.EntityName(), p.Id(), p.PlanId()))
 		return nil
@@ -180,13 +692,13 @@ func FindOne(p Persistable) *Persistable {
 }
 
 // InsertOne : Insert one entity
-func InsertOne(p Persistable) string {
+func InsertOne(ctx context.Context, tenantID string, p Persistable) string {
 	bson := ToBson(p)
-	ctx, cancel := GetContext()
-	res, errCol := GetCollection(p).InsertOne(ctx, bson)
+	ctx, cancel := GetContext(ctx)
+	defer cancel()
+	res, errCol := GetCollection(tenantID, p).InsertOne(ctx, bson)
 	if errCol != nil {
-		cancel()
-		logging.Logger.WithFields(logging.LogFields{
+		logging.FromContext(ctx).WithFields(logging.LogFields{
 			"collection": p.EntityName(),
 			"id":         p.Id(),
 			"planId":     p.PlanId(),
@@ -194,23 +706,23 @@ func InsertOne(p Persistable) string {
 		}).Error("Can't get collection instance")
 		return ""
 	}
-	logging.Logger.WithFields(logging.LogFields{"id": p.Id(), "planId": p.PlanId()}).Info(fmt.Sprintf("%s persisted", p.EntityName()))
+	logging.FromContext(ctx).WithFields(logging.LogFields{"id": p.Id(), "planId": p.PlanId()}).Info(fmt.Sprintf("%s persisted", p.EntityName()))
 	return res.InsertedID.(primitive.ObjectID).Hex()
 }
 
 // ReplaceOne : Replace one entity
-func ReplaceOne(p Persistable) bool {
+func ReplaceOne(ctx context.Context, tenantID string, p Persistable) bool {
 	id, err := primitive.ObjectIDFromHex(p.Id())
 	if err != nil {
-		logging.Logger.Error(fmt.Sprintf("Can't retrieve HEX ObjectId : %s", p.Id()))
+		logging.FromContext(ctx).Error(fmt.Sprintf("Can't retrieve HEX ObjectId : %s", p.Id()))
 		return false
 	}
 	toBson := ToBson(p)
-	ctx, cancel := GetContext()
-	_, errCol := GetCollection(p).ReplaceOne(ctx, bson.M{"_id": id, "planid": p.PlanId()}, toBson)
+	ctx, cancel := GetContext(ctx)
+	defer cancel()
+	_, errCol := GetCollection(tenantID, p).ReplaceOne(ctx, bson.M{"_id": id, "planid": p.PlanId()}, toBson)
 	if errCol != nil {
-		cancel()
-		logging.Logger.WithFields(logging.LogFields{
+		logging.FromContext(ctx).WithFields(logging.LogFields{
 			"collection": p.EntityName(),
 			"id":         p.Id(),
 			"planId":     p.PlanId(),
@@ -218,25 +730,40 @@ func ReplaceOne(p Persistable) bool {
 		}).Error("Can't get collection instance")
 		return false
 	}
-	logging.Logger.WithFields(logging.LogFields{"id": p.Id(), "planId": p.PlanId()}).Info(fmt.Sprintf("%s persisted", p.EntityName()))
+	logging.FromContext(ctx).WithFields(logging.LogFields{"id": p.Id(), "planId": p.PlanId()}).Info(fmt.Sprintf("%s persisted", p.EntityName()))
 	return true
 }
 
 // DeleteOne : Delete one entity
-func DeleteOne(p Persistable) bool {
+func DeleteOne(ctx context.Context, tenantID string, p Persistable) bool {
 	id, err := primitive.ObjectIDFromHex(p.Id())
 	if err != nil {
-		logging.Logger.Error(fmt.Sprintf("Can't retrieve HEX ObjectId : %s", p.Id()))
+		logging.FromContext(ctx).Error(fmt.Sprintf("Can't retrieve HEX ObjectId : %s", p.Id()))
 		return false
 	}
-	ctx, cancel := GetContext()
-	dr, err := GetCollection(p).DeleteOne(ctx, bson.M{"_id": id, "planid": p.PlanId()})
+	ctx, cancel := GetContext(ctx)
+	defer cancel()
+	dr, err := GetCollection(tenantID, p).DeleteOne(ctx, bson.M{"_id": id, "planid": p.PlanId()})
 	if err != nil {
-		cancel()
-		logging.Logger.WithFields(logging.LogFields{
+		logging.FromContext(ctx).WithFields(logging.LogFields{
 			"stacktrace": err.Error(),
 		}).Error(fmt.Sprintf("Can't delete %s with id : %s", p.EntityName(), p.Id()))
+		return false
 	}
 
 	return dr.DeletedCount == 1
 }
+
+// IsDuplicateKey checks whether an error was caused by a unique index
+func IsDuplicateKey(err error) bool {
+	var writeException mongo.WriteException
+	if errors.As(err, &writeException) {
+		for _, writeError := range writeException.WriteErrors {
+			if writeError.Code == duplicateKeyCode {
+				return true
+			}
+		}
+	}
+	var commandError mongo.CommandError
+	return errors.As(err, &commandError) && commandError.Code == duplicateKeyCode
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+
+	"gitlab.kardinal.ai/coretech/esther/logging"
+	"gitlab.kardinal.ai/coretech/esther/model"
+	"gitlab.kardinal.ai/coretech/esther/persistence"
+	"gitlab.kardinal.ai/coretech/esther/transport"
+)
+
+const (
+	defaultPort            = "8080"
+	defaultShutdownTimeout = 30 * time.Second
+)
+
+var (
+	inFlightApplies   sync.WaitGroup
+	backgroundWorkers sync.WaitGroup
+	draining          int32
+)
+
+// trackApply makes the shutdown wait for the applications in progress
+func trackApply(c *gin.Context) {
+	inFlightApplies.Add(1)
+	defer inFlightApplies.Done()
+	c.Next()
+}
+
+// resumeApplyRuns resumes in the background the applications interrupted by a previous shutdown or crash
+func resumeApplyRuns(ctx context.Context) {
+	inFlightApplies.Add(1)
+	go func() {
+		defer inFlightApplies.Done()
+		model.ResumeApplyRuns(ctx)
+	}()
+}
+
+// runInBackground runs a worker until ctx is done, the shutdown waits for the work in progress (e.g. a publication)
+func runInBackground(ctx context.Context, worker func(ctx context.Context)) {
+	backgroundWorkers.Add(1)
+	go func() {
+		defer backgroundWorkers.Done()
+		worker(ctx)
+	}()
+}
+
+func isDraining() bool {
+	return atomic.LoadInt32(&draining) == 1
+}
+
+// serve runs the server, and the gRPC server on GRPC_PORT, until SIGTERM or SIGINT, then drains them.
+// The requests still in progress at the end of SHUTDOWN_TIMEOUT are canceled through baseCtx,
+// so that the applications stop after the event callback being applied.
+func serve(handler http.Handler, baseCtx context.Context, cancelBase context.CancelFunc) {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = defaultPort
+	}
+	grpcPort := os.Getenv("GRPC_PORT")
+	if grpcPort == "" {
+		grpcPort = defaultGRPCPort
+	}
+	shutdownTimeout := defaultShutdownTimeout
+	if value := os.Getenv("SHUTDOWN_TIMEOUT"); value != "" {
+		if timeout, err := time.ParseDuration(value); err != nil || timeout < 0 {
+			logging.Logger.WithField("value", value).Error("SHUTDOWN_TIMEOUT must be a positive duration")
+		} else {
+			shutdownTimeout = timeout
+		}
+	}
+
+	srv := &http.Server{
+		Addr:        ":" + port,
+		Handler:     handler,
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
+	}
+	srv.RegisterOnShutdown(closeStreams)
+	go func() {
+		logging.Logger.WithField("port", port).Info("Listening")
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logging.Logger.WithField("error", err).Fatal("The server has failed")
+		}
+	}()
+	grpcSrv := newGRPCServer(baseCtx)
+	go func() {
+		listener, err := net.Listen("tcp", ":"+grpcPort)
+		if err != nil {
+			logging.Logger.WithField("error", err).Fatal("The gRPC server could not listen")
+		}
+		logging.Logger.WithField("port", grpcPort).Info("Listening for gRPC")
+		if err := grpcSrv.Serve(listener); err != nil && err != grpc.ErrServerStopped {
+			logging.Logger.WithField("error", err).Fatal("The gRPC server has failed")
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-quit
+	atomic.StoreInt32(&draining, 1)
+	logging.Logger.WithFields(logging.LogFields{
+		"signal":  sig.String(),
+		"timeout": shutdownTimeout.String(),
+	}).Info("Shutting down, draining the requests in progress")
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	grpcStopped := make(chan struct{})
+	go func() {
+		grpcSrv.GracefulStop()
+		close(grpcStopped)
+	}()
+	if err := srv.Shutdown(ctx); err != nil {
+		logging.Logger.WithField("error", err).Warn("The requests in progress were not done in time, canceling them")
+	}
+	select {
+	case <-grpcStopped:
+	case <-ctx.Done():
+		logging.Logger.Warn("The gRPC calls in progress were not done in time, canceling them")
+	}
+	cancelBase()
+	grpcSrv.Stop()
+	inFlightApplies.Wait()
+	backgroundWorkers.Wait()
+	transport.Close()
+
+	disconnectCtx, cancelDisconnect := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelDisconnect()
+	if err := persistence.Disconnect(disconnectCtx); err != nil {
+		logging.Logger.WithField("error", err).Error("The database could not be disconnected")
+	}
+	logging.Logger.Info("Esther has stopped")
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"gitlab.kardinal.ai/coretech/esther/logging"
+	"gitlab.kardinal.ai/coretech/esther/model"
+)
+
+const defaultStreamHeartbeat = 15 * time.Second
+
+var (
+	streamHeartbeat time.Duration
+
+	// streamsClosing is closed when the server shuts down, the streams never end by themselves
+	streamsClosing   = make(chan struct{})
+	closeStreamsOnce sync.Once
+
+	// The default upgrader rejects the cross-origin WebSocket requests
+	upgrader = websocket.Upgrader{}
+)
+
+func init() {
+	initStreamEnv()
+}
+
+func initStreamEnv() []string {
+	errs := []string{}
+	streamHeartbeat = defaultStreamHeartbeat
+	envHeartbeat := "STREAM_HEARTBEAT_INTERVAL"
+	if value := os.Getenv(envHeartbeat); value != "" {
+		if heartbeat, err := time.ParseDuration(value); err != nil || heartbeat <= 0 {
+			errs = append(errs, fmt.Sprintf("%s must be a positive duration", envHeartbeat))
+		} else {
+			streamHeartbeat = heartbeat
+		}
+	}
+	if len(errs) > 0 {
+		logging.Logger.WithField("errors", errs).Error("The stream environment is not properly set")
+	}
+	return errs
+}
+
+// closeStreams ends the streams in progress, so that they do not hold the shutdown
+func closeStreams() {
+	closeStreamsOnce.Do(func() { close(streamsClosing) })
+}
+
+// streamPlanEvents pushes the lifecycle events of the event callbacks of a plan, with Server-Sent Events or over a WebSocket.
+// A client resumes its stream from the last event it has received with the Last-Event-ID header or the lastEventId parameter.
+func streamPlanEvents(c *gin.Context) {
+	planID := c.Param("planId")
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("lastEventId")
+	}
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	events, err := model.SubscribePlanEvents(ctx, scope(c), planID, lastEventID)
+	if errors.Is(err, model.ErrInvalidEventID) {
+		abortWithError(c, http.StatusBadRequest, err, "The stream could not be resumed")
+		return
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err, "The stream could not be started")
+		return
+	}
+
+	if websocket.IsWebSocketUpgrade(c.Request) {
+		streamWebSocket(c, cancel, events)
+		return
+	}
+	streamServerSentEvents(c, events)
+}
+
+func streamServerSentEvents(c *gin.Context, events <-chan model.OutboxEvent) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// Disables the buffering of the reverse proxies, e.g. nginx
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				logging.FromContext(c.Request.Context()).WithField("error", err).Error("The plan event could not be encoded")
+				continue
+			}
+			fmt.Fprintf(c.Writer, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": heartbeat\n\n")
+		case <-streamsClosing:
+			return
+		case <-c.Request.Context().Done():
+			return
+		}
+		c.Writer.Flush()
+	}
+}
+
+func streamWebSocket(c *gin.Context, cancel context.CancelFunc, events <-chan model.OutboxEvent) {
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already responded
+		logging.FromContext(c.Request.Context()).WithField("error", err).Warn("The WebSocket could not be opened")
+		return
+	}
+	defer conn.Close()
+	// The messages of the client are only read to handle the control frames and to notice when it leaves
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				// The stream is broken, the client resumes it from its last event
+				closeWebSocket(conn, websocket.CloseTryAgainLater)
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(streamHeartbeat))
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamHeartbeat)); err != nil {
+				return
+			}
+		case <-streamsClosing:
+			closeWebSocket(conn, websocket.CloseGoingAway)
+			return
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+func closeWebSocket(conn *websocket.Conn, code int) {
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, ""), time.Now().Add(time.Second))
+}
@@ -0,0 +1,100 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/propagators"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+
+	"gitlab.kardinal.ai/coretech/esther/logging"
+)
+
+const (
+	instrumentationName = "gitlab.kardinal.ai/coretech/esther"
+	defaultServiceName  = "esther"
+)
+
+var (
+	serviceName string
+	// HTTPClient propagates the current trace (W3C traceparent) in its outbound requests
+	HTTPClient = &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
+)
+
+func init() {
+	global.SetTextMapPropagator(otel.NewCompositeTextMapPropagator(propagators.TraceContext{}, propagators.Baggage{}))
+}
+
+// Init exports the spans to the OTLP collector set in OTEL_EXPORTER_OTLP_ENDPOINT, if any.
+// The returned function flushes the pending spans.
+func Init() (func(), error) {
+	serviceName = os.Getenv("OTEL_SERVICE_NAME")
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	if endpoint == "" {
+		logging.Logger.Info("OTEL_EXPORTER_OTLP_ENDPOINT is not set, the traces are not exported")
+		return func() {}, nil
+	}
+
+	exporter, err := otlp.NewExporter(otlp.WithInsecure(), otlp.WithAddress(endpoint))
+	if err != nil {
+		return func() {}, err
+	}
+	processor := sdktrace.NewBatchSpanProcessor(exporter)
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithConfig(sdktrace.Config{DefaultSampler: sdktrace.ParentBased(sdktrace.AlwaysSample())}),
+		sdktrace.WithResource(resource.New(semconv.ServiceNameKey.String(serviceName))),
+		sdktrace.WithSpanProcessor(processor),
+	)
+	global.SetTracerProvider(provider)
+	logging.Logger.WithFields(logging.LogFields{
+		"endpoint": endpoint,
+		"service":  serviceName,
+	}).Info("The traces are exported to the OTLP collector")
+
+	return func() {
+		processor.Shutdown()
+		if err := exporter.Shutdown(context.Background()); err != nil {
+			logging.Logger.WithField("error", err).Error("The OTLP exporter could not be shut down")
+		}
+	}, nil
+}
+
+// ServiceName returns the name of the service in the traces
+func ServiceName() string {
+	if serviceName == "" {
+		return defaultServiceName
+	}
+	return serviceName
+}
+
+// Start starts a span as a child of the span of the given ctx
+func Start(ctx context.Context, name string, attributes ...label.KeyValue) (context.Context, trace.Span) {
+	return global.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// End ends a span, recording the error which occurred during it if any
+func End(ctx context.Context, span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(ctx, err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Inject propagates the current trace in the headers of an outbound message
+func Inject(ctx context.Context, headers map[string][]string) {
+	global.TextMapPropagator().Inject(ctx, http.Header(headers))
+}
@@ -0,0 +1,51 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"gitlab.kardinal.ai/coretech/esther/tracing"
+)
+
+// MaxResponseBodyLength is the maximum length of a response body kept after a message was sent
+const MaxResponseBodyLength = 4096
+
+type httpTransport struct {
+	client *http.Client
+}
+
+func newHTTPTransport() *httpTransport {
+	return &httpTransport{client: tracing.HTTPClient}
+}
+
+// Send sends the message as an HTTP request, whatever the status of the response
+func (transport *httpTransport) Send(ctx context.Context, message Message) (Result, error) {
+	req, err := http.NewRequestWithContext(ctx, message.Method, message.URI, bytes.NewBuffer(message.Payload))
+	if err != nil {
+		return Result{}, err
+	}
+	for key, values := range message.Headers {
+		req.Header[key] = values
+	}
+
+	resp, err := transport.client.Do(req)
+	if err != nil {
+		return Result{}, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, MaxResponseBodyLength))
+	return Result{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Body:       body,
+	}, nil
+}
+
+func (transport *httpTransport) Close() error {
+	transport.client.CloseIdleConnections()
+	return nil
+}
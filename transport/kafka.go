@@ -0,0 +1,84 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+
+	"gitlab.kardinal.ai/coretech/esther/tracing"
+)
+
+type kafkaTransport struct {
+	brokers []string
+
+	mutex   sync.Mutex
+	writers map[string]*kafka.Writer
+}
+
+// newKafkaTransport produces to the brokers set in KAFKA_BROKERS (comma-separated host:port)
+func newKafkaTransport() (*kafkaTransport, error) {
+	brokers := []string{}
+	for _, broker := range strings.Split(os.Getenv("KAFKA_BROKERS"), ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			brokers = append(brokers, broker)
+		}
+	}
+	if len(brokers) == 0 {
+		return nil, fmt.Errorf("KAFKA_BROKERS is not set, the kafka transport is disabled")
+	}
+	return &kafkaTransport{brokers: brokers, writers: map[string]*kafka.Writer{}}, nil
+}
+
+// writer returns the writer of a topic, the messages of a key go to the same partition
+func (transport *kafkaTransport) writer(topic string) *kafka.Writer {
+	transport.mutex.Lock()
+	defer transport.mutex.Unlock()
+	writer, ok := transport.writers[topic]
+	if !ok {
+		writer = &kafka.Writer{
+			Addr:         kafka.TCP(transport.brokers...),
+			Topic:        topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			// The messages are sent one by one, as soon as they are written
+			BatchSize: 1,
+		}
+		transport.writers[topic] = writer
+	}
+	return writer
+}
+
+// Send produces the message to its topic, it is sent once all the in-sync replicas have received it
+func (transport *kafkaTransport) Send(ctx context.Context, message Message) (Result, error) {
+	headers := http.Header{}
+	for key, values := range message.Headers {
+		headers[key] = values
+	}
+	tracing.Inject(ctx, headers)
+	msg := kafka.Message{Key: []byte(message.Key), Value: message.Payload}
+	for key := range headers {
+		msg.Headers = append(msg.Headers, kafka.Header{Key: key, Value: []byte(headers.Get(key))})
+	}
+	if err := transport.writer(message.Topic).WriteMessages(ctx, msg); err != nil {
+		return Result{}, err
+	}
+	return Result{Status: "published"}, nil
+}
+
+func (transport *kafkaTransport) Close() error {
+	transport.mutex.Lock()
+	defer transport.mutex.Unlock()
+	var lastErr error
+	for topic, writer := range transport.writers {
+		if err := writer.Close(); err != nil {
+			lastErr = err
+		}
+		delete(transport.writers, topic)
+	}
+	return lastErr
+}
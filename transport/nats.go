@@ -0,0 +1,63 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/nats-io/nats.go"
+
+	"gitlab.kardinal.ai/coretech/esther/logging"
+	"gitlab.kardinal.ai/coretech/esther/tracing"
+)
+
+type natsTransport struct {
+	conn *nats.Conn
+}
+
+// newNATSTransport connects to the NATS servers set in NATS_URL
+func newNATSTransport() (*natsTransport, error) {
+	url := os.Getenv("NATS_URL")
+	if url == "" {
+		return nil, fmt.Errorf("NATS_URL is not set, the nats transport is disabled")
+	}
+	conn, err := nats.Connect(url,
+		nats.Name("esther"),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			logging.Logger.WithField("error", err).Warn("Disconnected from NATS")
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			logging.Logger.WithField("url", conn.ConnectedUrl()).Info("Reconnected to NATS")
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Can't connect to NATS: %s", err)
+	}
+	logging.Logger.WithField("url", conn.ConnectedUrl()).Info("Connected to NATS")
+	return &natsTransport{conn: conn}, nil
+}
+
+// Send publishes the message on its topic (the NATS subject), it is sent once the server has received it
+func (transport *natsTransport) Send(ctx context.Context, message Message) (Result, error) {
+	msg := nats.NewMsg(message.Topic)
+	msg.Data = message.Payload
+	for key, values := range message.Headers {
+		msg.Header[key] = values
+	}
+	tracing.Inject(ctx, msg.Header)
+	if message.Key != "" {
+		msg.Header.Set("Nats-Msg-Key", message.Key)
+	}
+	if err := transport.conn.PublishMsg(msg); err != nil {
+		return Result{}, err
+	}
+	if err := transport.conn.FlushWithContext(ctx); err != nil {
+		return Result{}, err
+	}
+	return Result{Status: "published"}, nil
+}
+
+func (transport *natsTransport) Close() error {
+	return transport.conn.Drain()
+}
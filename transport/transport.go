@@ -0,0 +1,108 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"gitlab.kardinal.ai/coretech/esther/logging"
+)
+
+// Names of the transports
+const (
+	HTTP  = "http"
+	NATS  = "nats"
+	Kafka = "kafka"
+)
+
+// Message is what an action sends to its target
+type Message struct {
+	// Method and URI are used by the http transport
+	Method string
+	URI    string
+	// Topic and Key are used by the message-queue transports, the key selects the Kafka partition
+	Topic   string
+	Key     string
+	Headers http.Header
+	Payload []byte
+}
+
+// Result is what the target of a message responded, the message-queue transports only acknowledge the publication
+type Result struct {
+	StatusCode int
+	Status     string
+	Body       []byte
+}
+
+// Transport sends messages to their target
+type Transport interface {
+	Send(ctx context.Context, message Message) (Result, error)
+	Close() error
+}
+
+var (
+	transportsMutex sync.Mutex
+	transports      = map[string]Transport{}
+)
+
+// Get returns a transport by name, the http transport being the default one.
+// The connections to the brokers are opened the first time their transport is used.
+func Get(name string) (Transport, error) {
+	if name == "" {
+		name = HTTP
+	}
+	transportsMutex.Lock()
+	defer transportsMutex.Unlock()
+	if transport, ok := transports[name]; ok {
+		return transport, nil
+	}
+	var transport Transport
+	var err error
+	switch name {
+	case HTTP:
+		transport = newHTTPTransport()
+	case NATS:
+		transport, err = newNATSTransport()
+	case Kafka:
+		transport, err = newKafkaTransport()
+	default:
+		return nil, fmt.Errorf("Unknown transport: %s", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	transports[name] = transport
+	return transport, nil
+}
+
+// Validate checks that a transport exists and that its messages have a target
+func Validate(name string, topic string) error {
+	switch name {
+	case "", HTTP:
+		return nil
+	case NATS, Kafka:
+		if topic == "" {
+			return fmt.Errorf("The %s transport requires a topic", name)
+		}
+		return nil
+	}
+	return fmt.Errorf("Unknown transport %s, expected %s, %s or %s", name, HTTP, NATS, Kafka)
+}
+
+// IsMessageQueue checks whether a transport publishes to a broker
+func IsMessageQueue(name string) bool {
+	return name == NATS || name == Kafka
+}
+
+// Close closes the connections of the transports which were used
+func Close() {
+	transportsMutex.Lock()
+	defer transportsMutex.Unlock()
+	for name, transport := range transports {
+		if err := transport.Close(); err != nil {
+			logging.Logger.WithFields(logging.LogFields{"transport": name, "error": err}).Error("The transport could not be closed")
+		}
+		delete(transports, name)
+	}
+}
@@ -0,0 +1,184 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+)
+
+// The broker-backed tests run against the brokers of NATS_URL and KAFKA_BROKERS, e.g. the ones of docker-compose.override.yml.dist,
+// and are skipped when they are not set
+
+func testMessage(topic string) Message {
+	return Message{
+		Topic:   topic,
+		Key:     "plan-42",
+		Headers: http.Header{"Idempotency-Key": []string{"ec-1-parent"}, "X-Request-Id": []string{"req-1"}},
+		Payload: []byte(`{"state": "applied"}`),
+	}
+}
+
+// testTopic returns a topic of its own to each run, so that the messages of a previous run are not read
+func testTopic(name string) string {
+	return fmt.Sprintf("esther-test-%s-%d", name, time.Now().UnixNano())
+}
+
+func TestNATSTransport(t *testing.T) {
+	url := os.Getenv("NATS_URL")
+	if url == "" {
+		t.Skip("NATS_URL is not set")
+	}
+	defer Close()
+	conn, err := nats.Connect(url)
+	if err != nil {
+		t.Fatalf("Can't connect to NATS: %s", err)
+	}
+	defer conn.Close()
+	message := testMessage(testTopic("nats"))
+	sub, err := conn.SubscribeSync(message.Topic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	transport, err := Get(NATS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := transport.Send(ctx, message)
+	if err != nil {
+		t.Fatalf("The message was not published: %s", err)
+	}
+	if result.Status != "published" {
+		t.Errorf("The status is %s, instead of published", result.Status)
+	}
+
+	received, err := sub.NextMsgWithContext(ctx)
+	if err != nil {
+		t.Fatalf("The message was not received: %s", err)
+	}
+	if string(received.Data) != string(message.Payload) {
+		t.Errorf("The payload %s was received, instead of %s", received.Data, message.Payload)
+	}
+	if key := received.Header.Get("Nats-Msg-Key"); key != message.Key {
+		t.Errorf("The key %s was received, instead of %s", key, message.Key)
+	}
+	for key := range message.Headers {
+		if received.Header.Get(key) != message.Headers.Get(key) {
+			t.Errorf("The header %s is %q, instead of %q", key, received.Header.Get(key), message.Headers.Get(key))
+		}
+	}
+}
+
+func TestKafkaTransport(t *testing.T) {
+	brokers := os.Getenv("KAFKA_BROKERS")
+	if brokers == "" {
+		t.Skip("KAFKA_BROKERS is not set")
+	}
+	broker := strings.TrimSpace(strings.Split(brokers, ",")[0])
+	defer Close()
+	message := testMessage(testTopic("kafka"))
+	createKafkaTopic(t, broker, message.Topic)
+
+	transport, err := Get(Kafka)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	result, err := transport.Send(ctx, message)
+	if err != nil {
+		t.Fatalf("The message was not produced: %s", err)
+	}
+	if result.Status != "published" {
+		t.Errorf("The status is %s, instead of published", result.Status)
+	}
+
+	reader := kafka.NewReader(kafka.ReaderConfig{Brokers: []string{broker}, Topic: message.Topic})
+	defer reader.Close()
+	received, err := reader.ReadMessage(ctx)
+	if err != nil {
+		t.Fatalf("The message was not consumed: %s", err)
+	}
+	if string(received.Value) != string(message.Payload) {
+		t.Errorf("The payload %s was consumed, instead of %s", received.Value, message.Payload)
+	}
+	if string(received.Key) != message.Key {
+		t.Errorf("The key %s was consumed, instead of %s", received.Key, message.Key)
+	}
+	headers := http.Header{}
+	for _, header := range received.Headers {
+		headers.Set(header.Key, string(header.Value))
+	}
+	for key := range message.Headers {
+		if headers.Get(key) != message.Headers.Get(key) {
+			t.Errorf("The header %s is %q, instead of %q", key, headers.Get(key), message.Headers.Get(key))
+		}
+	}
+}
+
+// createKafkaTopic creates a topic with a single partition, so that it is read from its first offset without a consumer group
+func createKafkaTopic(t *testing.T, broker string, topic string) {
+	conn, err := kafka.Dial("tcp", broker)
+	if err != nil {
+		t.Fatalf("Can't connect to Kafka: %s", err)
+	}
+	defer conn.Close()
+	controller, err := conn.Controller()
+	if err != nil {
+		t.Fatal(err)
+	}
+	controllerConn, err := kafka.Dial("tcp", net.JoinHostPort(controller.Host, strconv.Itoa(controller.Port)))
+	if err != nil {
+		t.Fatalf("Can't connect to the Kafka controller: %s", err)
+	}
+	defer controllerConn.Close()
+	if err := controllerConn.CreateTopics(kafka.TopicConfig{Topic: topic, NumPartitions: 1, ReplicationFactor: 1}); err != nil {
+		t.Fatalf("Can't create the topic %s: %s", topic, err)
+	}
+}
+
+func TestBrokersNotSet(t *testing.T) {
+	for name, env := range map[string]string{NATS: "NATS_URL", Kafka: "KAFKA_BROKERS"} {
+		if os.Getenv(env) != "" {
+			continue
+		}
+		if _, err := Get(name); err == nil {
+			t.Errorf("The %s transport was created without %s", name, env)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		topic string
+		valid bool
+	}{
+		{"", "", true},
+		{HTTP, "", true},
+		{NATS, "plans", true},
+		{NATS, "", false},
+		{Kafka, "plans", true},
+		{Kafka, "", false},
+		{"smtp", "plans", false},
+	}
+	for _, test := range tests {
+		if err := Validate(test.name, test.topic); (err == nil) != test.valid {
+			t.Errorf("Validate(%q, %q) = %v", test.name, test.topic, err)
+		}
+	}
+}